@@ -8,8 +8,15 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	config "github.com/pochkachaiki/iot4gds/internal/config/data_simulator"
+	"github.com/pochkachaiki/iot4gds/internal/downlink"
+	"github.com/pochkachaiki/iot4gds/internal/fault"
+	"github.com/pochkachaiki/iot4gds/internal/loadtest"
+	"github.com/pochkachaiki/iot4gds/internal/replay"
+	"github.com/pochkachaiki/iot4gds/internal/scenario"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
 	"github.com/pochkachaiki/iot4gds/internal/sensor"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -25,10 +32,12 @@ func main() {
 	cfg := config.MustLoad()
 
 	slog.Info("starting simulator",
+		"mode", cfg.Mode,
 		"device_number", cfg.DeviceNumber,
 		"msg_period", cfg.MsgPeriod,
 		"iot_system_url", cfg.IotSystemUrl,
-		"metrics_addr", cfg.MetricsAddr)
+		"metrics_addr", cfg.MetricsAddr,
+		"buffer_dir", cfg.BufferDir)
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -40,21 +49,142 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
-	for id := 1; id <= cfg.DeviceNumber; id++ {
+	cc := cfg.ClientConfig()
+	if cfg.Mode == config.ModeLoadTest {
+		// повторы искажают задержки и маскируют ошибки — каждая попытка меряется отдельно
+		cc.MaxRetries = 0
+	}
+	client := sender.NewClient(cc)
+	var s sender.PacketSender = client
+
+	if cfg.BufferDir != "" {
+		buf, err := sender.OpenBuffer(cfg.BufferDir, cfg.BufferSegmentBytes, cfg.BufferMaxBytes)
+		if err != nil {
+			slog.Error("open buffer error", "dir", cfg.BufferDir, "err", err)
+			os.Exit(1)
+		}
+		defer buf.Close()
+
+		fwd := sender.NewForwarder(s, buf, cfg.ReplayMinBackoff, cfg.ReplayMaxBackoff)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fwd.Run(ctx)
+		}()
+		s = fwd
+	}
+
+	if fc := cfg.FaultConfig(); fc.Enabled() {
+		slog.Info("fault injection enabled", "faults", fc)
+		s = fault.NewInjector(fc, s, client)
+	}
+
+	// done закрывается, когда все источники данных исчерпаны (режимы replay и loadtest)
+	done := make(chan struct{})
+
+	switch {
+	case cfg.Mode == config.ModeLoadTest:
+		runner := loadtest.NewRunner(cfg.LoadStages(), s)
 		wg.Add(1)
-		go func(id int) {
+		go func() {
 			defer wg.Done()
-			sensor.Run(ctx, cfg, id)
-		}(id)
+			summary := runner.Run(ctx)
+			summary.Print(os.Stdout)
+			slog.Info("load test finished", "requests", summary.Requests, "throughput", summary.Throughput,
+				"error_rate", summary.ErrorRate, "p50", summary.P50, "p95", summary.P95, "p99", summary.P99)
+			close(done)
+		}()
+	case cfg.Mode == config.ModeReplay:
+		var replayWg sync.WaitGroup
+		runReplay(ctx, cfg, s, &replayWg)
+		go func() {
+			replayWg.Wait()
+			close(done)
+		}()
+	case cfg.ScenarioPath != "":
+		sc, err := scenario.Load(cfg.ScenarioPath)
+		if err != nil {
+			slog.Error("load scenario error", "path", cfg.ScenarioPath, "err", err)
+			os.Exit(1)
+		}
+		if cfg.Seed != 0 {
+			sc.Seed = cfg.Seed
+		}
+		slog.Info("running scenario", "path", cfg.ScenarioPath, "seed", sc.Seed,
+			"devices", len(sc.Devices), "duration", sc.Duration)
+
+		for _, dev := range sc.NewDevices() {
+			model, err := sensor.ModelFor(cfg, dev.ID)
+			if err != nil {
+				slog.Error("signal model error", "err", err)
+				os.Exit(1)
+			}
+			wg.Add(1)
+			go func(dev *scenario.Device) {
+				defer wg.Done()
+				sensor.RunScenario(ctx, cfg, dev, model, sc.Duration, s)
+			}(dev)
+		}
+	default:
+		var commands *downlink.Client
+		if cfg.CommandsURL != "" {
+			commands = downlink.NewClient(cfg.CommandsURL, cfg.CommandWait)
+		}
+
+		for id := 1; id <= cfg.DeviceNumber; id++ {
+			model, err := sensor.ModelFor(cfg, id)
+			if err != nil {
+				slog.Error("signal model error", "err", err)
+				os.Exit(1)
+			}
+			var ctrl *sensor.Control
+			if commands != nil {
+				ctrl = sensor.NewControl(time.Duration(cfg.MsgPeriod) * time.Second)
+				wg.Add(1)
+				go func(id int) {
+					defer wg.Done()
+					downlink.Run(ctx, commands, id, cfg.CommandWait, ctrl.Execute)
+				}(id)
+			}
+
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				sensor.Run(ctx, cfg, id, model, ctrl, s)
+			}(id)
+		}
 	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
-
-	slog.Info("shutdown signal received")
+	select {
+	case <-sigCh:
+		slog.Info("shutdown signal received")
+	case <-done:
+		slog.Info("simulation finished")
+	}
 	cancel()
 	wg.Wait()
 
 	slog.Info("simulator stopped")
 }
+
+func runReplay(ctx context.Context, cfg *config.Config, s sender.PacketSender, wg *sync.WaitGroup) {
+	opts := replay.Options{Speed: cfg.ReplaySpeed, RewriteTime: cfg.ReplayRewriteTime}
+
+	// записи разных станций воспроизводятся параллельно, каждая в своём темпе
+	for _, path := range cfg.ReplayFiles {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			slog.InfoContext(ctx, "replay started", "file", path, "speed", opts.Speed, "rewrite_time", opts.RewriteTime)
+
+			stats, err := replay.RunFile(ctx, path, cfg.ReplayFormat, cfg.ReplayMapping(), s, opts)
+			if err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "replay error", "file", path, "err", err)
+			}
+			slog.InfoContext(ctx, "replay stopped", "file", path,
+				"sent", stats.Sent, "failed", stats.Failed, "skipped", stats.Skipped)
+		}(path)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/iot_controller"
+	"github.com/pochkachaiki/iot4gds/internal/export"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+type options struct {
+	configPath string
+	out        string
+	req        export.Request
+}
+
+func parseFlags() (*options, error) {
+	var (
+		opts             options
+		kind, format     string
+		from, to, device string
+	)
+	flag.StringVar(&opts.configPath, "config", os.Getenv("CONFIG_PATH"), "path to iot controller config")
+	flag.StringVar(&opts.out, "out", "", "output directory; rerun with the same directory to resume")
+	flag.StringVar(&kind, "kind", string(export.Packets), "what to export: packets or alerts")
+	flag.StringVar(&format, "format", string(export.CSV), "file format: csv, ndjson or parquet")
+	flag.StringVar(&from, "from", "", "start of the period, RFC3339 (inclusive)")
+	flag.StringVar(&to, "to", "", "end of the period, RFC3339 (exclusive)")
+	flag.StringVar(&device, "devices", "", "comma separated device ids, all devices when empty")
+	flag.DurationVar(&opts.req.Chunk, "chunk", export.DefaultChunk, "period covered by one output file")
+	flag.BoolVar(&opts.req.Gzip, "gzip", false, "compress output files with gzip")
+	flag.Parse()
+
+	if opts.configPath == "" {
+		return nil, fmt.Errorf("-config or CONFIG_PATH is required")
+	}
+	if opts.out == "" {
+		return nil, fmt.Errorf("-out is required")
+	}
+	opts.req.Kind = export.Kind(kind)
+	opts.req.Format = export.Format(format)
+
+	var err error
+	if opts.req.From, err = time.Parse(time.RFC3339, from); err != nil {
+		return nil, fmt.Errorf("-from: %w", err)
+	}
+	if opts.req.To, err = time.Parse(time.RFC3339, to); err != nil {
+		return nil, fmt.Errorf("-to: %w", err)
+	}
+
+	if device != "" {
+		for _, s := range strings.Split(device, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("-devices: %w", err)
+			}
+			opts.req.Devices = append(opts.req.Devices, id)
+		}
+	}
+
+	if opts.req, err = opts.req.Normalize(); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	opts, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *options) error {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client, err := storage.NewMongoClient(cfg.MongoURI)
+	if err != nil {
+		return fmt.Errorf("mongo connect: %w", err)
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database(cfg.DBName)
+	if err := storage.CheckSchema(ctx, db); err != nil {
+		return fmt.Errorf("%w (start the controller or rule engine to migrate)", err)
+	}
+
+	exporter := export.New(export.NewMongoSource(db.Collection(cfg.PacketCollection), db.Collection(cfg.AlertCollection)))
+
+	// прогресс печатается не чаще раза в секунду и обязательно по завершении куска
+	var (
+		last      time.Time
+		lastChunk int
+	)
+	m, err := exporter.Run(ctx, opts.out, opts.req, func(p export.Progress) {
+		if time.Since(last) < time.Second && p.ChunksDone == lastChunk {
+			return
+		}
+		last, lastChunk = time.Now(), p.ChunksDone
+		fmt.Fprintf(os.Stderr, "chunks %d/%d, records %d, %s written\n", p.ChunksDone, p.ChunksTotal, p.Records, formatBytes(p.Bytes))
+	})
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("interrupted, rerun with the same -out to resume")
+	}
+	if err != nil {
+		return err
+	}
+
+	total := m.Progress()
+	fmt.Printf("exported %d %s into %d files in %s (%s)\n", total.Records, opts.req.Kind, len(m.Chunks), opts.out, formatBytes(total.Bytes))
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
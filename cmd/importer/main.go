@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/iot_controller"
+	"github.com/pochkachaiki/iot4gds/internal/importer"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+type options struct {
+	configPath  string
+	format      string
+	mapping     packetio.Mapping
+	batchSize   int
+	maxErrors   int
+	dryRun      bool
+	publish     bool
+	keepExpired bool
+	files       []string
+}
+
+func parseFlags() (*options, error) {
+	var opts options
+	flag.StringVar(&opts.configPath, "config", os.Getenv("CONFIG_PATH"), "path to iot controller config")
+	flag.StringVar(&opts.format, "format", "", "csv or ndjson; detected by file extension when empty")
+	flag.StringVar(&opts.mapping.DeviceID, "device-field", packetio.DefaultMapping.DeviceID, "name of the device id field")
+	flag.StringVar(&opts.mapping.Timestamp, "time-field", packetio.DefaultMapping.Timestamp, "name of the timestamp field")
+	flag.StringVar(&opts.mapping.Pressure, "pressure-field", packetio.DefaultMapping.Pressure, "name of the pressure field")
+	flag.StringVar(&opts.mapping.Temperature, "temperature-field", packetio.DefaultMapping.Temperature, "name of the temperature field")
+	flag.StringVar(&opts.mapping.TimeLayout, "time-layout", "", `timestamp layout: empty for RFC3339, "unix" for epoch seconds or a Go time layout`)
+	flag.IntVar(&opts.batchSize, "batch", 1000, "packets per InsertMany")
+	flag.IntVar(&opts.maxErrors, "max-errors", 100, "abort a file after this many invalid records, 0 for no limit")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "validate and count without writing anything")
+	flag.BoolVar(&opts.publish, "publish", false, "publish imported packets to the rule engine queue")
+	flag.BoolVar(&opts.keepExpired, "keep-expired", false, "import records older than raw retention; TTL removes them soon, only rollups remain")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if opts.configPath == "" {
+		return nil, fmt.Errorf("-config or CONFIG_PATH is required")
+	}
+	opts.files = flag.Args()
+	if len(opts.files) == 0 {
+		return nil, fmt.Errorf("no input files")
+	}
+	return &opts, nil
+}
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	opts, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *options) error {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client, err := storage.NewMongoClient(cfg.MongoURI)
+	if err != nil {
+		return fmt.Errorf("mongo connect: %w", err)
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database(cfg.DBName)
+	if err := storage.CheckSchema(ctx, db); err != nil {
+		return fmt.Errorf("%w (start the controller or rule engine to migrate)", err)
+	}
+
+	importOpts := importer.Options{
+		BatchSize:    opts.batchSize,
+		DryRun:       opts.dryRun,
+		Partitions:   cfg.Partitions,
+		MaxErrors:    opts.maxErrors,
+		Roller:       storage.NewMongoSeriesStore(db, cfg.PacketCollection),
+		RawRetention: cfg.RetentionRaw,
+		KeepExpired:  opts.keepExpired,
+	}
+	if opts.publish {
+		if err := storage.CheckPartitions(ctx, db, cfg.Exchange, cfg.Partitions); err != nil {
+			return err
+		}
+		conn, err := queue.NewRabbitConnection(cfg.RabbitURI)
+		if err != nil {
+			return fmt.Errorf("rabbitmq connect: %w", err)
+		}
+		defer conn.Close()
+		ch, err := conn.Channel()
+		if err != nil {
+			return fmt.Errorf("rabbitmq channel: %w", err)
+		}
+		defer ch.Close()
+		if err := queue.DeclarePartitions(ch, cfg.Exchange, cfg.QueueName, cfg.Partitions); err != nil {
+			return fmt.Errorf("declare partitions: %w", err)
+		}
+		importOpts.Publisher = queue.NewRabbitPublisher(ch, cfg.Exchange)
+	}
+
+	im := importer.New(storage.NewMongoPacketStore(db.Collection(cfg.PacketCollection)), importOpts)
+
+	if opts.dryRun {
+		fmt.Println("dry run: nothing will be written")
+	}
+	var total importer.Report
+	for _, path := range opts.files {
+		rep, err := importFile(ctx, im, path, opts)
+		printReport(os.Stdout, path, rep, opts.publish)
+		total.Add(rep)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(opts.files) > 1 {
+		total.Samples = nil
+		printReport(os.Stdout, "total", total, opts.publish)
+	}
+	return nil
+}
+
+func importFile(ctx context.Context, im *importer.Importer, path string, opts *options) (importer.Report, error) {
+	r, closer, err := packetio.OpenFormat(path, opts.format, opts.mapping)
+	if err != nil {
+		return importer.Report{}, err
+	}
+	defer closer.Close()
+	return im.Import(ctx, r)
+}
+
+func printReport(w io.Writer, name string, rep importer.Report, publish bool) {
+	fmt.Fprintf(w, "%s: read %d, invalid %d, duplicate %d, already stored %d, inserted %d",
+		name, rep.Read, rep.Invalid, rep.Duplicate, rep.Existing, rep.Inserted)
+	if publish {
+		fmt.Fprintf(w, ", published %d", rep.Published)
+	}
+	if rep.Expired > 0 {
+		fmt.Fprintf(w, ", older than raw retention %d", rep.Expired)
+	}
+	fmt.Fprintln(w)
+	for _, s := range rep.Samples {
+		fmt.Fprintln(w, "  ", s)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/iot4gds"
+	"github.com/pochkachaiki/iot4gds/internal/engine"
+	"github.com/pochkachaiki/iot4gds/internal/escalation"
+	"github.com/pochkachaiki/iot4gds/internal/export"
+	"github.com/pochkachaiki/iot4gds/internal/handler"
+	"github.com/pochkachaiki/iot4gds/internal/notify"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"github.com/pochkachaiki/iot4gds/internal/upstream"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const queuePrefix = "packets"
+
+func setupLogger() *slog.Logger {
+	return slog.New(tracing.NewLogHandler(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+// Однопроцессный режим для станций без Mongo и RabbitMQ: контроллер и движок правил
+// работают в одном процессе поверх встроенного хранилища и брокера в памяти.
+func main() {
+	logger := setupLogger()
+	slog.SetDefault(logger)
+
+	cfg := config.MustLoad()
+
+	slog.Info("starting iot4gds", "http_addr", cfg.HTTPAddr, "data_path", cfg.DataPath,
+		"partitions", cfg.Partitions, "upstream_url", cfg.UpstreamURL, "tracing_exporter", cfg.Tracing.Exporter)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "iot4gds", cfg.Tracing)
+	if err != nil {
+		slog.Error("tracing setup error", "err", err)
+		os.Exit(1)
+	}
+
+	store, err := storage.OpenBoltStore(cfg.DataPath, cfg.UpstreamURL != "")
+	if err != nil {
+		slog.Error("open store error", "path", cfg.DataPath, "err", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	broker := queue.NewMemoryBroker()
+	broker.DeclarePartitions(queuePrefix, cfg.Partitions)
+
+	e := engine.New(cfg.EngineConfig(), store, store)
+	runner := engine.NewPartitionRunner(e, broker, queuePrefix, cfg.Partitions)
+	silences := storage.NewSilenceCache(store)
+	e.UseSilences(silences)
+
+	channels := []notify.Channel{notify.Log{}}
+	for name, url := range cfg.NotifyWebhooks {
+		channels = append(channels, notify.NewWebhook(name, url))
+	}
+	notifier := notify.New(store.Consumer("notifier"), store, channels...)
+	escalations := escalation.NewScheduler(store, channels, cfg.EscalationPolicies, cfg.EscalationInterval)
+
+	h := handler.New(store, broker, cfg.Partitions)
+	ch := handler.NewCommandHandler(store, cfg.CommandMaxWait, cfg.CommandRedeliverAfter)
+	// пакеты в файле не прореживаются, агрегаты считаются на лету
+	sh := handler.NewSeriesHandler(store, storage.Retention{}, cfg.SeriesMaxPoints, cfg.SeriesRawMaxRange)
+	exports := export.NewManager(export.New(export.NewBoltSource(store)), cfg.ExportDir)
+	eh := handler.NewExportHandler(exports)
+	alh := handler.NewAlertHandler(store)
+	slh := handler.NewSilenceHandler(store)
+	ah := handler.NewAlertStreamHandler(store, cfg.AlertStreamHeartbeat)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /packets", h.HandlePacket)
+	ch.Register(mux)
+	mux.HandleFunc("GET /devices/{device}/packets", sh.HandleSeries)
+	eh.Register(mux)
+	mux.HandleFunc("GET /alerts/stream", ah.HandleStream)
+	alh.Register(mux)
+	slh.Register(mux)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:    cfg.HTTPAddr,
+		Handler: handler.MetricsMiddleware(mux),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go silences.Run(ctx, cfg.SilenceRefreshInterval)
+
+	// единственный экземпляр сам рассылает уведомления и ведёт эскалации
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		notifier.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		escalations.Run(ctx)
+	}()
+
+	// единственный экземпляр владеет всеми партициями
+	for p := 0; p < cfg.Partitions; p++ {
+		runner.Assign(ctx, p)
+	}
+
+	if cfg.UpstreamURL != "" {
+		client := sender.NewClient(sender.ClientConfig{
+			URL:          cfg.UpstreamURL,
+			Timeout:      10 * time.Second,
+			MaxRetries:   2,
+			MinBackoff:   time.Second,
+			MaxBackoff:   10 * time.Second,
+			Gzip:         cfg.UpstreamGzip,
+			MaxIdleConns: 4,
+		})
+		syncer := upstream.NewSyncer(store, client, cfg.SyncInterval, cfg.SyncBatch)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			syncer.Run(ctx)
+		}()
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("server error", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	slog.Info("shutdown signal received")
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server shutdown error", "err", err)
+	}
+
+	// дожидаемся обработки уже принятых пакетов: брокер живёт только в памяти
+	for p := 0; p < cfg.Partitions; p++ {
+		drain(shutdownCtx, broker, queue.PartitionQueue(queuePrefix, p))
+		runner.Revoke(p)
+	}
+
+	// незавершённые выгрузки продолжаются после перезапуска через POST /exports/{id}/resume
+	exports.Shutdown()
+	cancel()
+	wg.Wait()
+
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("tracing shutdown error", "err", err)
+	}
+	tracingCancel()
+
+	slog.Info("iot4gds stopped")
+}
+
+func drain(ctx context.Context, broker *queue.MemoryBroker, name string) {
+	for broker.Len(name) > 0 {
+		select {
+		case <-ctx.Done():
+			slog.Warn("unprocessed packets dropped on shutdown", "queue", name, "count", broker.Len(name))
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -11,67 +11,22 @@ import (
 	"time"
 
 	config "github.com/pochkachaiki/iot4gds/internal/config/iot_controller"
+	"github.com/pochkachaiki/iot4gds/internal/export"
 	"github.com/pochkachaiki/iot4gds/internal/handler"
 	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/rollup"
 	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-	httpRequestsTotal = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method", "path", "status"},
-	)
-
-	httpRequestDuration = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "Duration of HTTP requests in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"method", "path"},
-	)
-)
-
-func metricsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ww := &responseWriter{ResponseWriter: w, status: http.StatusOK}
-		start := time.Now()
-
-		next.ServeHTTP(ww, r)
-
-		duration := time.Since(start).Seconds()
-		path := r.URL.Path
-		method := r.Method
-		status := ww.status
-
-		httpRequestsTotal.WithLabelValues(method, path, http.StatusText(status)).Inc()
-		httpRequestDuration.WithLabelValues(method, path).Observe(duration)
-	})
-}
-
-type responseWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.status = code
-	rw.ResponseWriter.WriteHeader(code)
-}
-
 func setupLogger() *slog.Logger {
 	f, err := os.OpenFile("/app/logs/app.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		panic(fmt.Sprintf("open log file: %v", err))
 	}
-	return slog.New(slog.NewJSONHandler(f, nil))
+	return slog.New(tracing.NewLogHandler(slog.NewJSONHandler(f, nil)))
 }
 
 func main() {
@@ -80,7 +35,14 @@ func main() {
 
 	cfg := config.MustLoad()
 
-	slog.Info("starting iot controller", "http_addr", cfg.HTTPAddr, "mongo_uri", cfg.MongoURI, "rabbit_uri", cfg.RabbitURI)
+	slog.Info("starting iot controller", "http_addr", cfg.HTTPAddr, "mongo_uri", cfg.MongoURI, "rabbit_uri", cfg.RabbitURI,
+		"exchange", cfg.Exchange, "partitions", cfg.Partitions, "tracing_exporter", cfg.Tracing.Exporter)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "iot-controller", cfg.Tracing)
+	if err != nil {
+		slog.Error("tracing setup error", "err", err)
+		os.Exit(1)
+	}
 
 	mongoClient, err := storage.NewMongoClient(cfg.MongoURI)
 	if err != nil {
@@ -103,28 +65,89 @@ func main() {
 	}
 	defer rabbitCh.Close()
 
-	err = queue.DeclareQueue(rabbitCh, cfg.QueueName)
+	err = queue.DeclarePartitions(rabbitCh, cfg.Exchange, cfg.QueueName, cfg.Partitions)
 	if err != nil {
-		slog.Error("declare queue error", "err", err)
+		slog.Error("declare partitions error", "err", err)
 		os.Exit(1)
 	}
 
 	db := mongoClient.Database(cfg.DBName)
+
+	schemaCtx, schemaCancel := context.WithTimeout(context.Background(), cfg.MigrationTimeout)
+	schema := storage.Schema{
+		Packets:  cfg.PacketCollection,
+		Alerts:   cfg.AlertCollection,
+		Commands: cfg.CommandCollection,
+		Silences: cfg.SilenceCollection,
+	}
+	err = storage.Bootstrap(schemaCtx, db, schema)
+	schemaCancel()
+	if err != nil {
+		slog.Error("schema bootstrap error", "err", err)
+		os.Exit(1)
+	}
+
+	partitionsCtx, partitionsCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = storage.CheckPartitions(partitionsCtx, db, cfg.Exchange, cfg.Partitions)
+	partitionsCancel()
+	if err != nil {
+		slog.Error("partition check error", "err", err)
+		os.Exit(1)
+	}
+
+	retentionCtx, retentionCancel := context.WithTimeout(context.Background(), time.Minute)
+	err = storage.ApplyRetention(retentionCtx, db, schema, cfg.Retention())
+	retentionCancel()
+	if err != nil {
+		slog.Error("apply retention error", "err", err)
+		os.Exit(1)
+	}
 	collection := db.Collection(cfg.PacketCollection)
 
-	h := handler.New(collection, rabbitCh, cfg.QueueName)
+	publisher := queue.NewRabbitPublisher(rabbitCh, cfg.Exchange)
+	h := handler.New(storage.NewMongoPacketStore(collection), publisher, cfg.Partitions)
+
+	commands := storage.NewMongoCommandStore(db.Collection(cfg.CommandCollection))
+	ch := handler.NewCommandHandler(commands, cfg.CommandMaxWait, cfg.CommandRedeliverAfter)
+
+	series := storage.NewMongoSeriesStore(db, cfg.PacketCollection)
+	sh := handler.NewSeriesHandler(series, cfg.Retention(), cfg.SeriesMaxPoints, cfg.SeriesRawMaxRange)
+
+	exports := export.NewManager(export.New(export.NewMongoSource(collection, db.Collection(cfg.AlertCollection))), cfg.ExportDir)
+	eh := handler.NewExportHandler(exports)
+
+	alh := handler.NewAlertHandler(storage.NewMongoAlertStore(db.Collection(cfg.AlertCollection)))
+
+	slh := handler.NewSilenceHandler(storage.NewMongoSilenceStore(db.Collection(cfg.SilenceCollection)))
+
+	watcher := storage.NewAlertWatcher(db.Collection(cfg.AlertCollection), db.Collection(cfg.WatchTokenCollection), cfg.AlertPollInterval)
+	ah := handler.NewAlertStreamHandler(watcher, cfg.AlertStreamHeartbeat)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /packets", h.HandlePacket)
+	ch.Register(mux)
+	mux.HandleFunc("GET /devices/{device}/packets", sh.HandleSeries)
+	eh.Register(mux)
+	mux.HandleFunc("GET /alerts/stream", ah.HandleStream)
+	alh.Register(mux)
+	slh.Register(mux)
 	mux.Handle("/metrics", promhttp.Handler())
 
-	instrumentedMux := metricsMiddleware(mux)
+	instrumentedMux := handler.MetricsMiddleware(mux)
 
 	srv := &http.Server{
 		Addr:    cfg.HTTPAddr,
 		Handler: instrumentedMux,
 	}
 
+	jobCtx, jobCancel := context.WithCancel(context.Background())
+	defer jobCancel()
+	if cfg.RollupEnabled {
+		job := rollup.NewJob(series, storage.NewMongoRollupState(db.Collection(cfg.RollupStateCollection)),
+			cfg.RollupInterval, cfg.RollupLateness, cfg.RollupBackfill)
+		go job.Run(jobCtx)
+	}
+
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			slog.Error("server error", "err", err)
@@ -144,6 +167,14 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		slog.Error("server shutdown error", "err", err)
 	}
+	// незавершённые выгрузки продолжаются после перезапуска через POST /exports/{id}/resume
+	exports.Shutdown()
+
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("tracing shutdown error", "err", err)
+	}
+	tracingCancel()
 
 	slog.Info("iot controller stopped")
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/rule_engine"
+	"github.com/pochkachaiki/iot4gds/internal/engine"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type options struct {
+	configPath     string
+	input          string
+	from, to       time.Time
+	devices        []int
+	sustainedCount int
+	deltaPressure  float64
+	compare        bool
+}
+
+func parseFlags() (*options, error) {
+	var (
+		opts             options
+		from, to, device string
+	)
+	flag.StringVar(&opts.configPath, "config", os.Getenv("CONFIG_PATH"), "path to rule engine config")
+	flag.StringVar(&opts.input, "input", "", "CSV/NDJSON file with packets; packets collection is used when empty")
+	flag.StringVar(&from, "from", "", "start of the period, RFC3339 (inclusive)")
+	flag.StringVar(&to, "to", "", "end of the period, RFC3339 (exclusive)")
+	flag.StringVar(&device, "devices", "", "comma separated device ids, all devices when empty")
+	flag.IntVar(&opts.sustainedCount, "sustained-count", 0, "override sustained_count from config")
+	flag.Float64Var(&opts.deltaPressure, "delta-pressure", 0, "override delta_pressure from config")
+	flag.BoolVar(&opts.compare, "compare", true, "compare with alerts stored in the alerts collection")
+	flag.Parse()
+
+	if opts.configPath == "" {
+		return nil, fmt.Errorf("-config or CONFIG_PATH is required")
+	}
+
+	var err error
+	if from != "" {
+		if opts.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return nil, fmt.Errorf("-from: %w", err)
+		}
+	}
+	if to != "" {
+		if opts.to, err = time.Parse(time.RFC3339, to); err != nil {
+			return nil, fmt.Errorf("-to: %w", err)
+		}
+	}
+	if opts.input == "" && (opts.from.IsZero() || opts.to.IsZero()) {
+		return nil, fmt.Errorf("-from and -to are required when reading from the packets collection")
+	}
+
+	if device != "" {
+		for _, s := range strings.Split(device, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("-devices: %w", err)
+			}
+			opts.devices = append(opts.devices, id)
+		}
+	}
+	return &opts, nil
+}
+
+func main() {
+	// алерты движка в логах не нужны — результат печатается отчётом
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	opts, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "backtest failed:", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *options) error {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		return err
+	}
+	if opts.sustainedCount > 0 {
+		cfg.SustainedCount = opts.sustainedCount
+	}
+	if opts.deltaPressure > 0 {
+		cfg.DeltaPressure = float32(opts.deltaPressure)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var db *mongo.Database
+	if opts.input == "" || opts.compare {
+		client, err := storage.NewMongoClient(cfg.MongoURI)
+		if err != nil {
+			return fmt.Errorf("mongo connect: %w", err)
+		}
+		defer client.Disconnect(ctx)
+		db = client.Database(cfg.DBName)
+		if err := storage.CheckSchema(ctx, db); err != nil {
+			return fmt.Errorf("%w (start the controller or rule engine to migrate)", err)
+		}
+	}
+
+	var src packetio.Reader
+	if opts.input != "" {
+		src, err = fileSource(opts)
+	} else {
+		src, err = mongoSource(ctx, db.Collection(cfg.PacketCollection), opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	sink := storage.NewMemoryAlertStore()
+	e := engine.New(cfg, nil, sink)
+
+	rep := newReport(cfg)
+	for {
+		p, err := src.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read packet: %w", err)
+		}
+		if err := e.ProcessPacket(ctx, p); err != nil {
+			return fmt.Errorf("process packet: %w", err)
+		}
+		rep.addPacket(p)
+	}
+
+	for _, a := range sink.Alerts() {
+		rep.addAlert(key{device: a.DeviceID, rule: a.Reason}, true)
+	}
+
+	if opts.compare && db != nil {
+		from, to := opts.from, opts.to
+		if from.IsZero() {
+			from = rep.first
+		}
+		if to.IsZero() {
+			to = rep.last.Add(time.Second)
+		}
+		if err := loadStored(ctx, db.Collection(cfg.AlertCollection), from, to, opts.devices, rep); err != nil {
+			return fmt.Errorf("load stored alerts: %w", err)
+		}
+	}
+
+	rep.print(os.Stdout, opts.compare && db != nil)
+	return nil
+}
+
+// fileSource читает выгрузку целиком и упорядочивает пакеты по времени,
+// так как файлы с разных станций обычно склеены без сортировки
+func fileSource(opts *options) (packetio.Reader, error) {
+	r, closer, err := packetio.Open(opts.input)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	all, err := packetio.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type timed struct {
+		p packet.Packet
+		t time.Time
+	}
+	packets := make([]timed, 0, len(all))
+	for _, p := range all {
+		t, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("device %d: invalid timestamp %q", p.DeviceID, p.Timestamp)
+		}
+		if !opts.from.IsZero() && t.Before(opts.from) || !opts.to.IsZero() && !t.Before(opts.to) {
+			continue
+		}
+		if len(opts.devices) > 0 && !contains(opts.devices, p.DeviceID) {
+			continue
+		}
+		packets = append(packets, timed{p: p, t: t})
+	}
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].t.Before(packets[j].t) })
+
+	sorted := make([]packet.Packet, len(packets))
+	for i, tp := range packets {
+		sorted[i] = tp.p
+	}
+	return &sliceReader{packets: sorted}, nil
+}
+
+type sliceReader struct {
+	packets []packet.Packet
+}
+
+func (r *sliceReader) Read() (packet.Packet, error) {
+	if len(r.packets) == 0 {
+		return packet.Packet{}, io.EOF
+	}
+	p := r.packets[0]
+	r.packets = r.packets[1:]
+	return p, nil
+}
+
+// periodFilter отбирает пакеты или алерты за период [from, to)
+func periodFilter(from, to time.Time, devices []int) bson.M {
+	filter := bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}}
+	if len(devices) > 0 {
+		filter["device_id"] = bson.M{"$in": devices}
+	}
+	return filter
+}
+
+func contains(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongooptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type cursorReader struct {
+	ctx    context.Context
+	cursor *mongo.Cursor
+}
+
+func (r *cursorReader) Read() (packet.Packet, error) {
+	if !r.cursor.Next(r.ctx) {
+		err := r.cursor.Err()
+		r.cursor.Close(r.ctx)
+		if err != nil {
+			return packet.Packet{}, err
+		}
+		return packet.Packet{}, io.EOF
+	}
+	var d storage.PacketDocument
+	if err := r.cursor.Decode(&d); err != nil {
+		return packet.Packet{}, fmt.Errorf("decode packet: %w", err)
+	}
+	return d.Packet(), nil
+}
+
+// mongoSource потоково читает пакеты за период в порядке времени
+func mongoSource(ctx context.Context, coll *mongo.Collection, opts *options) (packetio.Reader, error) {
+	findOpts := mongooptions.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(5000)
+
+	cursor, err := coll.Find(ctx, periodFilter(opts.from.UTC(), opts.to.UTC(), opts.devices), findOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &cursorReader{ctx: ctx, cursor: cursor}, nil
+}
+
+func loadStored(ctx context.Context, coll *mongo.Collection, from, to time.Time, devices []int, rep *report) error {
+	findOpts := mongooptions.Find().SetProjection(bson.M{"device_id": 1, "reason": 1})
+	cursor, err := coll.Find(ctx, periodFilter(from.UTC(), to.UTC(), devices), findOpts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var a struct {
+			DeviceID int    `bson:"device_id"`
+			Reason   string `bson:"reason"`
+		}
+		if err := cursor.Decode(&a); err != nil {
+			return err
+		}
+		rep.addAlert(key{device: a.DeviceID, rule: a.Reason}, false)
+	}
+	return cursor.Err()
+}
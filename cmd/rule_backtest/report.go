@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/rule_engine"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+type key struct {
+	device int
+	rule   string
+}
+
+type counts struct {
+	backtest int
+	stored   int
+}
+
+type report struct {
+	cfg         *config.Config
+	packets     int
+	first, last time.Time
+	alerts      map[key]*counts
+}
+
+func newReport(cfg *config.Config) *report {
+	return &report{cfg: cfg, alerts: make(map[key]*counts)}
+}
+
+func (r *report) addPacket(p packet.Packet) {
+	r.packets++
+	t, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		return
+	}
+	if r.first.IsZero() || t.Before(r.first) {
+		r.first = t
+	}
+	if t.After(r.last) {
+		r.last = t
+	}
+}
+
+func (r *report) addAlert(k key, backtest bool) {
+	c, ok := r.alerts[k]
+	if !ok {
+		c = &counts{}
+		r.alerts[k] = c
+	}
+	if backtest {
+		c.backtest++
+	} else {
+		c.stored++
+	}
+}
+
+func (r *report) group(by func(key) string) ([]string, map[string]*counts) {
+	groups := make(map[string]*counts)
+	for k, c := range r.alerts {
+		name := by(k)
+		g, ok := groups[name]
+		if !ok {
+			g = &counts{}
+			groups[name] = g
+		}
+		g.backtest += c.backtest
+		g.stored += c.stored
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, groups
+}
+
+func (r *report) print(out io.Writer, compare bool) {
+	fmt.Fprintf(out, "packets: %d", r.packets)
+	if r.packets > 0 {
+		fmt.Fprintf(out, " (%s .. %s)", r.first.Format(time.RFC3339), r.last.Format(time.RFC3339))
+	}
+	fmt.Fprintf(out, "\nthresholds: sustained_count=%d delta_pressure=%v pressure=[%v, %v] temperature=(%v, %v]\n",
+		r.cfg.SustainedCount, r.cfg.DeltaPressure, r.cfg.LowPressure, r.cfg.HighPressure,
+		r.cfg.LowTemperature, r.cfg.HighTemperature)
+	fmt.Fprintf(out, "warning bands: pressure=[%v, %v] temperature=[%v, %v] sustained_severity=%s\n\n",
+		r.cfg.WarningLowPressure, r.cfg.WarningHighPressure,
+		r.cfg.WarningLowTemperature, r.cfg.WarningHighTemperature, r.cfg.SustainedSeverity)
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := func(title string) {
+		if compare {
+			fmt.Fprintf(tw, "%s\tBACKTEST\tSTORED\tDIFF\n", title)
+		} else {
+			fmt.Fprintf(tw, "%s\tBACKTEST\n", title)
+		}
+	}
+	row := func(name string, c *counts) {
+		if compare {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\n", name, c.backtest, c.stored, c.backtest-c.stored)
+		} else {
+			fmt.Fprintf(tw, "%s\t%d\n", name, c.backtest)
+		}
+	}
+
+	names, byRule := r.group(func(k key) string { return k.rule })
+	header("RULE")
+	total := &counts{}
+	for _, name := range names {
+		row(name, byRule[name])
+		total.backtest += byRule[name].backtest
+		total.stored += byRule[name].stored
+	}
+	row("total", total)
+	fmt.Fprintln(tw)
+
+	devices, byDevice := r.group(func(k key) string { return fmt.Sprintf("%08d", k.device) })
+	header("DEVICE")
+	for _, name := range devices {
+		id, _ := strconv.Atoi(name)
+		row(strconv.Itoa(id), byDevice[name])
+	}
+	tw.Flush()
+
+	if !compare {
+		return
+	}
+
+	var diffs []key
+	for k, c := range r.alerts {
+		if c.backtest != c.stored {
+			diffs = append(diffs, k)
+		}
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintln(out, "\nno differences with stored alerts")
+		return
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].device != diffs[j].device {
+			return diffs[i].device < diffs[j].device
+		}
+		return diffs[i].rule < diffs[j].rule
+	})
+
+	fmt.Fprintln(out)
+	header("DEVICE / RULE")
+	for _, k := range diffs {
+		row(fmt.Sprintf("%d / %s", k.device, k.rule), r.alerts[k])
+	}
+	tw.Flush()
+}
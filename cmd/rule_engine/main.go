@@ -7,21 +7,54 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/pochkachaiki/iot4gds/internal/cluster"
 	config "github.com/pochkachaiki/iot4gds/internal/config/rule_engine"
 	"github.com/pochkachaiki/iot4gds/internal/engine"
+	"github.com/pochkachaiki/iot4gds/internal/escalation"
+	"github.com/pochkachaiki/iot4gds/internal/notify"
 	"github.com/pochkachaiki/iot4gds/internal/queue"
 	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Аренды, по которым уведомления и эскалации выполняет только одна реплика.
+// Номера партиций неотрицательные, поэтому не пересекаются с ними.
+const (
+	notifierLease   = -1
+	escalationLease = -2
+)
+
+var ownedPartitions = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "engine_owned_partitions",
+		Help: "Number of queue partitions currently owned by this rule engine replica",
+	},
+)
+
+func instanceID(cfg *config.Config) string {
+	if cfg.InstanceID != "" {
+		return cfg.InstanceID
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "rule-engine"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func setupLogger() *slog.Logger {
 	f, err := os.OpenFile("/app/logs/app.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		panic(fmt.Sprintf("open log file: %v", err))
 	}
-	return slog.New(slog.NewJSONHandler(f, nil))
+	return slog.New(tracing.NewLogHandler(slog.NewJSONHandler(f, nil)))
 }
 func main() {
 	logger := setupLogger()
@@ -29,8 +62,18 @@ func main() {
 
 	cfg := config.MustLoad()
 
+	member := instanceID(cfg)
+
 	slog.Info("starting rule engine", "mongo_uri", cfg.MongoURI, "rabbit_uri", cfg.RabbitURI, "queue", cfg.QueueName,
-		"sustained_count", cfg.SustainedCount, "delta_pressure", cfg.DeltaPressure, "metrics_addr", cfg.MetricsAddr)
+		"exchange", cfg.Exchange, "partitions", cfg.Partitions, "instance_id", member,
+		"sustained_count", cfg.SustainedCount, "delta_pressure", cfg.DeltaPressure, "metrics_addr", cfg.MetricsAddr,
+		"tracing_exporter", cfg.Tracing.Exporter)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "rule-engine", cfg.Tracing)
+	if err != nil {
+		slog.Error("tracing setup error", "err", err)
+		os.Exit(1)
+	}
 
 	mongoClient, err := storage.NewMongoClient(cfg.MongoURI)
 	if err != nil {
@@ -53,17 +96,57 @@ func main() {
 	}
 	defer rabbitCh.Close()
 
-	err = queue.DeclareQueue(rabbitCh, cfg.QueueName)
+	err = queue.DeclarePartitions(rabbitCh, cfg.Exchange, cfg.QueueName, cfg.Partitions)
 	if err != nil {
-		slog.Error("declare queue error", "err", err)
+		slog.Error("declare partitions error", "err", err)
 		os.Exit(1)
 	}
 
 	db := mongoClient.Database(cfg.DBName)
+
+	schemaCtx, schemaCancel := context.WithTimeout(context.Background(), cfg.MigrationTimeout)
+	err = storage.Bootstrap(schemaCtx, db, storage.Schema{
+		Packets:  cfg.PacketCollection,
+		Alerts:   cfg.AlertCollection,
+		Commands: cfg.CommandCollection,
+		Silences: cfg.SilenceCollection,
+	})
+	schemaCancel()
+	if err != nil {
+		slog.Error("schema bootstrap error", "err", err)
+		os.Exit(1)
+	}
+	partitionsCtx, partitionsCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = storage.CheckPartitions(partitionsCtx, db, cfg.Exchange, cfg.Partitions)
+	partitionsCancel()
+	if err != nil {
+		slog.Error("partition check error", "err", err)
+		os.Exit(1)
+	}
+
 	packetColl := db.Collection(cfg.PacketCollection)
 	alertColl := db.Collection(cfg.AlertCollection)
 
-	e := engine.New(cfg, packetColl, alertColl)
+	alerts := storage.NewMongoAlertStore(alertColl)
+	e := engine.New(cfg, storage.NewMongoPacketStore(packetColl), alerts)
+	silences := storage.NewSilenceCache(storage.NewMongoSilenceStore(db.Collection(cfg.SilenceCollection)))
+	e.UseSilences(silences)
+
+	channels := []notify.Channel{notify.Log{}}
+	for name, url := range cfg.NotifyWebhooks {
+		channels = append(channels, notify.NewWebhook(name, url))
+	}
+	watcher := storage.NewAlertWatcher(alertColl, db.Collection(cfg.WatchTokenCollection), cfg.AlertPollInterval)
+	notifier := notify.New(watcher.Consumer("notifier"), alerts, channels...)
+	escalations := escalation.NewScheduler(alerts, channels, cfg.EscalationPolicies, cfg.EscalationInterval)
+
+	leases := cluster.NewMongoLeaseStore(db.Collection(cfg.LeaseCollection), db.Collection(cfg.MemberCollection))
+	balancer := cluster.NewBalancer(leases, member, cfg.Partitions, cfg.LeaseTTL)
+	balancer.OnChanged(func(owned int) {
+		ownedPartitions.Set(float64(owned))
+	})
+	subscriber := queue.NewRabbitSubscriber(rabbitConn, cfg.Prefetch)
+	runner := engine.NewPartitionRunner(e, subscriber, cfg.QueueName, cfg.Partitions)
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -73,16 +156,49 @@ func main() {
 	}()
 
 	ctx, cancel := context.WithCancel(context.Background())
+	go silences.Run(ctx, cfg.SilenceRefreshInterval)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go func() {
-		e.Run(ctx, rabbitCh, cfg.QueueName)
+		defer wg.Done()
+		balancer.Run(ctx, runner)
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cluster.RunSingleton(ctx, leases, notifierLease, member, cfg.LeaseTTL, notifier.Run)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cluster.RunSingleton(ctx, leases, escalationLease, member, cfg.LeaseTTL, escalations.Run)
+	}()
+
+	go config.Watch(ctx, os.Getenv("CONFIG_PATH"), cfg.ReloadInterval, func(next *config.Config) {
+		if err := cfg.CheckReload(next); err != nil {
+			slog.Error("config reload rejected, keeping current config", "err", err)
+			return
+		}
+		e.Reload(next)
+		escalations.SetPolicies(next.EscalationPolicies)
+	})
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 
 	slog.Info("shutdown signal received")
 	cancel()
+	wg.Wait()
+
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("tracing shutdown error", "err", err)
+	}
+	tracingCancel()
 
 	slog.Info("rule engine stopped")
 }
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Handler получает уведомления о назначении и снятии партиций.
+// Revoke должен вернуться только после того, как обработка партиции полностью остановлена:
+// сразу после него аренда освобождается и партицию может забрать другой участник.
+type Handler interface {
+	Assign(ctx context.Context, partition int)
+	Revoke(partition int)
+}
+
+// Balancer распределяет партиции между живыми участниками группы.
+// Каждый участник держит не больше ceil(partitions / members) партиций,
+// освобождает лишние при появлении новых участников и забирает просроченные аренды ушедших.
+type Balancer struct {
+	store      LeaseStore
+	member     string
+	partitions int
+	ttl        time.Duration
+
+	owned     map[int]time.Time // партиция -> время последнего успешного продления
+	onChanged func(owned int)
+}
+
+func NewBalancer(store LeaseStore, member string, partitions int, ttl time.Duration) *Balancer {
+	return &Balancer{
+		store:      store,
+		member:     member,
+		partitions: partitions,
+		ttl:        ttl,
+		owned:      make(map[int]time.Time),
+	}
+}
+
+// OnChanged задаёт callback, вызываемый после каждого изменения набора партиций.
+func (b *Balancer) OnChanged(f func(owned int)) {
+	b.onChanged = f
+}
+
+func (b *Balancer) Run(ctx context.Context, h Handler) {
+	ticker := time.NewTicker(b.ttl / 3)
+	defer ticker.Stop()
+
+	slog.Info("balancer started", "member", b.member, "partitions", b.partitions, "lease_ttl", b.ttl)
+
+	for {
+		b.rebalance(ctx, h)
+
+		select {
+		case <-ctx.Done():
+			b.leave(h)
+			slog.Info("balancer stopped", "member", b.member)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Balancer) rebalance(ctx context.Context, h Handler) {
+	ctx, cancel := context.WithTimeout(ctx, b.ttl/3)
+	defer cancel()
+
+	before := len(b.owned)
+	defer func() {
+		if len(b.owned) != before && b.onChanged != nil {
+			b.onChanged(len(b.owned))
+		}
+	}()
+
+	if err := b.store.Heartbeat(ctx, b.member, b.ttl); err != nil {
+		slog.Error("heartbeat error", "member", b.member, "err", err)
+	}
+
+	b.renew(ctx, h)
+
+	members, err := b.store.Members(ctx)
+	if err != nil {
+		slog.Error("list members error", "err", err)
+		return
+	}
+	idx := sort.SearchStrings(members, b.member)
+	if idx == len(members) || members[idx] != b.member {
+		members = append(members, b.member)
+		sort.Strings(members)
+		idx = sort.SearchStrings(members, b.member)
+	}
+
+	share := (b.partitions + len(members) - 1) / len(members)
+
+	if len(b.owned) > share {
+		b.shrink(ctx, h, len(b.owned)-share)
+		return
+	}
+
+	// Начинаем поиск с "своего" диапазона партиций, чтобы участники не конкурировали за одни и те же
+	start := idx * share
+	for i := 0; i < b.partitions && len(b.owned) < share; i++ {
+		p := (start + i) % b.partitions
+		if _, ok := b.owned[p]; ok {
+			continue
+		}
+		ok, err := b.store.Acquire(ctx, p, b.member, b.ttl)
+		if err != nil {
+			slog.Error("acquire lease error", "partition", p, "err", err)
+			return
+		}
+		if ok {
+			b.owned[p] = time.Now()
+			slog.Info("partition assigned", "member", b.member, "partition", p, "members", len(members))
+			h.Assign(ctx, p)
+		}
+	}
+}
+
+func (b *Balancer) renew(ctx context.Context, h Handler) {
+	for p, last := range b.owned {
+		ok, err := b.store.Acquire(ctx, p, b.member, b.ttl)
+		if err != nil {
+			slog.Error("renew lease error", "partition", p, "err", err)
+			if time.Since(last) < b.ttl {
+				continue
+			}
+		} else if ok {
+			b.owned[p] = time.Now()
+			continue
+		}
+
+		slog.Warn("partition lease lost", "member", b.member, "partition", p)
+		h.Revoke(p)
+		delete(b.owned, p)
+	}
+}
+
+func (b *Balancer) shrink(ctx context.Context, h Handler, n int) {
+	owned := make([]int, 0, len(b.owned))
+	for p := range b.owned {
+		owned = append(owned, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(owned)))
+
+	for _, p := range owned[:n] {
+		h.Revoke(p)
+		delete(b.owned, p)
+		if err := b.store.Release(ctx, p, b.member); err != nil {
+			slog.Error("release lease error", "partition", p, "err", err)
+		}
+		slog.Info("partition released", "member", b.member, "partition", p)
+	}
+}
+
+func (b *Balancer) leave(h Handler) {
+	for p := range b.owned {
+		h.Revoke(p)
+		delete(b.owned, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := b.store.Leave(ctx, b.member); err != nil {
+		slog.Error("leave group error", "member", b.member, "err", err)
+	}
+	if b.onChanged != nil {
+		b.onChanged(0)
+	}
+}
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingHandler struct {
+	mu    sync.Mutex
+	owned map[int]bool
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{owned: make(map[int]bool)}
+}
+
+func (h *recordingHandler) Assign(_ context.Context, partition int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.owned[partition] = true
+}
+
+func (h *recordingHandler) Revoke(partition int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.owned, partition)
+}
+
+func (h *recordingHandler) partitions() []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Sorted(maps.Keys(h.owned))
+}
+
+func TestBalancerSharesPartitions(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryLeaseStore()
+	a, ha := NewBalancer(store, "a", 8, time.Minute), newRecordingHandler()
+	b, hb := NewBalancer(store, "b", 8, time.Minute), newRecordingHandler()
+
+	a.rebalance(ctx, ha)
+	if got := ha.partitions(); len(got) != 8 {
+		t.Fatalf("single member owns %v, want all 8 partitions", got)
+	}
+
+	// b видит двух участников, a отдаёт лишнее, b забирает освободившееся
+	b.rebalance(ctx, hb)
+	a.rebalance(ctx, ha)
+	b.rebalance(ctx, hb)
+
+	if got, want := ha.partitions(), []int{0, 1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("a owns %v, want %v", got, want)
+	}
+	if got, want := hb.partitions(), []int{4, 5, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("b owns %v, want %v", got, want)
+	}
+}
+
+func TestBalancerTakesOverExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	const ttl = 50 * time.Millisecond
+	store := newMemoryLeaseStore()
+	a, ha := NewBalancer(store, "a", 4, ttl), newRecordingHandler()
+	b, hb := NewBalancer(store, "b", 4, ttl), newRecordingHandler()
+
+	a.rebalance(ctx, ha)
+	b.rebalance(ctx, hb)
+	a.rebalance(ctx, ha)
+	b.rebalance(ctx, hb)
+	if len(ha.partitions()) != 2 || len(hb.partitions()) != 2 {
+		t.Fatalf("a owns %v, b owns %v, want 2 each", ha.partitions(), hb.partitions())
+	}
+
+	// a падает, не освободив аренды: b забирает их после истечения ttl
+	time.Sleep(ttl + 10*time.Millisecond)
+	b.rebalance(ctx, hb)
+
+	if got := hb.partitions(); len(got) != 4 {
+		t.Errorf("b owns %v after a expired, want all 4 partitions", got)
+	}
+	if ok, _ := store.Acquire(ctx, 0, "a", ttl); ok {
+		t.Error("a reacquired partition 0 owned by b")
+	}
+}
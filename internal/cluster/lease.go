@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LeaseStore хранит участников группы и аренды партиций.
+type LeaseStore interface {
+	Heartbeat(ctx context.Context, member string, ttl time.Duration) error
+	Members(ctx context.Context) ([]string, error)
+	Leave(ctx context.Context, member string) error
+	// Acquire захватывает свободную (или просроченную) партицию либо продлевает собственную аренду.
+	Acquire(ctx context.Context, partition int, member string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, partition int, member string) error
+}
+
+type MongoLeaseStore struct {
+	leases  *mongo.Collection
+	members *mongo.Collection
+}
+
+func NewMongoLeaseStore(leases, members *mongo.Collection) *MongoLeaseStore {
+	return &MongoLeaseStore{
+		leases:  leases,
+		members: members,
+	}
+}
+
+func (s *MongoLeaseStore) Heartbeat(ctx context.Context, member string, ttl time.Duration) error {
+	_, err := s.members.UpdateOne(ctx,
+		bson.M{"_id": member},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(ttl)}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *MongoLeaseStore) Members(ctx context.Context) ([]string, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := s.members.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	members := make([]string, len(docs))
+	for i, d := range docs {
+		members[i] = d.ID
+	}
+	return members, nil
+}
+
+func (s *MongoLeaseStore) Leave(ctx context.Context, member string) error {
+	if _, err := s.leases.DeleteMany(ctx, bson.M{"owner": member}); err != nil {
+		return err
+	}
+	_, err := s.members.DeleteOne(ctx, bson.M{"_id": member})
+	return err
+}
+
+func (s *MongoLeaseStore) Acquire(ctx context.Context, partition int, member string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": partition,
+		"$or": bson.A{
+			bson.M{"owner": member},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": member, "expires_at": now.Add(ttl)}}
+
+	_, err := s.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// аренда существует и принадлежит другому участнику
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *MongoLeaseStore) Release(ctx context.Context, partition int, member string) error {
+	_, err := s.leases.DeleteOne(ctx, bson.M{"_id": partition, "owner": member})
+	return err
+}
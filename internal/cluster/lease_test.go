@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryLease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// memoryLeaseStore — группа участников внутри одного процесса для тестов
+type memoryLeaseStore struct {
+	mu      sync.Mutex
+	members map[string]time.Time
+	leases  map[int]memoryLease
+}
+
+func newMemoryLeaseStore() *memoryLeaseStore {
+	return &memoryLeaseStore{
+		members: make(map[string]time.Time),
+		leases:  make(map[int]memoryLease),
+	}
+}
+
+func (s *memoryLeaseStore) Heartbeat(_ context.Context, member string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[member] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memoryLeaseStore) Members(context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var members []string
+	for m, expiresAt := range s.members {
+		if expiresAt.After(now) {
+			members = append(members, m)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (s *memoryLeaseStore) Leave(_ context.Context, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p, l := range s.leases {
+		if l.owner == member {
+			delete(s.leases, p)
+		}
+	}
+	delete(s.members, member)
+	return nil
+}
+
+func (s *memoryLeaseStore) Acquire(_ context.Context, partition int, member string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if l, ok := s.leases[partition]; ok && l.owner != member && !l.expiresAt.Before(now) {
+		return false, nil
+	}
+	s.leases[partition] = memoryLease{owner: member, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryLeaseStore) Release(_ context.Context, partition int, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.leases[partition]; ok && l.owner == member {
+		delete(s.leases, partition)
+	}
+	return nil
+}
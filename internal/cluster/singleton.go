@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RunSingleton выполняет run только на одном участнике группы — владельце аренды
+// lease. Номер аренды не должен совпадать с номерами партиций. При потере аренды
+// контекст run отменяется, и его подхватывает другой участник.
+func RunSingleton(ctx context.Context, store LeaseStore, lease int, member string, ttl time.Duration, run func(ctx context.Context)) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	var (
+		cancel  context.CancelFunc
+		done    chan struct{}
+		renewed time.Time
+	)
+	stop := func() {
+		if cancel == nil {
+			return
+		}
+		cancel()
+		<-done
+		cancel = nil
+	}
+	defer func() {
+		stop()
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer releaseCancel()
+		if err := store.Release(releaseCtx, lease, member); err != nil {
+			slog.Error("release singleton lease error", "lease", lease, "err", err)
+		}
+	}()
+
+	for {
+		// срок аренды отсчитывается с момента запроса, а не ответа
+		attempt := time.Now()
+		acquireCtx, acquireCancel := context.WithTimeout(ctx, ttl/3)
+		ok, err := store.Acquire(acquireCtx, lease, member, ttl)
+		acquireCancel()
+
+		switch {
+		case err != nil:
+			slog.Error("acquire singleton lease error", "lease", lease, "err", err)
+			// пока аренда не истекла, другой участник её не заберёт. Следующая попытка
+			// будет только через ttl/3, поэтому остановиться нужно заранее.
+			if cancel != nil && time.Since(renewed) >= ttl-ttl/3 {
+				slog.Warn("singleton lease lost", "lease", lease, "member", member)
+				stop()
+			}
+		case ok:
+			renewed = attempt
+			if cancel == nil {
+				slog.Info("singleton lease acquired", "lease", lease, "member", member)
+				runCtx, runCancel := context.WithCancel(ctx)
+				cancel, done = runCancel, make(chan struct{})
+				go func() {
+					defer close(done)
+					run(runCtx)
+				}()
+			}
+		case cancel != nil:
+			slog.Warn("singleton lease lost", "lease", lease, "member", member)
+			stop()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunSingletonExclusive(t *testing.T) {
+	const ttl = 30 * time.Millisecond
+	store := newMemoryLeaseStore()
+
+	var active, maxActive atomic.Int32
+	var runs sync.Map
+	run := func(member string) func(context.Context) {
+		return func(ctx context.Context) {
+			n := active.Add(1)
+			for {
+				m := maxActive.Load()
+				if n <= m || maxActive.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			runs.Store(member, true)
+			<-ctx.Done()
+			active.Add(-1)
+		}
+	}
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		RunSingleton(ctxA, store, -1, "a", ttl, run("a"))
+	}()
+	time.Sleep(ttl)
+	go func() {
+		defer wg.Done()
+		RunSingleton(ctxB, store, -1, "b", ttl, run("b"))
+	}()
+
+	time.Sleep(3 * ttl)
+	if _, ok := runs.Load("a"); !ok {
+		t.Fatal("a did not start")
+	}
+	if _, ok := runs.Load("b"); ok {
+		t.Fatal("b started while a holds the lease")
+	}
+
+	// a останавливается и освобождает аренду, b подхватывает работу
+	stopA()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := runs.Load("b"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("b did not take over after a stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopB()
+	wg.Wait()
+
+	if maxActive.Load() != 1 {
+		t.Errorf("max concurrent runs = %d, want 1", maxActive.Load())
+	}
+}
+
+// flakyLeaseStore продлевает аренду один раз, а дальше запросы висят до таймаута
+type flakyLeaseStore struct {
+	*memoryLeaseStore
+	calls     atomic.Int32
+	expiresAt atomic.Int64
+}
+
+func (s *flakyLeaseStore) Acquire(ctx context.Context, partition int, member string, ttl time.Duration) (bool, error) {
+	if s.calls.Add(1) == 1 {
+		s.expiresAt.Store(time.Now().Add(ttl).UnixNano())
+		return s.memoryLeaseStore.Acquire(ctx, partition, member, ttl)
+	}
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestRunSingletonStopsBeforeExpiry(t *testing.T) {
+	const ttl = 60 * time.Millisecond
+	store := &flakyLeaseStore{memoryLeaseStore: newMemoryLeaseStore()}
+
+	stopped := make(chan time.Time, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*ttl)
+	defer cancel()
+	RunSingleton(ctx, store, -1, "a", ttl, func(runCtx context.Context) {
+		<-runCtx.Done()
+		stopped <- time.Now()
+		cancel()
+	})
+
+	select {
+	case at := <-stopped:
+		if expires := time.Unix(0, store.expiresAt.Load()); !at.Before(expires) {
+			t.Errorf("run stopped %s after the lease expired", at.Sub(expires))
+		}
+	default:
+		t.Fatal("run was not started")
+	}
+}
@@ -3,15 +3,98 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/pochkachaiki/iot4gds/internal/fault"
+	"github.com/pochkachaiki/iot4gds/internal/loadtest"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
 )
 
+const (
+	ModeGenerate = "generate"
+	ModeReplay   = "replay"
+	ModeLoadTest = "loadtest"
+)
+
+// LoadStage — этап профиля нагрузки, см. loadtest.Stage
+type LoadStage struct {
+	Duration time.Duration `yaml:"duration"`
+	Devices  int           `yaml:"devices"`
+	Rate     float64       `yaml:"rate"`
+}
+
+// ReplayColumns — имена колонок (полей) записи, соответствующие полям пакета
+type ReplayColumns struct {
+	DeviceID    string `yaml:"device_id" env-default:"device_id"`
+	Timestamp   string `yaml:"timestamp" env-default:"timestamp"`
+	Pressure    string `yaml:"pressure" env-default:"pressure"`
+	Temperature string `yaml:"temperature" env-default:"temperature"`
+	TimeLayout  string `yaml:"time_layout"`
+}
+
+// Faults — вероятности сбоев на один пакет, см. fault.Config
+type Faults struct {
+	MalformedJSON   float64       `yaml:"malformed_json"`
+	NegativeValues  float64       `yaml:"negative_values"`
+	MissingFields   float64       `yaml:"missing_fields"`
+	Duplicate       float64       `yaml:"duplicate"`
+	ClockSkew       float64       `yaml:"clock_skew"`
+	ClockSkewMax    time.Duration `yaml:"clock_skew_max" env-default:"5m"`
+	FutureTimestamp float64       `yaml:"future_timestamp"`
+	FutureOffset    time.Duration `yaml:"future_offset" env-default:"24h"`
+	OutOfOrder      float64       `yaml:"out_of_order"`
+	OutOfOrderBurst int           `yaml:"out_of_order_burst" env-default:"5"`
+	Silence         float64       `yaml:"silence"`
+	SilenceDuration time.Duration `yaml:"silence_duration" env-default:"5m"`
+}
+
+// ModelStage — звено модели сигнала: тип и его числовые параметры
+type ModelStage struct {
+	Type   string             `yaml:"type"`
+	Params map[string]float64 `yaml:",inline"`
+}
+
+// Models — именованные профили моделей сигнала и их назначение устройствам.
+// Устройство без назначения получает профиль Default; если и он пуст —
+// используется packet.Generate.
+type Models struct {
+	TimeScale float64                 `yaml:"time_scale" env-default:"1"`
+	Default   string                  `yaml:"default"`
+	Profiles  map[string][]ModelStage `yaml:"profiles"`
+	Devices   map[int]string          `yaml:"devices"`
+}
+
 type Config struct {
-	IotSystemUrl string  `yaml:"iot_system_url" env-required:"true"`
-	MetricsAddr  string  `yaml:"metrics_addr" env-default:":9092"`
-	DeviceNumber int     `yaml:"device_number" env-required:"true"`
-	MsgPeriod    float32 `yaml:"msg_period" env-required:"true"`
+	Mode               string        `yaml:"mode" env-default:"generate"`
+	IotSystemUrl       string        `yaml:"iot_system_url" env-required:"true"`
+	MetricsAddr        string        `yaml:"metrics_addr" env-default:":9092"`
+	DeviceNumber       int           `yaml:"device_number"`
+	MsgPeriod          float32       `yaml:"msg_period" env-required:"true"`
+	SendTimeout        time.Duration `yaml:"send_timeout" env-default:"5s"`
+	SendRetries        int           `yaml:"send_retries" env-default:"3"`
+	SendMinBackoff     time.Duration `yaml:"send_min_backoff" env-default:"200ms"`
+	SendMaxBackoff     time.Duration `yaml:"send_max_backoff" env-default:"5s"`
+	Gzip               bool          `yaml:"gzip"`
+	MaxIdleConns       int           `yaml:"max_idle_conns" env-default:"100"`
+	ScenarioPath       string        `yaml:"scenario_path"`
+	Seed               uint64        `yaml:"seed"`
+	ReplayFiles        []string      `yaml:"replay_files"`
+	ReplayFormat       string        `yaml:"replay_format"`
+	ReplayColumns      ReplayColumns `yaml:"replay_columns"`
+	ReplaySpeed        float64       `yaml:"replay_speed" env-default:"1"`
+	ReplayRewriteTime  bool          `yaml:"replay_rewrite_time"`
+	CommandsURL        string        `yaml:"commands_url"`
+	CommandWait        time.Duration `yaml:"command_wait" env-default:"25s"`
+	Models             Models        `yaml:"models"`
+	LoadProfile        []LoadStage   `yaml:"load_profile"`
+	Faults             Faults        `yaml:"faults"`
+	BufferDir          string        `yaml:"buffer_dir"`
+	BufferMaxBytes     int64         `yaml:"buffer_max_bytes" env-default:"104857600"`
+	BufferSegmentBytes int64         `yaml:"buffer_segment_bytes" env-default:"4194304"`
+	ReplayMinBackoff   time.Duration `yaml:"replay_min_backoff" env-default:"1s"`
+	ReplayMaxBackoff   time.Duration `yaml:"replay_max_backoff" env-default:"1m"`
 }
 
 func MustLoad() *Config {
@@ -26,5 +109,99 @@ func MustLoad() *Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		panic(fmt.Errorf("error reading config file: %s", err))
 	}
+	if cfg.Models.TimeScale <= 0 {
+		panic("models.time_scale must be positive")
+	}
+	for id, name := range cfg.Models.Devices {
+		if _, ok := cfg.Models.Profiles[name]; !ok {
+			panic(fmt.Errorf("models.devices[%d]: unknown profile %q", id, name))
+		}
+	}
+	if _, ok := cfg.Models.Profiles[cfg.Models.Default]; cfg.Models.Default != "" && !ok {
+		panic(fmt.Errorf("models.default: unknown profile %q", cfg.Models.Default))
+	}
+
+	switch cfg.Mode {
+	case ModeGenerate:
+		if cfg.ScenarioPath == "" && cfg.DeviceNumber <= 0 {
+			panic("device_number is required when scenario_path is not set")
+		}
+	case ModeReplay:
+		if len(cfg.ReplayFiles) == 0 {
+			panic("replay_files is required in replay mode")
+		}
+		if cfg.ReplaySpeed < 0 {
+			panic("replay_speed must not be negative")
+		}
+		// интервалы сохраняются в масштабе записи, и при ускорении метки уходили бы в будущее
+		if cfg.ReplayRewriteTime && (cfg.ReplaySpeed == 0 || cfg.ReplaySpeed > 1) {
+			panic(fmt.Errorf("replay_rewrite_time requires replay_speed in (0, 1], got %v", cfg.ReplaySpeed))
+		}
+	case ModeLoadTest:
+		if len(cfg.LoadProfile) == 0 {
+			panic("load_profile is required in loadtest mode")
+		}
+		// буфер и сбои подменяют ответы контроллера, и сводка меряла бы их, а не его
+		if cfg.BufferDir != "" {
+			panic("buffer_dir is not supported in loadtest mode")
+		}
+		if cfg.FaultConfig().Enabled() {
+			panic("faults are not supported in loadtest mode")
+		}
+		for i, st := range cfg.LoadProfile {
+			if st.Duration <= 0 || st.Devices < 0 || st.Rate < 0 {
+				panic(fmt.Errorf("load_profile[%d]: duration must be positive, devices and rate non-negative", i))
+			}
+		}
+	default:
+		panic(fmt.Errorf("unknown mode: %q", cfg.Mode))
+	}
 	return &cfg
 }
+
+func (c *Config) ReplayMapping() packetio.Mapping {
+	return packetio.Mapping{
+		DeviceID:    c.ReplayColumns.DeviceID,
+		Timestamp:   c.ReplayColumns.Timestamp,
+		Pressure:    c.ReplayColumns.Pressure,
+		Temperature: c.ReplayColumns.Temperature,
+		TimeLayout:  c.ReplayColumns.TimeLayout,
+	}
+}
+
+func (c *Config) LoadStages() []loadtest.Stage {
+	stages := make([]loadtest.Stage, len(c.LoadProfile))
+	for i, st := range c.LoadProfile {
+		stages[i] = loadtest.Stage{Duration: st.Duration, Devices: st.Devices, Rate: st.Rate}
+	}
+	return stages
+}
+
+func (c *Config) FaultConfig() fault.Config {
+	return fault.Config{
+		MalformedJSON:   c.Faults.MalformedJSON,
+		NegativeValues:  c.Faults.NegativeValues,
+		MissingFields:   c.Faults.MissingFields,
+		Duplicate:       c.Faults.Duplicate,
+		ClockSkew:       c.Faults.ClockSkew,
+		ClockSkewMax:    c.Faults.ClockSkewMax,
+		FutureTimestamp: c.Faults.FutureTimestamp,
+		FutureOffset:    c.Faults.FutureOffset,
+		OutOfOrder:      c.Faults.OutOfOrder,
+		OutOfOrderBurst: c.Faults.OutOfOrderBurst,
+		Silence:         c.Faults.Silence,
+		SilenceDuration: c.Faults.SilenceDuration,
+	}
+}
+
+func (c *Config) ClientConfig() sender.ClientConfig {
+	return sender.ClientConfig{
+		URL:          c.IotSystemUrl,
+		Timeout:      c.SendTimeout,
+		MaxRetries:   c.SendRetries,
+		MinBackoff:   c.SendMinBackoff,
+		MaxBackoff:   c.SendMaxBackoff,
+		Gzip:         c.Gzip,
+		MaxIdleConns: c.MaxIdleConns,
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	ruleconfig "github.com/pochkachaiki/iot4gds/internal/config/rule_engine"
+	"github.com/pochkachaiki/iot4gds/internal/escalation"
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+)
+
+type Config struct {
+	HTTPAddr        string        `yaml:"http_addr" env-default:":5555"`
+	DataPath        string        `yaml:"data_path" env-default:"/var/lib/iot4gds/iot4gds.db"`
+	Partitions      int           `yaml:"partitions" env-default:"4"`
+	SustainedCount  int           `yaml:"sustained_count" env-default:"10"`
+	DeltaPressure   float32       `yaml:"delta_pressure" env-default:"0.196133"`
+	LowPressure     float32       `yaml:"low_pressure" env-default:"0.03"`
+	HighPressure    float32       `yaml:"high_pressure" env-default:"0.07"`
+	LowTemperature  float32       `yaml:"low_temperature" env-default:"5"`
+	HighTemperature float32       `yaml:"high_temperature" env-default:"40"`
+	UpstreamURL     string        `yaml:"upstream_url"`
+	UpstreamGzip    bool          `yaml:"upstream_gzip"`
+	SyncInterval    time.Duration `yaml:"sync_interval" env-default:"30s"`
+	SyncBatch       int           `yaml:"sync_batch" env-default:"500"`
+
+	WarningLowPressure     float32        `yaml:"warning_low_pressure" env-default:"0.04"`
+	WarningHighPressure    float32        `yaml:"warning_high_pressure" env-default:"0.06"`
+	WarningLowTemperature  float32        `yaml:"warning_low_temperature" env-default:"8"`
+	WarningHighTemperature float32        `yaml:"warning_high_temperature" env-default:"40"`
+	SustainedSeverity      alert.Severity `yaml:"sustained_severity" env-default:"warning"`
+
+	// DeviceGroups — группы устройств для тишин: имя -> устройства
+	DeviceGroups           map[string][]int `yaml:"device_groups"`
+	SilenceRefreshInterval time.Duration    `yaml:"silence_refresh_interval" env-default:"10s"`
+	// NotifyWebhooks — каналы уведомлений: имя канала -> URL
+	NotifyWebhooks     map[string]string   `yaml:"notify_webhooks"`
+	EscalationPolicies []escalation.Policy `yaml:"escalation_policies"`
+	EscalationInterval time.Duration       `yaml:"escalation_interval" env-default:"30s"`
+
+	CommandMaxWait        time.Duration `yaml:"command_max_wait" env-default:"30s"`
+	CommandRedeliverAfter time.Duration `yaml:"command_redeliver_after" env-default:"1m"`
+	SeriesMaxPoints       int           `yaml:"series_max_points" env-default:"2000"`
+	SeriesRawMaxRange     time.Duration `yaml:"series_raw_max_range" env-default:"6h"`
+	ExportDir             string        `yaml:"export_dir" env-default:"/var/lib/iot4gds/exports"`
+	AlertStreamHeartbeat  time.Duration `yaml:"alert_stream_heartbeat" env-default:"15s"`
+
+	Tracing tracing.Config `yaml:"tracing"`
+}
+
+func MustLoad() *Config {
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		panic("CONFIG_PATH environment variable is not set")
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		panic(fmt.Errorf("error opening config file: %s", err))
+	}
+	var cfg Config
+	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+		panic(fmt.Errorf("error reading config file: %s", err))
+	}
+	if err := cfg.EngineConfig().Validate(); err != nil {
+		panic(fmt.Errorf("invalid config: %w", err))
+	}
+	return &cfg
+}
+
+// EngineConfig возвращает конфигурацию движка правил с порогами из общего конфига
+func (c *Config) EngineConfig() *ruleconfig.Config {
+	return &ruleconfig.Config{
+		Partitions:             c.Partitions,
+		LeaseTTL:               time.Second, // аренды в однопроцессном режиме не используются
+		DeviceGroups:           c.DeviceGroups,
+		SilenceRefreshInterval: c.SilenceRefreshInterval,
+		NotifyWebhooks:         c.NotifyWebhooks,
+		EscalationPolicies:     c.EscalationPolicies,
+		EscalationInterval:     c.EscalationInterval,
+		SustainedCount:         c.SustainedCount,
+		DeltaPressure:          c.DeltaPressure,
+		LowPressure:            c.LowPressure,
+		HighPressure:           c.HighPressure,
+		LowTemperature:         c.LowTemperature,
+		HighTemperature:        c.HighTemperature,
+		WarningLowPressure:     c.WarningLowPressure,
+		WarningHighPressure:    c.WarningHighPressure,
+		WarningLowTemperature:  c.WarningLowTemperature,
+		WarningHighTemperature: c.WarningHighTemperature,
+		SustainedSeverity:      c.SustainedSeverity,
+		Tracing:                c.Tracing,
+	}
+}
@@ -3,17 +3,45 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
 )
 
 type Config struct {
-	HTTPAddr         string `yaml:"http_addr" env-required:"true"`
-	MongoURI         string `yaml:"mongo_uri" env-required:"true"`
-	RabbitURI        string `yaml:"rabbit_uri" env-required:"true"`
-	QueueName        string `yaml:"queue_name" env-default:"packets"`
-	DBName           string `yaml:"db_name" env-default:"iot"`
-	PacketCollection string `yaml:"packet_collection" env-default:"packets"`
+	HTTPAddr              string        `yaml:"http_addr" env-required:"true"`
+	MongoURI              string        `yaml:"mongo_uri" env-required:"true"`
+	RabbitURI             string        `yaml:"rabbit_uri" env-required:"true"`
+	QueueName             string        `yaml:"queue_name" env-default:"packets"`
+	Exchange              string        `yaml:"exchange" env-default:"packets"`
+	Partitions            int           `yaml:"partitions" env-default:"8"`
+	DBName                string        `yaml:"db_name" env-default:"iot"`
+	PacketCollection      string        `yaml:"packet_collection" env-default:"packets"`
+	AlertCollection       string        `yaml:"alert_collection" env-default:"alerts"`
+	CommandCollection     string        `yaml:"command_collection" env-default:"commands"`
+	SilenceCollection     string        `yaml:"silence_collection" env-default:"silences"`
+	CommandMaxWait        time.Duration `yaml:"command_max_wait" env-default:"30s"`
+	CommandRedeliverAfter time.Duration `yaml:"command_redeliver_after" env-default:"1m"`
+	MigrationTimeout      time.Duration `yaml:"migration_timeout" env-default:"10m"`
+	RetentionRaw          time.Duration `yaml:"retention_raw" env-default:"720h"`
+	Retention1m           time.Duration `yaml:"retention_1m" env-default:"2160h"`
+	Retention1h           time.Duration `yaml:"retention_1h" env-default:"17520h"`
+	Retention1d           time.Duration `yaml:"retention_1d"`
+	RollupStateCollection string        `yaml:"rollup_state_collection" env-default:"rollup_state"`
+	RollupEnabled         bool          `yaml:"rollup_enabled" env-default:"true"`
+	RollupInterval        time.Duration `yaml:"rollup_interval" env-default:"1m"`
+	RollupLateness        time.Duration `yaml:"rollup_lateness" env-default:"2m"`
+	RollupBackfill        time.Duration `yaml:"rollup_backfill" env-default:"720h"`
+	SeriesMaxPoints       int           `yaml:"series_max_points" env-default:"2000"`
+	SeriesRawMaxRange     time.Duration `yaml:"series_raw_max_range" env-default:"6h"`
+	ExportDir             string        `yaml:"export_dir" env-default:"/app/exports"`
+	WatchTokenCollection  string        `yaml:"watch_token_collection" env-default:"watch_tokens"`
+	AlertPollInterval     time.Duration `yaml:"alert_poll_interval" env-default:"2s"`
+	AlertStreamHeartbeat  time.Duration `yaml:"alert_stream_heartbeat" env-default:"15s"`
+
+	Tracing tracing.Config `yaml:"tracing"`
 }
 
 func MustLoad() *Config {
@@ -24,9 +52,30 @@ func MustLoad() *Config {
 	if _, err := os.Stat(configPath); err != nil {
 		panic(fmt.Errorf("error opening config file: %s", err))
 	}
+	cfg, err := Load(configPath)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+func Load(path string) (*Config, error) {
 	var cfg Config
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic(fmt.Errorf("error reading config file: %s", err))
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// Retention возвращает сроки хранения по разрешениям, 0 — бессрочно
+func (c *Config) Retention() storage.Retention {
+	return storage.Retention{
+		storage.Raw:      c.RetentionRaw,
+		storage.Minutely: c.Retention1m,
+		storage.Hourly:   c.Retention1h,
+		storage.Daily:    c.Retention1d,
 	}
-	return &cfg
 }
@@ -1,22 +1,68 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/pochkachaiki/iot4gds/internal/escalation"
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
 )
 
 type Config struct {
-	MongoURI         string  `yaml:"mongo_uri" env-required:"true"`
-	RabbitURI        string  `yaml:"rabbit_uri" env-required:"true"`
-	QueueName        string  `yaml:"queue_name" env-default:"packets"`
-	DBName           string  `yaml:"db_name" env-default:"iot"`
-	PacketCollection string  `yaml:"packet_collection" env-default:"packets"`
-	AlertCollection  string  `yaml:"alert_collection" env-default:"alerts"`
-	SustainedCount   int     `yaml:"sustained_count" env-default:"10"`
-	DeltaPressure    float32 `yaml:"delta_pressure" env-default:"0.196133"`
-	MetricsAddr      string  `yaml:"metrics_addr" env-default:":9091"`
+	MongoURI          string        `yaml:"mongo_uri" env-required:"true"`
+	RabbitURI         string        `yaml:"rabbit_uri" env-required:"true"`
+	QueueName         string        `yaml:"queue_name" env-default:"packets"`
+	Exchange          string        `yaml:"exchange" env-default:"packets"`
+	Partitions        int           `yaml:"partitions" env-default:"8"`
+	Prefetch          int           `yaml:"prefetch" env-default:"50"`
+	InstanceID        string        `yaml:"instance_id"`
+	LeaseCollection   string        `yaml:"lease_collection" env-default:"partition_leases"`
+	MemberCollection  string        `yaml:"member_collection" env-default:"engine_members"`
+	LeaseTTL          time.Duration `yaml:"lease_ttl" env-default:"15s"`
+	DBName            string        `yaml:"db_name" env-default:"iot"`
+	PacketCollection  string        `yaml:"packet_collection" env-default:"packets"`
+	AlertCollection   string        `yaml:"alert_collection" env-default:"alerts"`
+	CommandCollection string        `yaml:"command_collection" env-default:"commands"`
+	MigrationTimeout  time.Duration `yaml:"migration_timeout" env-default:"10m"`
+	SustainedCount    int           `yaml:"sustained_count" env-default:"10" reload:"true"`
+	DeltaPressure     float32       `yaml:"delta_pressure" env-default:"0.196133" reload:"true"`
+	LowPressure       float32       `yaml:"low_pressure" env-default:"0.03" reload:"true"`
+	HighPressure      float32       `yaml:"high_pressure" env-default:"0.07" reload:"true"`
+	LowTemperature    float32       `yaml:"low_temperature" env-default:"5" reload:"true"`
+	HighTemperature   float32       `yaml:"high_temperature" env-default:"40" reload:"true"`
+	MetricsAddr       string        `yaml:"metrics_addr" env-default:":9091"`
+	ReloadInterval    time.Duration `yaml:"reload_interval" env-default:"5s"`
+	// DeviceGroups — группы устройств (например, станции) для тишин: имя -> устройства
+	DeviceGroups map[string][]int `yaml:"device_groups" reload:"true"`
+
+	SilenceCollection      string        `yaml:"silence_collection" env-default:"silences"`
+	SilenceRefreshInterval time.Duration `yaml:"silence_refresh_interval" env-default:"10s"`
+
+	WatchTokenCollection string        `yaml:"watch_token_collection" env-default:"watch_tokens"`
+	AlertPollInterval    time.Duration `yaml:"alert_poll_interval" env-default:"2s"`
+	// NotifyWebhooks — каналы уведомлений: имя канала -> URL
+	NotifyWebhooks map[string]string `yaml:"notify_webhooks"`
+
+	EscalationPolicies []escalation.Policy `yaml:"escalation_policies" reload:"true"`
+	EscalationInterval time.Duration       `yaml:"escalation_interval" env-default:"30s"`
+
+	// Полосы предупреждения лежат внутри критических порогов: показание за порогом
+	// предупреждения даёт алерт warning, за критическим — critical. Порог, равный
+	// критическому, отключает полосу.
+	WarningLowPressure     float32 `yaml:"warning_low_pressure" env-default:"0.04" reload:"true"`
+	WarningHighPressure    float32 `yaml:"warning_high_pressure" env-default:"0.06" reload:"true"`
+	WarningLowTemperature  float32 `yaml:"warning_low_temperature" env-default:"8" reload:"true"`
+	WarningHighTemperature float32 `yaml:"warning_high_temperature" env-default:"40" reload:"true"`
+	// SustainedSeverity — важность алертов о быстром изменении давления
+	SustainedSeverity alert.Severity `yaml:"sustained_severity" env-default:"warning" reload:"true"`
+
+	Tracing tracing.Config `yaml:"tracing"`
 }
 
 func MustLoad() *Config {
@@ -27,9 +73,146 @@ func MustLoad() *Config {
 	if _, err := os.Stat(configPath); err != nil {
 		panic(fmt.Errorf("error opening config file: %s", err))
 	}
+	cfg, err := Load(configPath)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+func Load(path string) (*Config, error) {
 	var cfg Config
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic(fmt.Errorf("error reading config file: %s", err))
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &cfg, nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Partitions <= 0 {
+		errs = append(errs, fmt.Errorf("partitions must be positive, got %d", c.Partitions))
+	}
+	if c.LeaseTTL <= 0 {
+		errs = append(errs, fmt.Errorf("lease_ttl must be positive, got %s", c.LeaseTTL))
+	}
+	if c.SustainedCount < 2 {
+		errs = append(errs, fmt.Errorf("sustained_count must be at least 2, got %d", c.SustainedCount))
+	}
+	if c.DeltaPressure <= 0 {
+		errs = append(errs, fmt.Errorf("delta_pressure must be positive, got %v", c.DeltaPressure))
+	}
+	if c.LowPressure >= c.HighPressure {
+		errs = append(errs, fmt.Errorf("low_pressure (%v) must be below high_pressure (%v)", c.LowPressure, c.HighPressure))
+	}
+	if c.LowTemperature >= c.HighTemperature {
+		errs = append(errs, fmt.Errorf("low_temperature (%v) must be below high_temperature (%v)", c.LowTemperature, c.HighTemperature))
+	}
+	if c.WarningLowPressure < c.LowPressure || c.WarningHighPressure > c.HighPressure || c.WarningLowPressure >= c.WarningHighPressure {
+		errs = append(errs, fmt.Errorf("warning pressure band [%v, %v] must lie within critical limits [%v, %v]",
+			c.WarningLowPressure, c.WarningHighPressure, c.LowPressure, c.HighPressure))
+	}
+	if c.WarningLowTemperature < c.LowTemperature || c.WarningHighTemperature > c.HighTemperature || c.WarningLowTemperature >= c.WarningHighTemperature {
+		errs = append(errs, fmt.Errorf("warning temperature band [%v, %v] must lie within critical limits [%v, %v]",
+			c.WarningLowTemperature, c.WarningHighTemperature, c.LowTemperature, c.HighTemperature))
+	}
+	if !c.SustainedSeverity.Valid() {
+		errs = append(errs, fmt.Errorf("unknown sustained_severity %q", c.SustainedSeverity))
+	}
+	if c.SilenceRefreshInterval <= 0 {
+		errs = append(errs, fmt.Errorf("silence_refresh_interval must be positive, got %s", c.SilenceRefreshInterval))
+	}
+	for name, u := range c.NotifyWebhooks {
+		if name == "log" {
+			errs = append(errs, errors.New(`notify_webhooks: channel name "log" is reserved`))
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("notify_webhooks.%s: invalid url %q", name, u))
+		}
+	}
+	if c.EscalationInterval <= 0 {
+		errs = append(errs, fmt.Errorf("escalation_interval must be positive, got %s", c.EscalationInterval))
+	}
+	if err := escalation.Validate(c.EscalationPolicies, c.NotifyChannels()); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// NotifyChannels — имена каналов уведомлений: журнал и настроенные webhook
+func (c *Config) NotifyChannels() []string {
+	names := []string{"log"}
+	for name := range c.NotifyWebhooks {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Change описывает изменение одного параметра конфигурации
+type Change struct {
+	Field      string
+	Old        any
+	New        any
+	Reloadable bool // можно применить без перезапуска
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.Old, c.New)
+}
+
+// Diff возвращает список изменившихся параметров, поля называются по ключам yaml
+func Diff(old, new *Config) []Change {
+	var changes []Change
+
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(new).Elem()
+	t := ov.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		o, n := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		f := t.Field(i)
+		changes = append(changes, Change{
+			Field:      f.Tag.Get("yaml"),
+			Old:        o,
+			New:        n,
+			Reloadable: f.Tag.Get("reload") == "true",
+		})
+	}
+	return changes
+}
+
+// WithReloadable возвращает копию c, в которую перенесены параметры из from,
+// допускающие применение без перезапуска
+func (c *Config) WithReloadable(from *Config) *Config {
+	next := *c
+
+	nv := reflect.ValueOf(&next).Elem()
+	fv := reflect.ValueOf(from).Elem()
+	t := nv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("reload") == "true" {
+			nv.Field(i).Set(fv.Field(i))
+		}
+	}
+	return &next
+}
+
+// CheckReload проверяет, что next можно применить к работающему процессу. Каналы
+// уведомлений создаются при запуске, поэтому политики эскалации могут ссылаться
+// только на уже работающие каналы; новый webhook требует перезапуска.
+func (c *Config) CheckReload(next *Config) error {
+	if err := escalation.Validate(next.EscalationPolicies, c.NotifyChannels()); err != nil {
+		return fmt.Errorf("escalation policies need a restart to use new channels: %w", err)
 	}
-	return &cfg
+	return nil
 }
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch перечитывает файл конфигурации при его изменении или по сигналу SIGHUP.
+// onChange вызывается только для валидных конфигураций; ошибки чтения логируются,
+// а предыдущая конфигурация продолжает действовать.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func(*Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, _ := os.Stat(path)
+
+	reload := func(reason string) {
+		cfg, err := Load(path)
+		if err != nil {
+			slog.Error("config reload failed, keeping current config", "path", path, "reason", reason, "err", err)
+			return
+		}
+		slog.Info("config reloaded", "path", path, "reason", reason)
+		onChange(cfg)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last, _ = os.Stat(path)
+			reload("sighup")
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			reload("file changed")
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package downlink — клиентская сторона протокола команд (см. пакет command):
+// опрос команд, выполнение и подтверждение. Используется симулятором и шлюзами.
+package downlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+)
+
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// NewClient создаёт клиент для контроллера по адресу base (например, http://controller:8080).
+// wait — сколько контроллер держит запрос опроса, если команд нет.
+func NewClient(base string, wait time.Duration) *Client {
+	return &Client{
+		base: base,
+		// таймаут с запасом на long polling
+		http: &http.Client{Timeout: wait + 10*time.Second},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Poll забирает команды устройства, ожидая их появления до wait
+func (c *Client) Poll(ctx context.Context, deviceID int, wait time.Duration) ([]command.Command, error) {
+	path := fmt.Sprintf("/devices/%d/commands?wait=%s", deviceID, url.QueryEscape(wait.String()))
+	var commands []command.Command
+	err := c.do(ctx, http.MethodGet, path, nil, &commands)
+	return commands, err
+}
+
+// Ack сообщает контроллеру результат выполнения команды
+func (c *Client) Ack(ctx context.Context, deviceID int, id string, ack command.Ack) error {
+	path := fmt.Sprintf("/devices/%d/commands/%s/ack", deviceID, url.PathEscape(id))
+	return c.do(ctx, http.MethodPost, path, ack, nil)
+}
+
+// Create ставит команду устройству (сторона платформы)
+func (c *Client) Create(ctx context.Context, deviceID int, req command.Request) (command.Command, error) {
+	var cmd command.Command
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/devices/%d/commands", deviceID), req, &cmd)
+	return cmd, err
+}
+
+// Executor выполняет команду на устройстве. Ошибка означает, что команда отклонена.
+type Executor func(ctx context.Context, c command.Command) error
+
+// Run опрашивает команды устройства, пока не отменён ctx, выполняет их по порядку и
+// подтверждает. Недоступность контроллера пережидается с экспоненциальной задержкой.
+func Run(ctx context.Context, client *Client, deviceID int, wait time.Duration, exec Executor) {
+	const (
+		minBackoff = time.Second
+		maxBackoff = time.Minute
+	)
+	backoff := minBackoff
+
+	for ctx.Err() == nil {
+		commands, err := client.Poll(ctx, deviceID, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.WarnContext(ctx, "poll commands error", "device_id", deviceID, "err", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = minBackoff
+
+		for _, c := range commands {
+			ack := command.Ack{Status: command.StatusSucceeded}
+			if err := exec(ctx, c); err != nil {
+				ack = command.Ack{Status: command.StatusFailed, Error: err.Error()}
+			}
+			slog.InfoContext(ctx, "command executed", "device_id", deviceID, "command_id", c.ID,
+				"type", c.Type, "status", ack.Status, "error", ack.Error)
+
+			// неподтверждённая команда придёт повторно после таймаута контроллера
+			if err := client.Ack(ctx, deviceID, c.ID, ack); err != nil {
+				slog.WarnContext(ctx, "ack command error", "device_id", deviceID, "command_id", c.ID, "err", err)
+			}
+		}
+	}
+}
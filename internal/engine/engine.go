@@ -3,62 +3,131 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"math"
-	"sort"
 	"sync"
 	"time"
 
 	config "github.com/pochkachaiki/iot4gds/internal/config/rule_engine"
+	"github.com/pochkachaiki/iot4gds/internal/escalation"
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
 	"github.com/pochkachaiki/iot4gds/internal/models/packet"
-	amqp "github.com/rabbitmq/amqp091-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/pochkachaiki/iot4gds/internal/models/silence"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const (
-	lowPressure  = 0.03
-	highPressure = 0.07
-	lowTemp      = 5.0
-	highTemp     = 40.0
-)
-
-type RecentPacket struct {
-	Timestamp string  `bson:"timestamp"`
-	Pressure  float32 `bson:"pressure"`
-}
-
 // type Engine struct {
 // 	cfg        *config.Config
 // 	packetColl *mongo.Collection
 // 	alertColl  *mongo.Collection
 // }
 
+// Silencer находит тишину, под которую попадает алерт
+type Silencer interface {
+	Match(a alert.Alert, groups map[string][]int) (silence.Silence, bool)
+}
+
+var alertsSilenced = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "engine_alerts_silenced_total",
+		Help: "Total number of alerts recorded under an active silence",
+	},
+	[]string{"rule"},
+)
+
+var alertsRaised = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "engine_alerts_raised_total",
+		Help: "Total number of open alerts raised to a higher severity instead of creating a new alert",
+	},
+	[]string{"rule", "severity"},
+)
+
 type Engine struct {
 	cfg         *config.Config
-	packetColl  *mongo.Collection
-	alertColl   *mongo.Collection
+	packets     storage.PacketStore
+	alerts      storage.AlertStore
+	silences    Silencer
 	mu          sync.RWMutex
 	recentCache map[int][]packet.Packet
 }
 
-func New(cfg *config.Config, packetColl, alertColl *mongo.Collection) *Engine {
+// New создаёт движок правил. packets может быть nil — тогда окно sustained-правила
+// строится только из кэша, без обращения к бд.
+func New(cfg *config.Config, packets storage.PacketStore, alerts storage.AlertStore) *Engine {
 	return &Engine{
 		cfg:         cfg,
-		packetColl:  packetColl,
-		alertColl:   alertColl,
+		packets:     packets,
+		alerts:      alerts,
 		recentCache: make(map[int][]packet.Packet),
 	}
 }
 
+// UseSilences включает проверку тишин: алерт под тишиной записывается с её
+// идентификатором, и уведомление по нему не отправляется
+func (e *Engine) UseSilences(s Silencer) {
+	e.silences = s
+}
+
+func (e *Engine) config() *config.Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.cfg
+}
+
+// Reload атомарно подменяет пороги правил. Сообщения, уже находящиеся в обработке,
+// дорабатывают со старыми порогами; окна устройств обрезаются до нового размера,
+// а при увеличении окна недостающие значения подтягиваются из бд.
+func (e *Engine) Reload(cfg *config.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changes := config.Diff(e.cfg, cfg)
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, c := range changes {
+		if !c.Reloadable {
+			slog.Warn("config change requires restart", "change", c.String())
+			continue
+		}
+		slog.Info("config change applied", "change", c.String())
+	}
+	// параметры подключений и очередей остаются прежними до перезапуска
+	next := e.cfg.WithReloadable(cfg)
+
+	if next.SustainedCount < e.cfg.SustainedCount {
+		for deviceID, queue := range e.recentCache {
+			if len(queue) > next.SustainedCount {
+				e.recentCache[deviceID] = trimWindow(queue, next.SustainedCount)
+			}
+		}
+	}
+	e.cfg = next
+}
+
+func trimWindow(queue []packet.Packet, size int) []packet.Packet {
+	trimmed := make([]packet.Packet, size)
+	copy(trimmed, queue[len(queue)-size:])
+	return trimmed
+}
+
 func (e *Engine) updateCache(p packet.Packet) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	queue := e.recentCache[p.DeviceID]
 	if len(queue) >= e.cfg.SustainedCount {
-		queue = queue[1:]
+		queue = queue[len(queue)-e.cfg.SustainedCount+1:]
 	}
 	queue = append(queue, p)
 	e.recentCache[p.DeviceID] = queue
@@ -80,6 +149,18 @@ func (e *Engine) getRecentPressures(deviceID int) []float32 {
 	return pressures
 }
 
+// ForgetDevices удаляет из кэша окна устройств, для которых match возвращает true
+func (e *Engine) ForgetDevices(match func(deviceID int) bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for deviceID := range e.recentCache {
+		if match(deviceID) {
+			delete(e.recentCache, deviceID)
+		}
+	}
+}
+
 // func New(cfg *config.Config, packetColl, alertColl *mongo.Collection) *Engine {
 // 	return &Engine{
 // 		cfg:        cfg,
@@ -88,10 +169,17 @@ func (e *Engine) getRecentPressures(deviceID int) []float32 {
 // 	}
 // }
 
-func (e *Engine) Run(ctx context.Context, ch *amqp.Channel, queue string) {
-	msgs, err := ch.Consume(queue, "", false, false, false, false, nil)
+// Run читает очередь эксклюзивно: второй потребитель той же партиции получит ошибку,
+// даже если аренды разных реплик временно пересеклись.
+func (e *Engine) Run(ctx context.Context, sub queue.Subscriber, queueName string) {
+	// подписка закрывается только после выхода из цикла, чтобы последнее
+	// обработанное сообщение успело получить ack
+	subCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	msgs, err := sub.Subscribe(subCtx, queueName)
 	if err != nil {
-		slog.Error("consume error", "err", err)
+		slog.Error("consume error", "queue", queueName, "err", err)
 		return
 	}
 
@@ -101,105 +189,180 @@ func (e *Engine) Run(ctx context.Context, ch *amqp.Channel, queue string) {
 			return
 		case msg, ok := <-msgs:
 			if !ok {
-				slog.Error("channel closed")
+				slog.Error("channel closed", "queue", queueName)
 				return
 			}
-			if err := e.processMessage(ctx, msg.Body); err != nil {
-				slog.Error("process message error", "err", err)
-			}
-			if err := msg.Ack(false); err != nil {
-				slog.Error("ack error", "err", err)
-			}
+			e.handleDelivery(ctx, msg)
 		}
 	}
 }
 
-func (e *Engine) processMessage(parentCtx context.Context, body []byte) error {
+// handleDelivery подтверждает сообщение после обработки. При ошибке сообщение
+// один раз возвращается в очередь, при повторной ошибке — отбрасывается.
+func (e *Engine) handleDelivery(ctx context.Context, msg queue.Delivery) {
+	err := e.processMessage(ctx, msg)
+	if err == nil || msg.Redelivered {
+		if err != nil {
+			slog.Error("process message error, dropping message", "err", err)
+		}
+		if err := msg.Ack(); err != nil {
+			slog.Error("ack error", "err", err)
+		}
+		return
+	}
+
+	slog.Error("process message error, requeueing message", "err", err)
+	if err := msg.Nack(true); err != nil {
+		slog.Error("nack error", "err", err)
+	}
+}
+
+// processMessage разбирает пакет из сообщения и прогоняет его через правила в спане,
+// продолжающем трассировку публикации
+func (e *Engine) processMessage(ctx context.Context, msg queue.Delivery) (err error) {
+	ctx = tracing.Extract(ctx, propagation.MapCarrier(msg.Headers))
+	ctx, span := tracing.Start(ctx, "engine process", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Bool("messaging.rabbitmq.redelivered", msg.Redelivered)))
+	defer func() { tracing.End(span, err) }()
+
 	var p packet.Packet
-	if err := json.Unmarshal(body, &p); err != nil {
+	if err := json.Unmarshal(msg.Body, &p); err != nil {
 		return err
 	}
+	span.SetAttributes(attribute.Int("device.id", p.DeviceID))
+	return e.ProcessPacket(ctx, p)
+}
 
+// ProcessPacket прогоняет пакет через все правила и записывает сработавшие алерты
+func (e *Engine) ProcessPacket(parentCtx context.Context, p packet.Packet) error {
+	cfg := e.config()
 	e.updateCache(p) // всегда обновляем кэш
 
 	ctx, cancel := context.WithTimeout(parentCtx, 5*time.Second)
 	defer cancel()
 
-	reason := ""
-	if p.Pressure < lowPressure {
-		reason = "pressure low"
-	} else if p.Pressure > highPressure {
-		reason = "pressure high"
-	} else if p.Temperature <= lowTemp {
-		reason = "temperature low"
-	} else if p.Temperature > highTemp {
-		reason = "temperature high"
-	}
-	if reason != "" {
-		_, err := e.alertColl.InsertOne(ctx, bson.M{
-			"type":        "instant",
-			"device_id":   p.DeviceID,
-			"timestamp":   p.Timestamp,
-			"reason":      reason,
-			"pressure":    p.Pressure,
-			"temperature": p.Temperature,
+	at, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	if rule, severity := classify(cfg, p); rule != "" {
+		a := alert.New(rule, p.DeviceID, at, alert.Values{
+			Pressure:    alert.Float(p.Pressure),
+			Temperature: alert.Float(p.Temperature),
 		})
+		a.Severity = severity
+		a, err := e.insertAlert(ctx, cfg, a)
 		if err != nil {
 			return err
 		}
-		slog.Info("instant alert", "device_id", p.DeviceID, "reason", reason)
+		slog.InfoContext(ctx, "instant alert", "device_id", p.DeviceID, "reason", rule.Reason(), "severity", a.Severity,
+			"alert_id", a.ID.Hex(), "silence_id", a.SilenceID)
 	}
 
 	var pressures []float32
 
 	// Sustained rule — сначала проверяем кэш. Если в кэше нет - идём в бд
 	if pressures = e.getRecentPressures(p.DeviceID); pressures == nil {
-		opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(e.cfg.SustainedCount))
-		cursor, err := e.packetColl.Find(ctx, bson.M{"device_id": p.DeviceID}, opts)
-		if err != nil {
-			return err
+		if e.packets == nil {
+			return nil
 		}
-		defer cursor.Close(ctx)
-
-		var recents []RecentPacket
-		if err := cursor.All(ctx, &recents); err != nil {
+		// пакеты новее текущего не учитываются: при разборе накопившейся очереди
+		// в бд уже могут лежать следующие пакеты устройства
+		recents, err := e.packets.RecentPackets(ctx, p.DeviceID, at, cfg.SustainedCount)
+		if err != nil {
 			return err
 		}
 
-		if len(recents) < e.cfg.SustainedCount {
+		if len(recents) < cfg.SustainedCount {
 			return nil
 		}
 
-		sort.Slice(recents, func(i, j int) bool {
-			ti, _ := time.Parse(time.RFC3339, recents[i].Timestamp)
-			tj, _ := time.Parse(time.RFC3339, recents[j].Timestamp)
-			return ti.Before(tj)
-		})
-
 		for _, r := range recents {
 			pressures = append(pressures, r.Pressure)
 		}
 	}
 
 	change := pressures[len(pressures)-1] - pressures[0]
-	if math.Abs(float64(change)) >= float64(e.cfg.DeltaPressure) {
-		dir := "increase"
+	if math.Abs(float64(change)) >= float64(cfg.DeltaPressure) {
+		rule := alert.RapidPressureIncrease
 		if change < 0 {
-			dir = "decrease"
-		}
-		reason = "rapid pressure " + dir
-		_, err := e.alertColl.InsertOne(ctx, bson.M{
-			"type":      "sustained",
-			"device_id": p.DeviceID,
-			"timestamp": p.Timestamp,
-			"reason":    reason,
-			"change":    change,
-		})
+			rule = alert.RapidPressureDecrease
+		}
+		a := alert.New(rule, p.DeviceID, at, alert.Values{Change: alert.Float(change)})
+		a.Severity = cfg.SustainedSeverity
+		a, err := e.insertAlert(ctx, cfg, a)
 		if err != nil {
 			return err
 		}
-		slog.Info("sustained alert", "device_id", p.DeviceID, "reason", reason, "change", change)
+		slog.InfoContext(ctx, "sustained alert", "device_id", p.DeviceID, "reason", rule.Reason(), "change", change,
+			"severity", a.Severity, "alert_id", a.ID.Hex(), "silence_id", a.SilenceID)
 	}
 
 	return nil
 }
+
+// classify возвращает сработавшее мгновенное правило и его важность. Критический
+// порог важнее полосы предупреждения, при равной важности давление важнее температуры.
+func classify(cfg *config.Config, p packet.Packet) (alert.Rule, alert.Severity) {
+	checks := []struct {
+		rule              alert.Rule
+		critical, warning bool
+	}{
+		{alert.PressureLow, p.Pressure < cfg.LowPressure, p.Pressure < cfg.WarningLowPressure},
+		{alert.PressureHigh, p.Pressure > cfg.HighPressure, p.Pressure > cfg.WarningHighPressure},
+		{alert.TemperatureLow, p.Temperature <= cfg.LowTemperature, p.Temperature < cfg.WarningLowTemperature},
+		{alert.TemperatureHigh, p.Temperature > cfg.HighTemperature, p.Temperature > cfg.WarningHighTemperature},
+	}
+	for _, c := range checks {
+		if c.critical {
+			return c.rule, alert.Critical
+		}
+	}
+	for _, c := range checks {
+		if c.warning {
+			return c.rule, alert.Warning
+		}
+	}
+	return "", ""
+}
+
+// insertAlert записывает алерт, помечая его тишиной, если она действует. Алерт вне
+// тишины ставится на первый шаг подходящей политики эскалации. Если по устройству и
+// правилу открыт алерт меньшей важности, новый не записывается: важность открытого
+// повышается, а его эскалация начинается заново по политике новой важности.
+func (e *Engine) insertAlert(ctx context.Context, cfg *config.Config, a alert.Alert) (_ alert.Alert, err error) {
+	ctx, span := tracing.Start(ctx, "engine insert alert", trace.WithAttributes(
+		attribute.String("alert.rule", string(a.Rule)),
+		attribute.String("alert.severity", string(a.Severity)),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if e.silences != nil {
+		if s, ok := e.silences.Match(a, cfg.DeviceGroups); ok {
+			a.SilenceID = s.ID.Hex()
+			span.SetAttributes(attribute.String("alert.silence_id", a.SilenceID))
+			alertsSilenced.WithLabelValues(string(a.Rule)).Inc()
+			return a, e.alerts.InsertAlert(ctx, a)
+		}
+	}
+	escalation.Start(cfg.EscalationPolicies, &a, a.CreatedAt)
+
+	if store, ok := e.alerts.(storage.SeverityStore); ok && len(a.Severity.Below()) > 0 {
+		raised, ok, err := store.RaiseSeverity(ctx, a, alert.Event{
+			Action:   alert.Raise,
+			Operator: "engine",
+			At:       a.CreatedAt,
+			Comment:  fmt.Sprintf("%s threshold crossed at %s", a.Severity, a.Timestamp.Format(time.RFC3339)),
+		})
+		if err != nil {
+			return a, err
+		}
+		if ok {
+			span.SetAttributes(attribute.Bool("alert.raised", true))
+			alertsRaised.WithLabelValues(string(a.Rule), string(a.Severity)).Inc()
+			return raised, nil
+		}
+	}
+	return a, e.alerts.InsertAlert(ctx, a)
+}
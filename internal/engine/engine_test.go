@@ -0,0 +1,340 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/rule_engine"
+	"github.com/pochkachaiki/iot4gds/internal/handler"
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/models/silence"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		SustainedCount:  3,
+		DeltaPressure:   0.02,
+		LowPressure:     0.03,
+		HighPressure:    0.07,
+		LowTemperature:  5,
+		HighTemperature: 40,
+
+		// полосы предупреждения совпадают с критическими порогами и не срабатывают
+		WarningLowPressure:     0.03,
+		WarningHighPressure:    0.07,
+		WarningLowTemperature:  5,
+		WarningHighTemperature: 40,
+		SustainedSeverity:      alert.Warning,
+	}
+}
+
+func pkt(deviceID, sec int, pressure, temperature float32) packet.Packet {
+	return packet.Packet{
+		DeviceID:    deviceID,
+		Timestamp:   fmt.Sprintf("2025-01-01T00:00:%02dZ", sec),
+		Pressure:    pressure,
+		Temperature: temperature,
+	}
+}
+
+func reasons(alerts *storage.MemoryAlertStore) []string {
+	var out []string
+	for _, a := range alerts.Alerts() {
+		out = append(out, a.Reason)
+	}
+	return out
+}
+
+func TestInstantRules(t *testing.T) {
+	tests := []struct {
+		name string
+		p    packet.Packet
+		want string
+	}{
+		{"normal", pkt(1, 0, 0.05, 20), ""},
+		{"pressure low", pkt(1, 0, 0.02, 20), "pressure low"},
+		{"pressure high", pkt(1, 0, 0.08, 20), "pressure high"},
+		{"temperature low", pkt(1, 0, 0.05, 5), "temperature low"},
+		{"temperature high", pkt(1, 0, 0.05, 41), "temperature high"},
+		{"pressure wins over temperature", pkt(1, 0, 0.08, 41), "pressure high"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := storage.NewMemoryAlertStore()
+			e := New(testConfig(), nil, alerts)
+
+			if err := e.ProcessPacket(context.Background(), tt.p); err != nil {
+				t.Fatalf("process: %v", err)
+			}
+
+			got := reasons(alerts)
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Errorf("got alerts %v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got alerts %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeverities(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig()
+	cfg.WarningLowPressure, cfg.WarningHighPressure = 0.04, 0.06
+	cfg.WarningLowTemperature = 8
+
+	alerts := storage.NewMemoryAlertStore()
+	e := New(cfg, nil, alerts)
+
+	for _, p := range []packet.Packet{
+		pkt(1, 0, 0.065, 20), // предупреждение по давлению
+		pkt(2, 0, 0.05, 6),   // предупреждение по температуре
+		pkt(1, 1, 0.08, 20),  // открытое предупреждение повышается до критического
+		pkt(1, 2, 0.065, 20), // критический алерт уже открыт, новое предупреждение
+	} {
+		if err := e.ProcessPacket(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, a := range alerts.Alerts() {
+		got = append(got, fmt.Sprintf("%d %s %s", a.DeviceID, a.Rule, a.Severity))
+	}
+	want := []string{
+		"1 pressure_high critical",
+		"2 temperature_low warning",
+		"1 pressure_high warning",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("alerts = %q, want %q", got, want)
+	}
+
+	raised := alerts.Alerts()[0]
+	crossed := time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC)
+	if *raised.Values.Pressure != 0.08 || !raised.Timestamp.Equal(crossed) || len(raised.History) != 1 || raised.History[0].Action != alert.Raise {
+		t.Errorf("raised alert = %+v", raised)
+	}
+}
+
+func TestSustainedRuleFromCache(t *testing.T) {
+	alerts := storage.NewMemoryAlertStore()
+	e := New(testConfig(), nil, alerts)
+	ctx := context.Background()
+
+	for i, pressure := range []float32{0.04, 0.05, 0.065} {
+		if err := e.ProcessPacket(ctx, pkt(1, i, pressure, 20)); err != nil {
+			t.Fatalf("process: %v", err)
+		}
+	}
+	got := reasons(alerts)
+	if len(got) != 1 || got[0] != "rapid pressure increase" {
+		t.Fatalf("got alerts %v, want [rapid pressure increase]", got)
+	}
+
+	// другое устройство не должно видеть окно первого
+	if err := e.ProcessPacket(ctx, pkt(2, 3, 0.04, 20)); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if n := len(alerts.Alerts()); n != 1 {
+		t.Errorf("got %d alerts after other device packet, want 1", n)
+	}
+}
+
+func TestSustainedRuleFallsBackToStore(t *testing.T) {
+	ctx := context.Background()
+	packets := storage.NewMemoryPacketStore()
+	alerts := storage.NewMemoryAlertStore()
+
+	history := []packet.Packet{pkt(1, 0, 0.06, 20), pkt(1, 1, 0.05, 20), pkt(1, 2, 0.035, 20)}
+	for _, p := range history {
+		packets.InsertPacket(ctx, p)
+	}
+
+	e := New(testConfig(), packets, alerts)
+	// пакет уже сохранён контроллером, в кэше движка истории нет
+	if err := e.ProcessPacket(ctx, history[2]); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+
+	got := reasons(alerts)
+	if len(got) != 1 || got[0] != "rapid pressure decrease" {
+		t.Errorf("got alerts %v, want [rapid pressure decrease]", got)
+	}
+}
+
+func TestReloadResizesWindows(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig()
+	cfg.SustainedCount = 5
+	e := New(cfg, nil, storage.NewMemoryAlertStore())
+
+	for i := 0; i < 5; i++ {
+		e.ProcessPacket(ctx, pkt(1, i, 0.05, 20))
+	}
+
+	next := testConfig()
+	next.SustainedCount = 2
+	next.DeltaPressure = 0.5
+	e.Reload(next)
+
+	if got := e.config(); got.SustainedCount != 2 || got.DeltaPressure != 0.5 {
+		t.Errorf("config after reload = %+v", got)
+	}
+	if n := len(e.getRecentPressures(1)); n != 2 {
+		t.Errorf("window size after shrink = %d, want 2", n)
+	}
+
+	grown := testConfig()
+	grown.SustainedCount = 4
+	e.Reload(grown)
+	if w := e.getRecentPressures(1); w != nil {
+		t.Errorf("window after grow = %v, want nil until it is refilled", w)
+	}
+}
+
+func TestReloadKeepsNonReloadableFields(t *testing.T) {
+	cfg := testConfig()
+	cfg.MongoURI = "mongodb://old"
+	e := New(cfg, nil, storage.NewMemoryAlertStore())
+
+	next := testConfig()
+	next.MongoURI = "mongodb://new"
+	next.HighPressure = 0.09
+	e.Reload(next)
+
+	got := e.config()
+	if got.MongoURI != "mongodb://old" {
+		t.Errorf("mongo_uri changed to %q", got.MongoURI)
+	}
+	if got.HighPressure != 0.09 {
+		t.Errorf("high_pressure = %v, want 0.09", got.HighPressure)
+	}
+}
+
+func TestForgetDevices(t *testing.T) {
+	ctx := context.Background()
+	e := New(testConfig(), nil, storage.NewMemoryAlertStore())
+	for i := 0; i < 3; i++ {
+		e.ProcessPacket(ctx, pkt(1, i, 0.05, 20))
+		e.ProcessPacket(ctx, pkt(2, i, 0.05, 20))
+	}
+
+	e.ForgetDevices(func(deviceID int) bool { return deviceID == 1 })
+
+	if w := e.getRecentPressures(1); w != nil {
+		t.Errorf("device 1 window = %v, want nil", w)
+	}
+	if w := e.getRecentPressures(2); len(w) != 3 {
+		t.Errorf("device 2 window = %v, want 3 values", w)
+	}
+}
+
+func TestSilences(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig()
+	cfg.DeviceGroups = map[string][]int{"station-7": {3, 4}}
+
+	start := time.Date(2025, 1, 1, 0, 0, 10, 0, time.UTC)
+	silences := staticSilences{
+		{ID: primitive.NewObjectID(), Groups: []string{"station-7"}, StartsAt: start, EndsAt: start.Add(time.Minute)},
+		{ID: primitive.NewObjectID(), Devices: []int{1}, Rules: []alert.Rule{alert.PressureLow}, StartsAt: start, EndsAt: start.Add(time.Minute)},
+	}
+
+	alerts := storage.NewMemoryAlertStore()
+	e := New(cfg, nil, alerts)
+	e.UseSilences(silences)
+
+	for _, p := range []packet.Packet{
+		pkt(3, 5, 0.02, 20),  // до начала окна
+		pkt(3, 15, 0.02, 20), // группа под тишиной
+		pkt(5, 15, 0.02, 20), // устройство вне группы
+		pkt(1, 15, 0.02, 20), // устройство и правило под тишиной
+		pkt(1, 16, 0.08, 20), // другое правило
+	} {
+		if err := e.ProcessPacket(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var silenced []bool
+	for _, a := range alerts.Alerts() {
+		silenced = append(silenced, a.SilenceID != "")
+	}
+	if want := []bool{false, true, false, true, false}; !slices.Equal(silenced, want) {
+		t.Errorf("silenced = %v, want %v", silenced, want)
+	}
+}
+
+type staticSilences []silence.Silence
+
+func (s staticSilences) Match(a alert.Alert, groups map[string][]int) (silence.Silence, bool) {
+	for _, sl := range s {
+		if sl.Matches(a, groups) {
+			return sl, true
+		}
+	}
+	return silence.Silence{}, false
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	broker := queue.NewMemoryBroker()
+	broker.DeclarePartitions("packets", 1)
+	h := handler.New(storage.NewMemoryPacketStore(), broker, 1)
+
+	body := `{"device_id":1,"timestamp":"2025-01-01T00:00:00Z","pressure":0.08,"temperature":20}`
+	rec := httptest.NewRecorder()
+	h.HandlePacket(rec, httptest.NewRequest(http.MethodPost, "/packets", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs, err := broker.Subscribe(ctx, queue.PartitionQueue("packets", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(testConfig(), nil, storage.NewMemoryAlertStore())
+	e.handleDelivery(ctx, <-msgs)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	root, process, insert := spans["POST /packets"], spans["engine process"], spans["engine insert alert"]
+	if root == nil || process == nil || insert == nil {
+		t.Fatalf("spans = %v", slices.Collect(maps.Keys(spans)))
+	}
+	if process.Parent().SpanID() != root.SpanContext().SpanID() || insert.Parent().SpanID() != process.SpanContext().SpanID() {
+		t.Errorf("engine spans are not linked to the request trace")
+	}
+	if insert.SpanContext().TraceID() != root.SpanContext().TraceID() {
+		t.Errorf("trace id = %s, want %s", insert.SpanContext().TraceID(), root.SpanContext().TraceID())
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+)
+
+const consumerRetry = time.Second
+
+type partitionConsumer struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// PartitionRunner запускает по отдельному потребителю на каждую назначенную партицию.
+// Реализует cluster.Handler.
+type PartitionRunner struct {
+	engine     *Engine
+	sub        queue.Subscriber
+	prefix     string
+	partitions int
+
+	mu        sync.Mutex
+	consumers map[int]*partitionConsumer
+}
+
+func NewPartitionRunner(e *Engine, sub queue.Subscriber, prefix string, partitions int) *PartitionRunner {
+	return &PartitionRunner{
+		engine:     e,
+		sub:        sub,
+		prefix:     prefix,
+		partitions: partitions,
+		consumers:  make(map[int]*partitionConsumer),
+	}
+}
+
+func (r *PartitionRunner) Assign(ctx context.Context, partition int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.consumers[partition]; ok {
+		return
+	}
+
+	// контекст партиции не должен зависеть от короткого контекста ребалансировки
+	pctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &partitionConsumer{cancel: cancel, done: make(chan struct{})}
+	r.consumers[partition] = c
+
+	go func() {
+		defer close(c.done)
+		r.consume(pctx, partition)
+	}()
+}
+
+func (r *PartitionRunner) Revoke(partition int) {
+	r.mu.Lock()
+	c, ok := r.consumers[partition]
+	delete(r.consumers, partition)
+	r.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	c.cancel()
+	<-c.done
+
+	// окна других партиций не трогаем; при повторном назначении окно восстановится из бд
+	r.engine.ForgetDevices(func(deviceID int) bool {
+		return queue.Partition(deviceID, r.partitions) == partition
+	})
+}
+
+func (r *PartitionRunner) consume(ctx context.Context, partition int) {
+	name := queue.PartitionQueue(r.prefix, partition)
+
+	for {
+		slog.Info("partition consumer started", "queue", name)
+		// неподтверждённые сообщения вернутся в очередь при отписке
+		r.engine.Run(ctx, r.sub, name)
+
+		select {
+		case <-ctx.Done():
+			slog.Info("partition consumer stopped", "queue", name)
+			return
+		case <-time.After(consumerRetry):
+		}
+	}
+}
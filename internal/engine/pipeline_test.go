@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/handler"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+// Контроллер и движок правил в одном процессе: HTTP -> хранилище -> брокер -> правила -> алерты
+func TestPipeline(t *testing.T) {
+	const partitions = 4
+
+	packets := storage.NewMemoryPacketStore()
+	alerts := storage.NewMemoryAlertStore()
+	broker := queue.NewMemoryBroker()
+	broker.DeclarePartitions("packets", partitions)
+
+	h := handler.New(packets, broker, partitions)
+	e := New(testConfig(), packets, alerts)
+	runner := NewPartitionRunner(e, broker, "packets", partitions)
+
+	ctx := context.Background()
+	for p := 0; p < partitions; p++ {
+		runner.Assign(ctx, p)
+	}
+	defer func() {
+		for p := 0; p < partitions; p++ {
+			runner.Revoke(p)
+		}
+	}()
+
+	post := func(deviceID, sec int, pressure float32) {
+		body := fmt.Sprintf(`{"device_id":%d,"timestamp":"2025-01-01T00:00:%02dZ","pressure":%v,"temperature":20}`,
+			deviceID, sec, pressure)
+		rec := httptest.NewRecorder()
+		h.HandlePacket(rec, httptest.NewRequest(http.MethodPost, "/packets", strings.NewReader(body)))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+		}
+	}
+
+	post(1, 0, 0.05)
+	post(2, 0, 0.08) // pressure high
+	post(1, 1, 0.06)
+	post(1, 2, 0.075) // pressure high + rapid pressure increase
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(alerts.Alerts()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got := map[string]int{}
+	for _, a := range alerts.Alerts() {
+		got[fmt.Sprintf("%d/%s", a.DeviceID, a.Reason)]++
+	}
+	want := map[string]int{
+		"2/pressure high":           1,
+		"1/pressure high":           1,
+		"1/rapid pressure increase": 1,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("alerts = %v, want %v", got, want)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("alerts[%s] = %d, want %d", k, got[k], n)
+		}
+	}
+	if n := len(packets.Packets(1)); n != 3 {
+		t.Errorf("stored %d packets for device 1, want 3", n)
+	}
+}
@@ -0,0 +1,244 @@
+// Package escalation повторно уведомляет о неподтверждённых алертах по шагам политики.
+// Положение алерта в политике хранится в самом документе алерта, поэтому после
+// перезапуска планировщик продолжает с того же шага.
+package escalation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/notify"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// batchSize — сколько наступивших шагов обрабатывается за проход
+const batchSize = 100
+
+var escalationsSent = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "escalation_steps_total",
+		Help: "Total number of escalation steps executed, by policy and step",
+	},
+	[]string{"policy", "step"},
+)
+
+// Step — через After после начала эскалации уведомить каналы Channels
+type Step struct {
+	After    time.Duration `yaml:"after" json:"after"`
+	Channels []string      `yaml:"channels" json:"channels"`
+}
+
+// Policy применяется к алерту, если подходят правило и важность (пустое условие —
+// любое значение). Из нескольких подходящих политик берётся первая по порядку.
+type Policy struct {
+	Name       string           `yaml:"name" json:"name"`
+	Rules      []alert.Rule     `yaml:"rules" json:"rules"`
+	Severities []alert.Severity `yaml:"severities" json:"severities"`
+	Steps      []Step           `yaml:"steps" json:"steps"`
+}
+
+func (p Policy) Matches(a alert.Alert) bool {
+	return (len(p.Rules) == 0 || slices.Contains(p.Rules, a.Rule)) &&
+		(len(p.Severities) == 0 || slices.Contains(p.Severities, a.Severity))
+}
+
+// Validate проверяет политики; channels — имена настроенных каналов уведомлений
+func Validate(policies []Policy, channels []string) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, p := range policies {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("escalation_policies[%d]: name is required", i))
+		} else if seen[p.Name] {
+			errs = append(errs, fmt.Errorf("escalation policy %q is defined twice", p.Name))
+		}
+		seen[p.Name] = true
+		if len(p.Steps) == 0 {
+			errs = append(errs, fmt.Errorf("escalation policy %q has no steps", p.Name))
+		}
+		for _, r := range p.Rules {
+			if !r.Valid() {
+				errs = append(errs, fmt.Errorf("escalation policy %q: unknown rule %q", p.Name, r))
+			}
+		}
+		var prev time.Duration
+		for j, s := range p.Steps {
+			if s.After < prev {
+				errs = append(errs, fmt.Errorf("escalation policy %q: step %d is earlier than the previous one", p.Name, j))
+			}
+			prev = s.After
+			if len(s.Channels) == 0 {
+				errs = append(errs, fmt.Errorf("escalation policy %q: step %d has no channels", p.Name, j))
+			}
+			for _, ch := range s.Channels {
+				if !slices.Contains(channels, ch) {
+					errs = append(errs, fmt.Errorf("escalation policy %q: unknown channel %q", p.Name, ch))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start ставит алерт на первый шаг подходящей политики, отсчитывая задержки от since.
+// Если подходящей политики нет, эскалация снимается.
+func Start(policies []Policy, a *alert.Alert, since time.Time) {
+	a.Escalation = nil
+	for _, p := range policies {
+		if !p.Matches(*a) || len(p.Steps) == 0 {
+			continue
+		}
+		next := since.Add(p.Steps[0].After)
+		a.Escalation = &alert.Escalation{Policy: p.Name, Since: since, NextAt: &next}
+		return
+	}
+}
+
+// Scheduler раз в интервал находит алерты с наступившим шагом, уведомляет каналы
+// шага и переводит алерт на следующий. Уведомление отправляется до записи шага, так
+// что при сбое между ними шаг повторится — «хотя бы один раз». Подтверждённые и
+// закрытые алерты не эскалируются; после снятия подтверждения эскалация продолжается.
+// Если подтверждён другой алерт того же устройства и правила, эскалация завершается,
+// как и уведомления в notify: о проблеме уже знают.
+type Scheduler struct {
+	alerts   storage.EscalationStore
+	channels map[string]notify.Channel
+	interval time.Duration
+
+	mu       sync.RWMutex
+	policies []Policy
+}
+
+func NewScheduler(alerts storage.EscalationStore, channels []notify.Channel, policies []Policy, interval time.Duration) *Scheduler {
+	byName := make(map[string]notify.Channel, len(channels))
+	for _, ch := range channels {
+		byName[ch.Name()] = ch
+	}
+	return &Scheduler{alerts: alerts, channels: byName, policies: policies, interval: interval}
+}
+
+// SetPolicies подменяет политики. Алерты, уже стоящие в политике, продолжают по её
+// новой версии; если политику удалили, их эскалация завершается.
+func (s *Scheduler) SetPolicies(policies []Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+}
+
+func (s *Scheduler) policy(name string) (Policy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.policies {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	slog.Info("escalation scheduler started", "interval", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("escalation tick error", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("escalation scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick выполняет шаги, наступившие к now. За проход обрабатывается не больше
+// batchSize алертов, остальные дождутся следующего.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) error {
+	due, err := s.alerts.DueEscalations(ctx, now, batchSize)
+	if err != nil {
+		return err
+	}
+	for _, a := range due {
+		if err := s.escalate(ctx, a, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) escalate(ctx context.Context, a alert.Alert, now time.Time) error {
+	esc := a.Escalation
+	p, ok := s.policy(esc.Policy)
+	if !ok || esc.Step >= len(p.Steps) {
+		// политику удалили или укоротили — дальше эскалировать некуда
+		slog.Warn("escalation policy step not found", "alert_id", a.ID.Hex(), "policy", esc.Policy, "step", esc.Step)
+		_, err := s.alerts.AdvanceEscalation(ctx, a.ID, esc.Step, nil, alert.Event{
+			Action: alert.Escalate, Operator: "escalation", At: now.UTC(),
+			Comment: fmt.Sprintf("policy %s has no step %d, escalation stopped", esc.Policy, esc.Step+1),
+		})
+		return err
+	}
+
+	acked, err := s.alerts.CountAlerts(ctx, storage.AlertQuery{
+		Devices:    []int{a.DeviceID},
+		Rules:      []alert.Rule{a.Rule},
+		States:     []alert.State{alert.Acknowledged},
+		Severities: a.Severity.AtLeast(),
+		Limit:      1,
+	})
+	if err != nil {
+		return err
+	}
+	if acked > 0 {
+		slog.Info("escalation stopped by acknowledged alert", "alert_id", a.ID.Hex(), "device_id", a.DeviceID, "rule", a.Rule)
+		_, err := s.alerts.AdvanceEscalation(ctx, a.ID, esc.Step, nil, alert.Event{
+			Action: alert.Escalate, Operator: "escalation", At: now.UTC(),
+			Comment: fmt.Sprintf("alert of device %d rule %s is acknowledged, escalation stopped", a.DeviceID, a.Rule),
+		})
+		return err
+	}
+
+	step := p.Steps[esc.Step]
+	for _, name := range step.Channels {
+		ch, ok := s.channels[name]
+		if !ok {
+			slog.Error("escalation channel not configured", "policy", p.Name, "channel", name)
+			continue
+		}
+		if err := ch.Send(ctx, a); err != nil {
+			slog.Error("escalation notification error", "alert_id", a.ID.Hex(), "policy", p.Name, "channel", name, "err", err)
+		}
+	}
+
+	var next *time.Time
+	if esc.Step+1 < len(p.Steps) {
+		t := esc.Since.Add(p.Steps[esc.Step+1].After)
+		next = &t
+	}
+	advanced, err := s.alerts.AdvanceEscalation(ctx, a.ID, esc.Step, next, alert.Event{
+		Action:   alert.Escalate,
+		Operator: "escalation",
+		At:       now.UTC(),
+		Comment:  fmt.Sprintf("policy %s step %d: %s", p.Name, esc.Step+1, strings.Join(step.Channels, ", ")),
+	})
+	if err != nil {
+		return err
+	}
+	if advanced {
+		escalationsSent.WithLabelValues(p.Name, fmt.Sprint(esc.Step+1)).Inc()
+		slog.Info("alert escalated", "alert_id", a.ID.Hex(), "device_id", a.DeviceID, "rule", a.Rule,
+			"policy", p.Name, "step", esc.Step+1, "channels", step.Channels)
+	}
+	return nil
+}
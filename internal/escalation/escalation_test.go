@@ -0,0 +1,147 @@
+package escalation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/notify"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+type recorder struct {
+	name string
+	sent *[]string
+}
+
+func (r recorder) Name() string { return r.name }
+
+func (r recorder) Send(_ context.Context, a alert.Alert) error {
+	*r.sent = append(*r.sent, r.name+":"+a.ID.Hex()[18:])
+	return nil
+}
+
+var policies = []Policy{
+	{Name: "critical-pressure", Rules: []alert.Rule{alert.PressureHigh}, Severities: []alert.Severity{alert.Critical}, Steps: []Step{
+		{After: 5 * time.Minute, Channels: []string{"oncall"}},
+		{After: 15 * time.Minute, Channels: []string{"oncall", "supervisor"}},
+	}},
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryAlertStore()
+	var sent []string
+	channels := []notify.Channel{recorder{"oncall", &sent}, recorder{"supervisor", &sent}}
+
+	a := alert.New(alert.PressureHigh, 1, time.Now(), alert.Values{})
+	Start(policies, &a, a.CreatedAt)
+	other := alert.New(alert.TemperatureHigh, 1, time.Now(), alert.Values{})
+	Start(policies, &other, other.CreatedAt)
+	if a.Escalation == nil || other.Escalation != nil {
+		t.Fatalf("escalation = %+v / %+v", a.Escalation, other.Escalation)
+	}
+	store.InsertAlert(ctx, a)
+	store.InsertAlert(ctx, other)
+	id := a.ID.Hex()[18:]
+	created := a.CreatedAt
+
+	s := NewScheduler(store, channels, policies, time.Minute)
+	tick := func(after time.Duration) {
+		t.Helper()
+		if err := s.Tick(ctx, created.Add(after)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tick(time.Minute)
+	if len(sent) != 0 {
+		t.Fatalf("sent before the first step: %v", sent)
+	}
+	tick(6 * time.Minute)
+	tick(7 * time.Minute)
+	if strings.Join(sent, " ") != "oncall:"+id {
+		t.Fatalf("after step 1: %v", sent)
+	}
+
+	// подтверждённый алерт не эскалируется, после снятия подтверждения — продолжает
+	store.ApplyAlertEvent(ctx, a.ID.Hex(), alert.Event{Action: alert.Ack, Operator: "ivanov", At: created})
+	tick(20 * time.Minute)
+	if len(sent) != 1 {
+		t.Fatalf("acknowledged alert escalated: %v", sent)
+	}
+	store.ApplyAlertEvent(ctx, a.ID.Hex(), alert.Event{Action: alert.Unack, Operator: "ivanov", At: created})
+
+	// новый планировщик продолжает с сохранённого шага
+	s = NewScheduler(store, channels, policies, time.Minute)
+	tick(21 * time.Minute)
+	tick(60 * time.Minute)
+	if want := "oncall:" + id + " oncall:" + id + " supervisor:" + id; strings.Join(sent, " ") != want {
+		t.Errorf("sent = %v, want %s", sent, want)
+	}
+
+	got, _ := store.GetAlert(ctx, a.ID.Hex())
+	if got.Escalation.Step != 2 || got.Escalation.NextAt != nil {
+		t.Errorf("escalation = %+v", got.Escalation)
+	}
+	var escalated int
+	for _, e := range got.History {
+		if e.Action == alert.Escalate {
+			escalated++
+		}
+	}
+	if escalated != 2 {
+		t.Errorf("history has %d escalation events, want 2", escalated)
+	}
+}
+
+func TestSchedulerStopsOnAcknowledged(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryAlertStore()
+	var sent []string
+	channels := []notify.Channel{recorder{"oncall", &sent}, recorder{"supervisor", &sent}}
+
+	created := time.Now()
+	var open []alert.Alert
+	for _, device := range []int{1, 1, 1, 2} {
+		a := alert.New(alert.PressureHigh, device, created, alert.Values{})
+		a.Severity = alert.Critical
+		Start(policies, &a, created)
+		store.InsertAlert(ctx, a)
+		open = append(open, a)
+	}
+	// подтверждён только один из трёх алертов устройства 1
+	store.ApplyAlertEvent(ctx, open[0].ID.Hex(), alert.Event{Action: alert.Ack, Operator: "ivanov", At: created})
+
+	s := NewScheduler(store, channels, policies, time.Minute)
+	if err := s.Tick(ctx, created.Add(6*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "oncall:" + open[3].ID.Hex()[18:]; strings.Join(sent, " ") != want {
+		t.Errorf("sent = %v, want only device 2: %s", sent, want)
+	}
+	for _, a := range open[1:3] {
+		got, _ := store.GetAlert(ctx, a.ID.Hex())
+		if got.Escalation.NextAt != nil || len(got.History) == 0 || got.History[len(got.History)-1].Action != alert.Escalate {
+			t.Errorf("alert %s escalation = %+v, history = %+v, want stopped and recorded", a.ID.Hex(), got.Escalation, got.History)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(policies, []string{"log", "oncall", "supervisor"}); err != nil {
+		t.Fatal(err)
+	}
+	bad := []Policy{
+		{Name: "p", Steps: []Step{{After: time.Hour, Channels: []string{"log"}}, {After: time.Minute, Channels: []string{"pager"}}}},
+		{Name: "p", Rules: []alert.Rule{"pressure_very_high"}},
+	}
+	err := Validate(bad, []string{"log"})
+	for _, want := range []string{"earlier than the previous", `unknown channel "pager"`, "defined twice", "has no steps", "unknown rule"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetBatch — сколько строк копится перед записью в parquet
+const parquetBatch = 1024
+
+type row interface {
+	header() []string
+	record() []string
+}
+
+type PacketRow struct {
+	DeviceID    int64     `json:"device_id" parquet:"device_id"`
+	Timestamp   time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Pressure    float32   `json:"pressure" parquet:"pressure"`
+	Temperature float32   `json:"temperature" parquet:"temperature"`
+}
+
+func (PacketRow) header() []string {
+	return []string{"device_id", "timestamp", "pressure", "temperature"}
+}
+
+func (r PacketRow) record() []string {
+	return []string{
+		strconv.FormatInt(r.DeviceID, 10),
+		r.Timestamp.UTC().Format(time.RFC3339),
+		formatFloat(r.Pressure),
+		formatFloat(r.Temperature),
+	}
+}
+
+// AlertRow — алерт любого типа: у мгновенных заполнены давление и температура,
+// у устойчивых — изменение давления
+type AlertRow struct {
+	ID          string    `json:"id" parquet:"id"`
+	DeviceID    int64     `json:"device_id" parquet:"device_id"`
+	Timestamp   time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Type        string    `json:"type" parquet:"type,dict"`
+	Rule        string    `json:"rule" parquet:"rule,dict"`
+	Severity    string    `json:"severity" parquet:"severity,dict"`
+	State       string    `json:"state" parquet:"state,dict"`
+	Pressure    *float32  `json:"pressure,omitempty" parquet:"pressure,optional"`
+	Temperature *float32  `json:"temperature,omitempty" parquet:"temperature,optional"`
+	Change      *float32  `json:"change,omitempty" parquet:"change,optional"`
+}
+
+func (AlertRow) header() []string {
+	return []string{"id", "device_id", "timestamp", "type", "rule", "severity", "state", "pressure", "temperature", "change"}
+}
+
+func (r AlertRow) record() []string {
+	opt := func(v *float32) string {
+		if v == nil {
+			return ""
+		}
+		return formatFloat(*v)
+	}
+	return []string{
+		r.ID,
+		strconv.FormatInt(r.DeviceID, 10),
+		r.Timestamp.UTC().Format(time.RFC3339),
+		r.Type,
+		r.Rule,
+		r.Severity,
+		r.State,
+		opt(r.Pressure),
+		opt(r.Temperature),
+		opt(r.Change),
+	}
+}
+
+func formatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 32)
+}
+
+type encoder[T row] interface {
+	Encode(v T) error
+	Close() error
+}
+
+func newEncoder[T row](f Format, w io.Writer) encoder[T] {
+	switch f {
+	case NDJSON:
+		return &ndjsonEncoder[T]{enc: json.NewEncoder(w)}
+	case Parquet:
+		return &parquetEncoder[T]{w: parquet.NewGenericWriter[T](w)}
+	default:
+		return &csvEncoder[T]{w: csv.NewWriter(w)}
+	}
+}
+
+type csvEncoder[T row] struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvEncoder[T]) Encode(v T) error {
+	if !e.wroteHeader {
+		if err := e.w.Write(v.header()); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	return e.w.Write(v.record())
+}
+
+func (e *csvEncoder[T]) Close() error {
+	// пустой кусок всё равно получает заголовок, чтобы файлы склеивались одинаково
+	if !e.wroteHeader {
+		var zero T
+		if err := e.w.Write(zero.header()); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder[T row] struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder[T]) Encode(v T) error {
+	return e.enc.Encode(v)
+}
+
+func (e *ndjsonEncoder[T]) Close() error {
+	return nil
+}
+
+type parquetEncoder[T row] struct {
+	w   *parquet.GenericWriter[T]
+	buf []T
+}
+
+func (e *parquetEncoder[T]) Encode(v T) error {
+	e.buf = append(e.buf, v)
+	if len(e.buf) < parquetBatch {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *parquetEncoder[T]) flush() error {
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+func (e *parquetEncoder[T]) Close() error {
+	if len(e.buf) > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	return e.w.Close()
+}
@@ -0,0 +1,151 @@
+// Package export выгружает пакеты и алерты за период в файлы CSV, NDJSON или Parquet.
+// Период режется на куски по времени, каждый кусок — отдельный файл; готовые куски
+// записываются в манифест, поэтому прерванная выгрузка продолжается с места остановки.
+package export
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type Kind string
+
+const (
+	Packets Kind = "packets"
+	Alerts  Kind = "alerts"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+)
+
+const (
+	DefaultChunk = 24 * time.Hour
+	// maxChunks ограничивает число файлов одной выгрузки
+	maxChunks = 10000
+)
+
+var (
+	ErrNotFound = errors.New("export not found")
+	ErrRunning  = errors.New("export is already running")
+	// ErrMismatch — в каталоге уже лежит выгрузка с другими параметрами
+	ErrMismatch = errors.New("directory contains another export")
+)
+
+// Request описывает выгрузку. Пустой Devices — все устройства, период [From, To).
+type Request struct {
+	Kind    Kind          `json:"kind"`
+	Format  Format        `json:"format"`
+	Devices []int         `json:"devices,omitempty"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Chunk   time.Duration `json:"chunk"`
+	Gzip    bool          `json:"gzip"`
+}
+
+// Normalize подставляет значения по умолчанию и проверяет запрос
+func (r Request) Normalize() (Request, error) {
+	if r.Chunk == 0 {
+		r.Chunk = DefaultChunk
+	}
+	r.From, r.To = r.From.UTC(), r.To.UTC()
+
+	switch r.Kind {
+	case Packets, Alerts:
+	default:
+		return r, fmt.Errorf("unknown kind %q", r.Kind)
+	}
+	switch r.Format {
+	case CSV, NDJSON, Parquet:
+	default:
+		return r, fmt.Errorf("unknown format %q", r.Format)
+	}
+	if r.From.IsZero() || r.To.IsZero() || !r.From.Before(r.To) {
+		return r, errors.New("from must be before to")
+	}
+	if r.Chunk < time.Minute {
+		return r, errors.New("chunk must be at least 1m")
+	}
+	if n := r.To.Sub(r.From) / r.Chunk; n >= maxChunks {
+		return r, fmt.Errorf("period splits into more than %d chunks, increase chunk", maxChunks)
+	}
+	return r, nil
+}
+
+func (r Request) same(o Request) bool {
+	return r.Kind == o.Kind && r.Format == o.Format && slices.Equal(r.Devices, o.Devices) &&
+		r.From.Equal(o.From) && r.To.Equal(o.To) && r.Chunk == o.Chunk && r.Gzip == o.Gzip
+}
+
+// chunks режет период на интервалы по Chunk, последний может быть короче
+func (r Request) chunks() [][2]time.Time {
+	var out [][2]time.Time
+	for from := r.From; from.Before(r.To); from = from.Add(r.Chunk) {
+		to := from.Add(r.Chunk)
+		if to.After(r.To) {
+			to = r.To
+		}
+		out = append(out, [2]time.Time{from, to})
+	}
+	return out
+}
+
+func (r Request) fileName(from time.Time) string {
+	name := string(r.Kind) + "-" + from.Format("20060102T150405Z") + "." + string(r.Format)
+	if r.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// Chunk — записанный файл выгрузки
+type Chunk struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	File    string    `json:"file"`
+	Records int64     `json:"records"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// Manifest хранится в каталоге выгрузки рядом с файлами
+type Manifest struct {
+	Request Request `json:"request"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+func (m *Manifest) Complete() bool {
+	return len(m.Chunks) == len(m.Request.chunks())
+}
+
+func (m *Manifest) Progress() Progress {
+	p := Progress{ChunksTotal: len(m.Request.chunks()), ChunksDone: len(m.Chunks)}
+	for _, c := range m.Chunks {
+		p.Records += c.Records
+		p.Bytes += c.Bytes
+	}
+	return p
+}
+
+func (m *Manifest) has(file string) bool {
+	for _, c := range m.Chunks {
+		if c.File == file {
+			return true
+		}
+	}
+	return false
+}
+
+// Progress — состояние выгрузки. Records и Bytes учитывают и текущий кусок.
+type Progress struct {
+	ChunksTotal int       `json:"chunks_total"`
+	ChunksDone  int       `json:"chunks_done"`
+	Records     int64     `json:"records"`
+	Bytes       int64     `json:"bytes"`
+	Position    time.Time `json:"position,omitzero"`
+}
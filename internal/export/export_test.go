@@ -0,0 +1,239 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var base = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+type sliceSource struct {
+	packets []PacketRow
+	alerts  []AlertRow
+	// failAt — начало куска, на котором чтение падает, пока не сброшено
+	failAt time.Time
+}
+
+func (s *sliceSource) Packets(_ context.Context, devices []int, from, to time.Time, fn func(PacketRow) error) error {
+	if from.Equal(s.failAt) {
+		return errors.New("connection reset")
+	}
+	for _, p := range s.packets {
+		if p.Timestamp.Before(from) || !p.Timestamp.Before(to) {
+			continue
+		}
+		if len(devices) > 0 && devices[0] != int(p.DeviceID) {
+			continue
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sliceSource) Alerts(_ context.Context, _ []int, from, to time.Time, fn func(AlertRow) error) error {
+	for _, a := range s.alerts {
+		if a.Timestamp.Before(from) || !a.Timestamp.Before(to) {
+			continue
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testSource() *sliceSource {
+	s := &sliceSource{}
+	for i := range 6 {
+		s.packets = append(s.packets, PacketRow{
+			DeviceID:    int64(1 + i%2),
+			Timestamp:   base.Add(time.Duration(i) * 10 * time.Hour),
+			Pressure:    0.05,
+			Temperature: 20.5,
+		})
+	}
+	change := float32(-0.2)
+	s.alerts = []AlertRow{{DeviceID: 1, Timestamp: base.Add(time.Hour), Type: "sustained", Rule: "rapid_pressure_decrease", Change: &change}}
+	return s
+}
+
+func TestRunCSV(t *testing.T) {
+	dir := t.TempDir()
+	req := Request{Kind: Packets, Format: CSV, From: base, To: base.Add(48 * time.Hour)}
+
+	var last Progress
+	m, err := New(testSource()).Run(context.Background(), dir, req, func(p Progress) { last = p })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Chunks) != 2 || m.Chunks[0].Records != 3 || m.Chunks[1].Records != 2 {
+		t.Fatalf("chunks = %+v", m.Chunks)
+	}
+	if last.ChunksDone != 2 || last.Records != 5 {
+		t.Errorf("last progress = %+v", last)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "packets-20250301T000000Z.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "device_id,timestamp,pressure,temperature\n" +
+		"1,2025-03-01T00:00:00Z,0.05,20.5\n" +
+		"2,2025-03-01T10:00:00Z,0.05,20.5\n" +
+		"1,2025-03-01T20:00:00Z,0.05,20.5\n"
+	if string(data) != want {
+		t.Errorf("csv =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestRunNDJSONGzip(t *testing.T) {
+	dir := t.TempDir()
+	req := Request{Kind: Alerts, Format: NDJSON, From: base, To: base.Add(24 * time.Hour), Gzip: true}
+
+	if _, err := New(testSource()).Run(context.Background(), dir, req, nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "alerts-20250301T000000Z.ndjson.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.NewDecoder(zr).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["rule"] != "rapid_pressure_decrease" || got["change"] == nil {
+		t.Errorf("alert = %v", got)
+	}
+	if _, ok := got["pressure"]; ok {
+		t.Errorf("empty pressure must be omitted: %v", got)
+	}
+}
+
+func TestRunParquet(t *testing.T) {
+	dir := t.TempDir()
+	req := Request{Kind: Packets, Format: Parquet, Devices: []int{2}, From: base, To: base.Add(72 * time.Hour), Chunk: 72 * time.Hour}
+
+	if _, err := New(testSource()).Run(context.Background(), dir, req, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "packets-20250301T000000Z.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := parquet.Read[PacketRow](bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0].DeviceID != 2 || !rows[0].Timestamp.Equal(base.Add(10*time.Hour)) {
+		t.Errorf("rows = %+v", rows)
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	dir := t.TempDir()
+	src := testSource()
+	src.failAt = base.Add(24 * time.Hour)
+	req := Request{Kind: Packets, Format: CSV, From: base, To: base.Add(72 * time.Hour)}
+
+	m, err := New(src).Run(context.Background(), dir, req, nil)
+	if err == nil {
+		t.Fatal("expected error on the second chunk")
+	}
+	if len(m.Chunks) != 1 {
+		t.Fatalf("chunks after failure = %d, want 1", len(m.Chunks))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "packets-20250302T000000Z.csv.part")); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+
+	// первый кусок подменяем, чтобы убедиться, что повторно он не пишется
+	first := filepath.Join(dir, m.Chunks[0].File)
+	if err := os.WriteFile(first, []byte("kept"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src.failAt = time.Time{}
+	m, err = New(src).Run(context.Background(), dir, req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Complete() || m.Progress().Records != 6 {
+		t.Errorf("manifest after resume = %+v", m)
+	}
+	if data, _ := os.ReadFile(first); string(data) != "kept" {
+		t.Error("completed chunk was rewritten")
+	}
+
+	req.Format = NDJSON
+	if _, err := New(src).Run(context.Background(), dir, req, nil); !errors.Is(err, ErrMismatch) {
+		t.Errorf("different request in the same dir: err = %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		err  string
+	}{
+		{"unknown kind", Request{Kind: "commands", Format: CSV, From: base, To: base.Add(time.Hour)}, "unknown kind"},
+		{"unknown format", Request{Kind: Packets, Format: "xlsx", From: base, To: base.Add(time.Hour)}, "unknown format"},
+		{"empty period", Request{Kind: Packets, Format: CSV, From: base, To: base}, "from must be before to"},
+		{"too many chunks", Request{Kind: Packets, Format: CSV, From: base, To: base.Add(365 * 24 * time.Hour), Chunk: time.Minute}, "more than"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.req.Normalize(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(New(testSource()), dir)
+
+	st, err := m.Start(Request{Kind: Packets, Format: CSV, From: base, To: base.Add(48 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-m.jobs[st.ID].done
+
+	st, err = m.Get(st.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != Done || len(st.Files) != 2 || st.Progress.Records != 5 {
+		t.Errorf("status = %+v", st)
+	}
+	if _, err := m.File(st.ID, st.Files[0]); err != nil {
+		t.Error(err)
+	}
+	if _, err := m.File(st.ID, "../"+st.ID+"/manifest.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("file outside manifest: err = %v", err)
+	}
+
+	// после перезапуска состояние восстанавливается по манифесту
+	restarted := NewManager(New(testSource()), dir)
+	if st, err := restarted.Get(st.ID); err != nil || st.State != Done {
+		t.Errorf("after restart: %+v, %v", st, err)
+	}
+	if _, err := restarted.Get("../../etc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("invalid id: err = %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package export
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type State string
+
+const (
+	Running   State = "running"
+	Done      State = "done"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+	// Interrupted — выгрузка найдена на диске, но не завершена, например после перезапуска
+	Interrupted State = "interrupted"
+)
+
+var exportsFinished = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "exports_finished_total",
+		Help: "Total number of finished export jobs",
+	},
+	[]string{"kind", "state"},
+)
+
+// Status — состояние фоновой выгрузки для API
+type Status struct {
+	ID       string    `json:"id"`
+	State    State     `json:"state"`
+	Request  Request   `json:"request"`
+	Progress Progress  `json:"progress"`
+	Error    string    `json:"error,omitempty"`
+	Files    []string  `json:"files"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+type job struct {
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Manager запускает выгрузки в фоне, каждую в своём подкаталоге dir. Состояние
+// запущенных выгрузок хранится в памяти, завершённые и прерванные восстанавливаются
+// по манифестам на диске.
+type Manager struct {
+	exporter *Exporter
+	dir      string
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func NewManager(exporter *Exporter, dir string) *Manager {
+	return &Manager{exporter: exporter, dir: dir, jobs: make(map[string]*job)}
+}
+
+// Start проверяет запрос и запускает новую выгрузку
+func (m *Manager) Start(req Request) (Status, error) {
+	req, err := req.Normalize()
+	if err != nil {
+		return Status{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Status{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.start(id, req), nil
+}
+
+// Resume продолжает прерванную или упавшую выгрузку с первого незаписанного куска
+func (m *Manager) Resume(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.jobs[id]; ok && j.status.State == Running {
+		return Status{}, ErrRunning
+	}
+	manifest, err := m.manifest(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return m.start(id, manifest.Request), nil
+}
+
+func (m *Manager) start(id string, req Request) Status {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: Status{ID: id, State: Running, Request: req, Started: time.Now().UTC()},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.jobs[id] = j
+	go m.run(ctx, j)
+	return j.status
+}
+
+func (m *Manager) run(ctx context.Context, j *job) {
+	defer close(j.done)
+	id, req := j.status.ID, j.status.Request
+	slog.Info("export started", "id", id, "kind", req.Kind, "format", req.Format,
+		"from", req.From, "to", req.To, "devices", req.Devices)
+
+	manifest, err := m.exporter.Run(ctx, filepath.Join(m.dir, id), req, func(p Progress) {
+		m.mu.Lock()
+		j.status.Progress = p
+		m.mu.Unlock()
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.status.Finished = time.Now().UTC()
+	if manifest != nil {
+		j.status.Files = files(manifest)
+	}
+	switch {
+	case err == nil:
+		j.status.State = Done
+		slog.Info("export finished", "id", id, "records", j.status.Progress.Records, "bytes", j.status.Progress.Bytes)
+	case errors.Is(err, context.Canceled):
+		j.status.State = Cancelled
+		slog.Info("export cancelled", "id", id)
+	default:
+		j.status.State = Failed
+		j.status.Error = err.Error()
+		slog.Error("export failed", "id", id, "err", err)
+	}
+	exportsFinished.WithLabelValues(string(req.Kind), string(j.status.State)).Inc()
+}
+
+// Get возвращает состояние выгрузки из памяти или, если процесс перезапускался, по манифесту
+func (m *Manager) Get(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.jobs[id]; ok {
+		st := j.status
+		st.Files = append([]string(nil), st.Files...)
+		return st, nil
+	}
+	manifest, err := m.manifest(id)
+	if err != nil {
+		return Status{}, err
+	}
+	st := Status{ID: id, State: Interrupted, Request: manifest.Request, Progress: manifest.Progress(), Files: files(manifest)}
+	if manifest.Complete() {
+		st.State = Done
+	}
+	return st, nil
+}
+
+// Cancel останавливает выгрузку и ждёт её завершения. Записанные куски остаются,
+// выгрузку можно продолжить через Resume.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	j.cancel()
+	<-j.done
+	return nil
+}
+
+// Shutdown отменяет все выгрузки, например при остановке сервиса
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+
+	for _, j := range jobs {
+		j.cancel()
+		<-j.done
+	}
+}
+
+// File возвращает путь к готовому файлу выгрузки. Отдаются только файлы из манифеста.
+func (m *Manager) File(id, name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	manifest, err := m.manifest(id)
+	if err != nil {
+		return "", err
+	}
+	if !manifest.has(name) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(m.dir, id, name), nil
+}
+
+func (m *Manager) manifest(id string) (*Manifest, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	manifest, err := LoadManifest(filepath.Join(m.dir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return manifest, err
+}
+
+func files(m *Manifest) []string {
+	names := make([]string, len(m.Chunks))
+	for i, c := range m.Chunks {
+		names[i] = c.File
+	}
+	return names
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validID не даёт выйти за пределы каталога выгрузок через id из URL
+func validID(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
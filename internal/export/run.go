@@ -0,0 +1,223 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	manifestName = "manifest.json"
+	// progressEvery — как часто внутри куска сообщается прогресс
+	progressEvery = 10000
+)
+
+var recordsExported = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "export_records_total",
+		Help: "Total number of records written to export files",
+	},
+	[]string{"kind", "format"},
+)
+
+type Exporter struct {
+	src Source
+}
+
+func New(src Source) *Exporter {
+	return &Exporter{src: src}
+}
+
+// Run записывает выгрузку в каталог dir. Если там уже есть манифест того же запроса,
+// готовые куски пропускаются. Кусок сначала пишется во временный файл и попадает
+// в манифест только после переименования, поэтому обрыв не оставляет битых файлов.
+// progress может быть nil.
+func (e *Exporter) Run(ctx context.Context, dir string, req Request, progress func(Progress)) (*Manifest, error) {
+	req, err := req.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	m, err := LoadManifest(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// пустой манифест сразу на диске: выгрузку можно продолжить, даже если не успел записаться ни один кусок
+		m = &Manifest{Request: req}
+		if err := saveManifest(dir, m); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !m.Request.same(req):
+		return nil, ErrMismatch
+	}
+	if len(m.Chunks) > 0 {
+		slog.Info("resuming export", "dir", dir, "chunks_done", len(m.Chunks))
+	}
+
+	done := m.Progress()
+	progress(done)
+	for _, c := range req.chunks() {
+		if m.has(req.fileName(c[0])) {
+			continue
+		}
+		chunk, err := e.writeChunk(ctx, dir, req, c[0], c[1], func(records, bytes int64) {
+			p := done
+			p.Records += records
+			p.Bytes += bytes
+			p.Position = c[0]
+			progress(p)
+		})
+		if err != nil {
+			return m, fmt.Errorf("chunk %s: %w", c[0].Format(time.RFC3339), err)
+		}
+
+		m.Chunks = append(m.Chunks, chunk)
+		if err := saveManifest(dir, m); err != nil {
+			return m, err
+		}
+		done = m.Progress()
+		done.Position = c[1]
+		progress(done)
+	}
+	return m, nil
+}
+
+func (e *Exporter) writeChunk(ctx context.Context, dir string, req Request, from, to time.Time,
+	report func(records, bytes int64)) (chunk Chunk, err error) {
+	name := req.fileName(from)
+	part := filepath.Join(dir, name+".part")
+	f, err := os.Create(part)
+	if err != nil {
+		return Chunk{}, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(part)
+		}
+	}()
+
+	counter := &countingWriter{w: f}
+	var w io.Writer = counter
+	var gz *gzip.Writer
+	if req.Gzip {
+		gz = gzip.NewWriter(counter)
+		w = gz
+	}
+	buf := bufio.NewWriterSize(w, 64*1024)
+
+	var records int64
+	count := func() error {
+		records++
+		if records%progressEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			report(records, counter.n)
+		}
+		return nil
+	}
+
+	switch req.Kind {
+	case Packets:
+		err = encodeAll(newEncoder[PacketRow](req.Format, buf), func(fn func(PacketRow) error) error {
+			return e.src.Packets(ctx, req.Devices, from, to, fn)
+		}, count)
+	case Alerts:
+		err = encodeAll(newEncoder[AlertRow](req.Format, buf), func(fn func(AlertRow) error) error {
+			return e.src.Alerts(ctx, req.Devices, from, to, fn)
+		}, count)
+	}
+	if err != nil {
+		return Chunk{}, err
+	}
+
+	if err := buf.Flush(); err != nil {
+		return Chunk{}, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return Chunk{}, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return Chunk{}, err
+	}
+	if err := f.Close(); err != nil {
+		return Chunk{}, err
+	}
+	if err := os.Rename(part, filepath.Join(dir, name)); err != nil {
+		return Chunk{}, err
+	}
+
+	recordsExported.WithLabelValues(string(req.Kind), string(req.Format)).Add(float64(records))
+	return Chunk{From: from, To: to, File: name, Records: records, Bytes: counter.n}, nil
+}
+
+func encodeAll[T row](enc encoder[T], scan func(func(T) error) error, count func() error) error {
+	err := scan(func(v T) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return count()
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// LoadManifest читает манифест выгрузки из каталога. Если выгрузка ещё не начиналась,
+// возвращает ошибку os.ErrNotExist.
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func saveManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
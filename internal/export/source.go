@@ -0,0 +1,129 @@
+package export
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Source отдаёт записи за период [from, to) в порядке времени. Пустой devices — все устройства.
+type Source interface {
+	Packets(ctx context.Context, devices []int, from, to time.Time, fn func(PacketRow) error) error
+	Alerts(ctx context.Context, devices []int, from, to time.Time, fn func(AlertRow) error) error
+}
+
+// MongoSource читает коллекции пакетов и алертов курсором, не загружая период целиком
+type MongoSource struct {
+	packets *mongo.Collection
+	alerts  *mongo.Collection
+}
+
+func NewMongoSource(packets, alerts *mongo.Collection) *MongoSource {
+	return &MongoSource{packets: packets, alerts: alerts}
+}
+
+func (s *MongoSource) Packets(ctx context.Context, devices []int, from, to time.Time, fn func(PacketRow) error) error {
+	return scan(ctx, s.packets, periodFilter(from, to, devices), func(d storage.PacketDocument) error {
+		return fn(packetRow(d))
+	})
+}
+
+func (s *MongoSource) Alerts(ctx context.Context, devices []int, from, to time.Time, fn func(AlertRow) error) error {
+	return scan(ctx, s.alerts, periodFilter(from, to, devices), func(a alert.Alert) error {
+		return fn(alertRow(a))
+	})
+}
+
+func packetRow(d storage.PacketDocument) PacketRow {
+	return PacketRow{
+		DeviceID:    int64(d.DeviceID),
+		Timestamp:   d.Timestamp.UTC(),
+		Pressure:    d.Pressure,
+		Temperature: d.Temperature,
+	}
+}
+
+func alertRow(a alert.Alert) AlertRow {
+	return AlertRow{
+		ID:          a.ID.Hex(),
+		DeviceID:    int64(a.DeviceID),
+		Timestamp:   a.Timestamp.UTC(),
+		Type:        string(a.Type),
+		Rule:        string(a.Rule),
+		Severity:    string(a.Severity),
+		State:       string(a.State),
+		Pressure:    a.Values.Pressure,
+		Temperature: a.Values.Temperature,
+		Change:      a.Values.Change,
+	}
+}
+
+func periodFilter(from, to time.Time, devices []int) bson.M {
+	filter := bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}}
+	if len(devices) > 0 {
+		filter["device_id"] = bson.M{"$in": devices}
+	}
+	return filter
+}
+
+func scan[T any](ctx context.Context, coll *mongo.Collection, filter bson.M, fn func(T) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// BoltSource читает пакеты и алерты из файла однопроцессного режима
+type BoltSource struct {
+	store *storage.BoltStore
+}
+
+func NewBoltSource(store *storage.BoltStore) *BoltSource {
+	return &BoltSource{store: store}
+}
+
+func (s *BoltSource) Packets(ctx context.Context, devices []int, from, to time.Time, fn func(PacketRow) error) error {
+	return s.store.ScanPackets(ctx, devices, from, to, func(d storage.PacketDocument) error {
+		return fn(packetRow(d))
+	})
+}
+
+func (s *BoltSource) Alerts(_ context.Context, devices []int, from, to time.Time, fn func(AlertRow) error) error {
+	alerts, err := s.store.Alerts()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Timestamp.Before(alerts[j].Timestamp) })
+	for _, a := range alerts {
+		if a.Timestamp.Before(from) || !a.Timestamp.Before(to) {
+			continue
+		}
+		if len(devices) > 0 && !slices.Contains(devices, a.DeviceID) {
+			continue
+		}
+		if err := fn(alertRow(a)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
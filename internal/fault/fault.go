@@ -0,0 +1,239 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Виды сбоев, значения метки fault
+const (
+	MalformedJSON   = "malformed_json"
+	NegativeValues  = "negative_values"
+	MissingFields   = "missing_fields"
+	Duplicate       = "duplicate"
+	ClockSkew       = "clock_skew"
+	FutureTimestamp = "future_timestamp"
+	OutOfOrder      = "out_of_order"
+	Silence         = "silence"
+)
+
+var (
+	injectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "simulator_faults_injected_total",
+			Help: "Total number of faults injected by the simulator",
+		},
+		[]string{"fault"},
+	)
+
+	// для пакетов, которые контроллер обязан отклонить, фиксируем его фактический ответ
+	invalidResponsesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "simulator_fault_responses_total",
+			Help: "Controller responses to deliberately invalid packets",
+		},
+		[]string{"fault", "result"},
+	)
+)
+
+// Config задаёт вероятность каждого сбоя на один отправляемый пакет (0 — выключен)
+type Config struct {
+	MalformedJSON   float64
+	NegativeValues  float64
+	MissingFields   float64
+	Duplicate       float64
+	ClockSkew       float64
+	ClockSkewMax    time.Duration
+	FutureTimestamp float64
+	FutureOffset    time.Duration
+	OutOfOrder      float64
+	OutOfOrderBurst int
+	Silence         float64
+	SilenceDuration time.Duration
+}
+
+// Enabled сообщает, включён ли хотя бы один вид сбоев
+func (c Config) Enabled() bool {
+	return c.MalformedJSON > 0 || c.NegativeValues > 0 || c.MissingFields > 0 || c.Duplicate > 0 ||
+		c.ClockSkew > 0 || c.FutureTimestamp > 0 || c.OutOfOrder > 0 || c.Silence > 0
+}
+
+// RawSender отправляет тело запроса как есть, без сериализации пакета
+type RawSender interface {
+	SendRaw(ctx context.Context, body []byte) error
+}
+
+type deviceState struct {
+	silentUntil time.Time
+	held        []packet.Packet // пакеты пачки, отправляемой в обратном порядке
+	burstLeft   int
+}
+
+// Injector портит часть пакетов перед отправкой. Пакеты, которые контроллер обязан
+// отклонить (битый JSON, отрицательные значения, пропущенные поля), уходят через raw
+// в обход next, чтобы не застрять в буфере store-and-forward.
+type Injector struct {
+	cfg  Config
+	next sender.PacketSender
+	raw  RawSender
+
+	mu      sync.Mutex
+	devices map[int]*deviceState
+}
+
+func NewInjector(cfg Config, next sender.PacketSender, raw RawSender) *Injector {
+	return &Injector{
+		cfg:     cfg,
+		next:    next,
+		raw:     raw,
+		devices: make(map[int]*deviceState),
+	}
+}
+
+func (in *Injector) hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+func (in *Injector) count(fault string, p packet.Packet) {
+	injectedTotal.WithLabelValues(fault).Inc()
+	slog.Debug("fault injected", "fault", fault, "device_id", p.DeviceID)
+}
+
+func (in *Injector) Send(ctx context.Context, p packet.Packet) error {
+	in.mu.Lock()
+	st, ok := in.devices[p.DeviceID]
+	if !ok {
+		st = &deviceState{}
+		in.devices[p.DeviceID] = st
+	}
+
+	// молчание: устройство пропадает целиком, пакеты не отправляются
+	now := time.Now()
+	if now.Before(st.silentUntil) {
+		in.mu.Unlock()
+		return nil
+	}
+	if in.hit(in.cfg.Silence) {
+		st.silentUntil = now.Add(in.cfg.SilenceDuration)
+		in.mu.Unlock()
+		in.count(Silence, p)
+		return nil
+	}
+
+	// пачка не по порядку: копим пакеты и отправляем их от новых к старым
+	if st.burstLeft == 0 && in.cfg.OutOfOrderBurst > 1 && in.hit(in.cfg.OutOfOrder) {
+		st.burstLeft = in.cfg.OutOfOrderBurst
+		in.count(OutOfOrder, p)
+	}
+	if st.burstLeft > 0 {
+		st.held = append(st.held, p)
+		st.burstLeft--
+		if st.burstLeft > 0 {
+			in.mu.Unlock()
+			return nil
+		}
+		held := st.held
+		st.held = nil
+		in.mu.Unlock()
+
+		var firstErr error
+		for i := len(held) - 1; i >= 0; i-- {
+			if err := in.send(ctx, held[i]); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+	in.mu.Unlock()
+
+	return in.send(ctx, p)
+}
+
+func (in *Injector) send(ctx context.Context, p packet.Packet) error {
+	switch {
+	case in.hit(in.cfg.MalformedJSON):
+		in.count(MalformedJSON, p)
+		body, _ := json.Marshal(p)
+		return outcome(MalformedJSON, in.raw.SendRaw(ctx, body[:rand.IntN(len(body))]))
+	case in.hit(in.cfg.NegativeValues):
+		in.count(NegativeValues, p)
+		if rand.IntN(2) == 0 {
+			p.Pressure = -p.Pressure - 0.01
+		} else {
+			p.Temperature = -p.Temperature - 1
+		}
+		body, _ := json.Marshal(p)
+		return outcome(NegativeValues, in.raw.SendRaw(ctx, body))
+	case in.hit(in.cfg.MissingFields):
+		in.count(MissingFields, p)
+		return outcome(MissingFields, in.raw.SendRaw(ctx, withoutField(p)))
+	}
+
+	if in.hit(in.cfg.ClockSkew) {
+		in.count(ClockSkew, p)
+		skew := time.Duration(rand.Int64N(int64(2*in.cfg.ClockSkewMax)+1)) - in.cfg.ClockSkewMax
+		p.Timestamp = shift(p.Timestamp, skew)
+	} else if in.hit(in.cfg.FutureTimestamp) {
+		in.count(FutureTimestamp, p)
+		p.Timestamp = shift(p.Timestamp, in.cfg.FutureOffset)
+	}
+
+	if err := in.next.Send(ctx, p); err != nil {
+		return err
+	}
+	if in.hit(in.cfg.Duplicate) {
+		in.count(Duplicate, p)
+		return in.next.Send(ctx, p)
+	}
+	return nil
+}
+
+// withoutField сериализует пакет без одного случайного поля
+func withoutField(p packet.Packet) []byte {
+	fields := map[string]any{
+		"device_id":   p.DeviceID,
+		"timestamp":   p.Timestamp,
+		"pressure":    p.Pressure,
+		"temperature": p.Temperature,
+	}
+	names := []string{"device_id", "timestamp", "pressure", "temperature"}
+	delete(fields, names[rand.IntN(len(names))])
+	body, _ := json.Marshal(fields)
+	return body
+}
+
+func shift(ts string, d time.Duration) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	return t.Add(d).UTC().Format(time.RFC3339)
+}
+
+// outcome учитывает ответ контроллера на испорченный пакет. Отказ (4xx) — ожидаемый
+// результат и ошибкой не считается; принятый пакет означает дыру в валидации.
+func outcome(fault string, err error) error {
+	var se *sender.StatusError
+	switch {
+	case err == nil:
+		invalidResponsesTotal.WithLabelValues(fault, "accepted").Inc()
+		slog.Warn("controller accepted invalid packet", "fault", fault)
+		return nil
+	case errors.As(err, &se) && se.Code >= 400 && se.Code < 500:
+		invalidResponsesTotal.WithLabelValues(fault, "rejected").Inc()
+		return nil
+	default:
+		invalidResponsesTotal.WithLabelValues(fault, "error").Inc()
+		return err
+	}
+}
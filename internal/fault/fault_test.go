@@ -0,0 +1,114 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+)
+
+type recorder struct {
+	packets []packet.Packet
+	raw     [][]byte
+}
+
+func (r *recorder) Send(_ context.Context, p packet.Packet) error {
+	r.packets = append(r.packets, p)
+	return nil
+}
+
+func (r *recorder) SendRaw(_ context.Context, body []byte) error {
+	r.raw = append(r.raw, body)
+	return &sender.StatusError{Code: http.StatusBadRequest}
+}
+
+func pkt(device, sec int) packet.Packet {
+	ts := time.Date(2025, 3, 1, 10, 0, sec, 0, time.UTC).Format(time.RFC3339)
+	return packet.Packet{DeviceID: device, Timestamp: ts, Pressure: 0.05, Temperature: 20}
+}
+
+func TestInjector(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("duplicate", func(t *testing.T) {
+		rec := &recorder{}
+		in := NewInjector(Config{Duplicate: 1}, rec, rec)
+		if err := in.Send(ctx, pkt(1, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.packets) != 2 || rec.packets[0] != rec.packets[1] {
+			t.Errorf("sent %+v, want the packet twice", rec.packets)
+		}
+	})
+
+	t.Run("invalid packets go raw and rejection is not an error", func(t *testing.T) {
+		rec := &recorder{}
+		in := NewInjector(Config{NegativeValues: 1}, rec, rec)
+		if err := in.Send(ctx, pkt(1, 0)); err != nil {
+			t.Fatalf("rejected invalid packet returned %v", err)
+		}
+		if len(rec.packets) != 0 || len(rec.raw) != 1 {
+			t.Fatalf("packets=%d raw=%d, want 0 and 1", len(rec.packets), len(rec.raw))
+		}
+		var p packet.Packet
+		if err := json.Unmarshal(rec.raw[0], &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Pressure >= 0 && p.Temperature >= 0 {
+			t.Errorf("raw packet %+v has no negative value", p)
+		}
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		rec := &recorder{}
+		in := NewInjector(Config{MissingFields: 1}, rec, rec)
+		in.Send(ctx, pkt(1, 0))
+		var fields map[string]any
+		if err := json.Unmarshal(rec.raw[0], &fields); err != nil {
+			t.Fatal(err)
+		}
+		if len(fields) != 3 {
+			t.Errorf("raw body %s, want one field missing", rec.raw[0])
+		}
+	})
+
+	t.Run("out of order burst", func(t *testing.T) {
+		rec := &recorder{}
+		in := NewInjector(Config{OutOfOrder: 1, OutOfOrderBurst: 3}, rec, rec)
+		for sec := 0; sec < 3; sec++ {
+			in.Send(ctx, pkt(1, sec))
+		}
+		if len(rec.packets) != 3 {
+			t.Fatalf("sent %d packets, want 3", len(rec.packets))
+		}
+		for i, want := range []packet.Packet{pkt(1, 2), pkt(1, 1), pkt(1, 0)} {
+			if rec.packets[i] != want {
+				t.Errorf("packet %d = %s, want %s", i, rec.packets[i].Timestamp, want.Timestamp)
+			}
+		}
+	})
+
+	t.Run("silence drops packets of one device", func(t *testing.T) {
+		rec := &recorder{}
+		in := NewInjector(Config{Silence: 1, SilenceDuration: time.Hour}, rec, rec)
+		in.Send(ctx, pkt(1, 0))
+		in.Send(ctx, pkt(1, 1))
+		if len(rec.packets) != 0 {
+			t.Errorf("sent %d packets during silence", len(rec.packets))
+		}
+	})
+
+	t.Run("future timestamp", func(t *testing.T) {
+		rec := &recorder{}
+		in := NewInjector(Config{FutureTimestamp: 1, FutureOffset: time.Hour}, rec, rec)
+		in.Send(ctx, pkt(1, 0))
+		want := time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		if rec.packets[0].Timestamp != want {
+			t.Errorf("timestamp = %s, want %s", rec.packets[0].Timestamp, want)
+		}
+	})
+}
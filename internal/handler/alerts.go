@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultAlertLimit = 100
+	maxAlertLimit     = 1000
+)
+
+var alertActions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "alert_actions_total",
+		Help: "Total number of operator actions applied to alerts",
+	},
+	[]string{"action"},
+)
+
+// AlertStreamHandler отдаёт новые алерты потоком Server-Sent Events
+type AlertStreamHandler struct {
+	alerts    storage.AlertSource
+	heartbeat time.Duration
+}
+
+func NewAlertStreamHandler(alerts storage.AlertSource, heartbeat time.Duration) *AlertStreamHandler {
+	return &AlertStreamHandler{alerts: alerts, heartbeat: heartbeat}
+}
+
+// queryList собирает значения параметра, заданного несколько раз или через запятую
+func queryList[T ~string](r *http.Request, name string) []T {
+	var out []T
+	for _, v := range r.URL.Query()[name] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, T(s))
+			}
+		}
+	}
+	return out
+}
+
+func queryDevices(r *http.Request) ([]int, bool) {
+	var devices []int
+	for _, s := range queryList[string](r, "device") {
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		devices = append(devices, id)
+	}
+	return devices, true
+}
+
+// HandleStream — GET /alerts/stream?device=1,2&type=instant&rule=pressure_low&severity=critical
+func (h *AlertStreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	devices, ok := queryDevices(r)
+	if !ok {
+		http.Error(w, "invalid device", http.StatusBadRequest)
+		return
+	}
+	filter := storage.AlertFilter{
+		Devices:    devices,
+		Types:      queryList[alert.Type](r, "type"),
+		Rules:      queryList[alert.Rule](r, "rule"),
+		Severities: queryList[alert.Severity](r, "severity"),
+	}
+
+	// подписка до отправки заголовков: всё, что вставлено после ответа, попадёт в поток
+	ctx := r.Context()
+	alerts := h.alerts.WatchAlerts(ctx, filter)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("alert stream: flush not supported", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			// комментарий не дает прокси закрыть простаивающее соединение
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case a, ok := <-alerts:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(a)
+			if err != nil {
+				slog.Error("alert stream: marshal error", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: alert\ndata: %s\n\n", a.ID.Hex(), data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// AlertHandler — поиск алертов и действия операторов: подтверждение, назначение,
+// комментарии и закрытие. Каждое действие попадает в журнал алерта.
+type AlertHandler struct {
+	alerts storage.AlertRepository
+}
+
+func NewAlertHandler(alerts storage.AlertRepository) *AlertHandler {
+	return &AlertHandler{alerts: alerts}
+}
+
+// Register добавляет маршруты работы с алертами в mux
+func (h *AlertHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /alerts", h.List)
+	mux.HandleFunc("GET /alerts/{id}", h.Get)
+	mux.HandleFunc("GET /alerts/{id}/history", h.History)
+	mux.HandleFunc("POST /alerts/{id}/ack", h.action(alert.Ack))
+	mux.HandleFunc("POST /alerts/{id}/unack", h.action(alert.Unack))
+	mux.HandleFunc("POST /alerts/{id}/assign", h.action(alert.Assign))
+	mux.HandleFunc("POST /alerts/{id}/comments", h.action(alert.Comment))
+	mux.HandleFunc("POST /alerts/{id}/close", h.action(alert.Close))
+}
+
+type alertListResponse struct {
+	Total  int64         `json:"total"`
+	Alerts []alert.Alert `json:"alerts"`
+}
+
+// List — GET /alerts?device=&type=&rule=&severity=&state=&assignee=&from=&to=&limit=&offset=
+func (h *AlertHandler) List(w http.ResponseWriter, r *http.Request) {
+	devices, ok := queryDevices(r)
+	if !ok {
+		http.Error(w, "invalid device", http.StatusBadRequest)
+		return
+	}
+	q := storage.AlertQuery{
+		Devices:    devices,
+		Types:      queryList[alert.Type](r, "type"),
+		Rules:      queryList[alert.Rule](r, "rule"),
+		Severities: queryList[alert.Severity](r, "severity"),
+		States:     queryList[alert.State](r, "state"),
+		Assignees:  queryList[string](r, "assignee"),
+		Limit:      defaultAlertLimit,
+	}
+
+	var err error
+	values := r.URL.Query()
+	if q.From, err = parseTime(values.Get("from"), time.Time{}); err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseTime(values.Get("to"), time.Time{}); err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > maxAlertLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAlertLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	found, err := h.alerts.FindAlerts(ctx, q)
+	if err != nil {
+		slog.Error("find alerts error", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	total, err := h.alerts.CountAlerts(ctx, q)
+	if err != nil {
+		slog.Error("count alerts error", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, alertListResponse{Total: total, Alerts: found})
+}
+
+func (h *AlertHandler) get(w http.ResponseWriter, r *http.Request) (alert.Alert, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	a, err := h.alerts.GetAlert(ctx, r.PathValue("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "alert not found", http.StatusNotFound)
+		return a, false
+	}
+	if err != nil {
+		slog.Error("get alert error", "alert_id", r.PathValue("id"), "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return a, false
+	}
+	return a, true
+}
+
+func (h *AlertHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if a, ok := h.get(w, r); ok {
+		writeJSON(w, http.StatusOK, a)
+	}
+}
+
+// History отдаёт журнал действий над алертом
+func (h *AlertHandler) History(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.get(w, r)
+	if !ok {
+		return
+	}
+	if a.History == nil {
+		a.History = []alert.Event{}
+	}
+	writeJSON(w, http.StatusOK, a.History)
+}
+
+// action обрабатывает действие оператора. Тело запроса — alert.Event без action и at:
+//
+//	{"operator": "ivanov", "comment": "...", "assignee": "petrov", "resolution": "fixed"}
+func (h *AlertHandler) action(action alert.Action) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var e alert.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		e.Action = action
+		e.At = time.Now().UTC()
+		if err := e.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		id := r.PathValue("id")
+		a, err := h.alerts.ApplyAlertEvent(ctx, id, e)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "alert not found", http.StatusNotFound)
+			return
+		case errors.Is(err, alert.ErrTransition), errors.Is(err, storage.ErrConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			slog.Error("alert action error", "alert_id", id, "action", action, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		alertActions.WithLabelValues(string(action)).Inc()
+		slog.Info("alert action applied", "alert_id", id, "action", action, "operator", e.Operator,
+			"state", a.State, "device_id", a.DeviceID, "rule", a.Rule)
+		writeJSON(w, http.StatusOK, a)
+	}
+}
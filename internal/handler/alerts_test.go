@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+func TestHandleAlertStream(t *testing.T) {
+	store := storage.NewMemoryAlertStore()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /alerts/stream", NewAlertStreamHandler(store, time.Minute).HandleStream)
+	srv := httptest.NewServer(MetricsMiddleware(mux))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/alerts/stream?device=3", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	go func() {
+		at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		store.InsertAlert(ctx, alert.New(alert.PressureLow, 1, at, alert.Values{}))
+		store.InsertAlert(ctx, alert.New(alert.TemperatureHigh, 3, at.Add(time.Second), alert.Values{}))
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		if !strings.Contains(line, `"device_id":3`) || !strings.Contains(line, `"rule":"temperature_high"`) {
+			t.Errorf("event data = %s", line)
+		}
+		return
+	}
+	t.Fatalf("stream ended without events: %v", scanner.Err())
+}
+
+func TestHandleAlertStreamBadDevice(t *testing.T) {
+	h := NewAlertStreamHandler(storage.NewMemoryAlertStore(), time.Minute)
+	rec := httptest.NewRecorder()
+	h.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/alerts/stream?device=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
+
+func TestAlertActions(t *testing.T) {
+	store := storage.NewMemoryAlertStore()
+	a := alert.New(alert.PressureHigh, 2, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), alert.Values{Pressure: alert.Float(0.09)})
+	store.InsertAlert(context.Background(), a)
+
+	mux := http.NewServeMux()
+	NewAlertHandler(store).Register(mux)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	base := "/alerts/" + a.ID.Hex()
+
+	steps := []struct {
+		path, body string
+		status     int
+	}{
+		{"/ack", `{"operator":"ivanov"}`, http.StatusOK},
+		{"/ack", `{"operator":"ivanov"}`, http.StatusConflict},
+		{"/assign", `{"operator":"ivanov","assignee":"petrov"}`, http.StatusOK},
+		{"/comments", `{"operator":"petrov","comment":"valve replaced"}`, http.StatusOK},
+		{"/close", `{"operator":"petrov","resolution":"solved"}`, http.StatusBadRequest},
+		{"/close", `{"operator":"petrov","resolution":"fixed"}`, http.StatusOK},
+		{"/unack", `{"operator":"ivanov"}`, http.StatusConflict},
+		{"/comments", `{"comment":"no operator"}`, http.StatusBadRequest},
+	}
+	for _, s := range steps {
+		if rec := do(http.MethodPost, base+s.path, s.body); rec.Code != s.status {
+			t.Fatalf("POST %s %s: status = %d, want %d: %s", s.path, s.body, rec.Code, s.status, rec.Body)
+		}
+	}
+
+	got, _ := store.GetAlert(context.Background(), a.ID.Hex())
+	if got.State != alert.Closed || got.Resolution != alert.Fixed || got.Assignee != "petrov" || got.AcknowledgedBy != "ivanov" {
+		t.Errorf("alert = %+v", got)
+	}
+
+	rec := do(http.MethodGet, base+"/history", "")
+	var history []alert.Event
+	if err := json.NewDecoder(rec.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	var actions []alert.Action
+	for _, e := range history {
+		actions = append(actions, e.Action)
+	}
+	if want := []alert.Action{alert.Ack, alert.Assign, alert.Comment, alert.Close}; !slices.Equal(actions, want) {
+		t.Errorf("history actions = %v, want %v", actions, want)
+	}
+
+	if rec := do(http.MethodGet, "/alerts?state=closed&assignee=petrov", ""); !strings.Contains(rec.Body.String(), `"total":1`) {
+		t.Errorf("list = %s", rec.Body)
+	}
+	if rec := do(http.MethodPost, "/alerts/000000000000000000000000/ack", `{"operator":"ivanov"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown alert: status = %d", rec.Code)
+	}
+}
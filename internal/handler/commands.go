@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	claimLimit   = 10
+	pollInterval = 500 * time.Millisecond
+)
+
+var (
+	commandsCreated = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "commands_created_total",
+			Help: "Total number of commands created for devices",
+		},
+		[]string{"type"},
+	)
+
+	commandsAcked = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "commands_acked_total",
+			Help: "Total number of commands acknowledged by devices",
+		},
+		[]string{"type", "status"},
+	)
+)
+
+// CommandHandler реализует HTTP-протокол команд из пакета command
+type CommandHandler struct {
+	commands       storage.CommandStore
+	maxWait        time.Duration
+	redeliverAfter time.Duration
+}
+
+func NewCommandHandler(commands storage.CommandStore, maxWait, redeliverAfter time.Duration) *CommandHandler {
+	return &CommandHandler{
+		commands:       commands,
+		maxWait:        maxWait,
+		redeliverAfter: redeliverAfter,
+	}
+}
+
+// Register добавляет маршруты протокола команд в mux
+func (h *CommandHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /devices/{device}/commands", h.Create)
+	mux.HandleFunc("GET /devices/{device}/commands", h.Poll)
+	mux.HandleFunc("POST /devices/{device}/commands/{id}/ack", h.Ack)
+	mux.HandleFunc("GET /commands/{id}", h.Get)
+}
+
+func deviceID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("device"))
+	return id, err == nil && id > 0
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("encode response error", "err", err)
+	}
+}
+
+func (h *CommandHandler) Create(w http.ResponseWriter, r *http.Request) {
+	device, ok := deviceID(r)
+	if !ok {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var req command.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c := command.New(device, req)
+	if err := h.commands.CreateCommand(ctx, c); err != nil {
+		slog.Error("create command error", "device_id", device, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	commandsCreated.WithLabelValues(string(c.Type)).Inc()
+	slog.Info("command created", "device_id", device, "command_id", c.ID, "type", c.Type)
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// Poll выдаёт устройству ожидающие команды. С параметром wait запрос держится
+// открытым, пока не появятся команды или не истечёт время (не больше maxWait).
+func (h *CommandHandler) Poll(w http.ResponseWriter, r *http.Request) {
+	device, ok := deviceID(r)
+	if !ok {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = min(d, h.maxWait)
+	}
+
+	ctx := r.Context()
+	deadline := time.Now().Add(wait)
+	for {
+		claimed, err := h.commands.ClaimCommands(ctx, device, h.redeliverAfter, claimLimit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("claim commands error", "device_id", device, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(claimed) > 0 || !time.Now().Before(deadline) {
+			if claimed == nil {
+				claimed = []command.Command{}
+			}
+			writeJSON(w, http.StatusOK, claimed)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(min(pollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+func (h *CommandHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	device, ok := deviceID(r)
+	if !ok {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var ack command.Ack
+	if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := ack.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := h.commands.AckCommand(ctx, device, r.PathValue("id"), ack)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "command not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("ack command error", "device_id", device, "command_id", r.PathValue("id"), "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	commandsAcked.WithLabelValues(string(c.Type), string(ack.Status)).Inc()
+	slog.Info("command acknowledged", "device_id", device, "command_id", c.ID, "type", c.Type,
+		"status", ack.Status, "error", ack.Error)
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *CommandHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := h.commands.GetCommand(ctx, r.PathValue("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "command not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("get command error", "command_id", r.PathValue("id"), "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
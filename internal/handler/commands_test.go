@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/downlink"
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+func newCommandServer(t *testing.T, redeliverAfter time.Duration) (*downlink.Client, *storage.MemoryCommandStore) {
+	t.Helper()
+	store := storage.NewMemoryCommandStore()
+	mux := http.NewServeMux()
+	NewCommandHandler(store, time.Second, redeliverAfter).Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return downlink.NewClient(srv.URL, time.Second), store
+}
+
+func TestCommandLifecycle(t *testing.T) {
+	ctx := context.Background()
+	client, store := newCommandServer(t, time.Hour)
+
+	created, err := client.Create(ctx, 3, command.Request{Type: command.SetPeriod, Params: map[string]float64{"period": 5}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.Status != command.StatusPending {
+		t.Errorf("created status = %s, want pending", created.Status)
+	}
+
+	if cmds, _ := client.Poll(ctx, 4, 0); len(cmds) != 0 {
+		t.Errorf("other device got %d commands", len(cmds))
+	}
+
+	cmds, err := client.Poll(ctx, 3, 0)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0].ID != created.ID || cmds[0].Status != command.StatusDelivered {
+		t.Fatalf("poll = %+v, want the created command delivered", cmds)
+	}
+	if again, _ := client.Poll(ctx, 3, 0); len(again) != 0 {
+		t.Errorf("delivered command returned again before redelivery timeout")
+	}
+
+	if err := client.Ack(ctx, 3, created.ID, command.Ack{Status: command.StatusSucceeded}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	// повторное подтверждение не меняет результат
+	if err := client.Ack(ctx, 3, created.ID, command.Ack{Status: command.StatusFailed, Error: "late"}); err != nil {
+		t.Fatalf("repeated ack: %v", err)
+	}
+	got, _ := store.GetCommand(ctx, created.ID)
+	if got.Status != command.StatusSucceeded || got.AckedAt == nil {
+		t.Errorf("stored command = %+v, want succeeded", got)
+	}
+
+	if err := client.Ack(ctx, 4, created.ID, command.Ack{Status: command.StatusSucceeded}); err == nil {
+		t.Error("ack from another device accepted")
+	}
+}
+
+func TestCommandRedelivery(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newCommandServer(t, 0)
+
+	created, _ := client.Create(ctx, 1, command.Request{Type: command.Recalibrate})
+	client.Poll(ctx, 1, 0)
+
+	cmds, err := client.Poll(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 1 || cmds[0].ID != created.ID || cmds[0].Attempts != 2 {
+		t.Errorf("poll after timeout = %+v, want redelivered command with 2 attempts", cmds)
+	}
+}
+
+func TestCommandLongPoll(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newCommandServer(t, time.Hour)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		client.Create(ctx, 1, command.Request{Type: command.CloseValve})
+	}()
+
+	start := time.Now()
+	cmds, err := client.Poll(ctx, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 1 {
+		t.Fatalf("long poll returned %d commands, want 1", len(cmds))
+	}
+	// ожидание ограничено maxWait сервера (1с)
+	if d := time.Since(start); d > 1500*time.Millisecond {
+		t.Errorf("long poll took %s", d)
+	}
+}
+
+func TestCommandValidation(t *testing.T) {
+	store := storage.NewMemoryCommandStore()
+	mux := http.NewServeMux()
+	NewCommandHandler(store, time.Second, time.Minute).Register(mux)
+
+	tests := []struct{ path, body string }{
+		{"/devices/x/commands", `{"type":"recalibrate"}`},
+		{"/devices/1/commands", `{"type":"self_destruct"}`},
+		{"/devices/1/commands", `{"type":"set_period"}`},
+		{"/devices/1/commands/abc/ack", `{"status":"pending"}`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s %s: status = %d, want 400", tt.path, tt.body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/export"
+)
+
+// ExportHandler — асинхронный API выгрузок: создание возвращает id, по которому
+// опрашивается прогресс и скачиваются готовые файлы
+type ExportHandler struct {
+	exports *export.Manager
+}
+
+func NewExportHandler(exports *export.Manager) *ExportHandler {
+	return &ExportHandler{exports: exports}
+}
+
+// Register добавляет маршруты выгрузок в mux
+func (h *ExportHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /exports", h.Create)
+	mux.HandleFunc("GET /exports/{id}", h.Get)
+	mux.HandleFunc("POST /exports/{id}/resume", h.Resume)
+	mux.HandleFunc("DELETE /exports/{id}", h.Cancel)
+	mux.HandleFunc("GET /exports/{id}/files/{name}", h.Download)
+}
+
+type exportRequest struct {
+	Kind    export.Kind   `json:"kind"`
+	Format  export.Format `json:"format"`
+	Devices []int         `json:"devices"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	// Chunk — длительность куска в формате time.ParseDuration, по умолчанию сутки
+	Chunk string `json:"chunk"`
+	Gzip  bool   `json:"gzip"`
+}
+
+func (h *ExportHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("decode error", "err", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	var chunk time.Duration
+	if req.Chunk != "" {
+		var err error
+		if chunk, err = time.ParseDuration(req.Chunk); err != nil {
+			http.Error(w, "invalid chunk", http.StatusBadRequest)
+			return
+		}
+	}
+
+	st, err := h.exports.Start(export.Request{
+		Kind:    req.Kind,
+		Format:  req.Format,
+		Devices: req.Devices,
+		From:    req.From,
+		To:      req.To,
+		Chunk:   chunk,
+		Gzip:    req.Gzip,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Location", "/exports/"+st.ID)
+	writeJSON(w, http.StatusAccepted, st)
+}
+
+func (h *ExportHandler) Get(w http.ResponseWriter, r *http.Request) {
+	st, err := h.exports.Get(r.PathValue("id"))
+	if err != nil {
+		writeExportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *ExportHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	st, err := h.exports.Resume(r.PathValue("id"))
+	if err != nil {
+		writeExportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, st)
+}
+
+func (h *ExportHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := h.exports.Cancel(id); err != nil {
+		writeExportError(w, err)
+		return
+	}
+	st, err := h.exports.Get(id)
+	if err != nil {
+		writeExportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	path, err := h.exports.File(r.PathValue("id"), name)
+	if err != nil {
+		writeExportError(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, path)
+}
+
+func writeExportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, export.ErrNotFound):
+		http.Error(w, "export not found", http.StatusNotFound)
+	case errors.Is(err, export.ErrRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		slog.Error("export error", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
@@ -1,82 +1,99 @@
 package handler
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/pochkachaiki/iot4gds/internal/models/packet"
-	amqp "github.com/rabbitmq/amqp091-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Handler struct {
-	collection *mongo.Collection
-	rabbitCh   *amqp.Channel
-	queueName  string
+	packets    storage.PacketStore
+	publisher  queue.Publisher
+	partitions int
 }
 
-func New(collection *mongo.Collection, rabbitCh *amqp.Channel, queueName string) *Handler {
+func New(packets storage.PacketStore, publisher queue.Publisher, partitions int) *Handler {
 	return &Handler{
-		collection: collection,
-		rabbitCh:   rabbitCh,
-		queueName:  queueName,
+		packets:    packets,
+		publisher:  publisher,
+		partitions: partitions,
 	}
 }
 
+// HandlePacket записывает пакет и публикует его в партицию устройства. Спан запроса
+// продолжает трассировку клиента из заголовка traceparent, если он есть.
 func (h *Handler) HandlePacket(w http.ResponseWriter, r *http.Request) {
-	var p packet.Packet
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		slog.Error("decode error", "err", err)
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
+	// запрос не отменяется при обрыве соединения клиента: принятый пакет доводится до очереди
+	ctx := tracing.Extract(context.WithoutCancel(r.Context()), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Start(ctx, "POST /packets", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	fail := func(status int, msg, logMsg string, args ...any) {
+		slog.ErrorContext(ctx, logMsg, args...)
+		span.SetStatus(codes.Error, logMsg)
+		http.Error(w, msg, status)
 	}
 
-	if p.DeviceID <= 0 || p.Timestamp == "" || p.Pressure < 0 || p.Temperature < 0 {
-		slog.Error("validation error", "packet", p)
-		http.Error(w, "invalid packet", http.StatusBadRequest)
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid gzip body", "gzip error", "err", err)
+			return
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	var p packet.Packet
+	if err := json.NewDecoder(reader).Decode(&p); err != nil {
+		fail(http.StatusBadRequest, "invalid json", "decode error", "err", err)
 		return
 	}
+	span.SetAttributes(attribute.Int("device.id", p.DeviceID))
 
-	_, err := time.Parse(time.RFC3339, p.Timestamp)
-	if err != nil {
-		slog.Error("timestamp parse error", "err", err)
-		http.Error(w, "invalid timestamp", http.StatusBadRequest)
+	if err := p.Validate(); err != nil {
+		if errors.Is(err, packet.ErrTimestamp) {
+			fail(http.StatusBadRequest, "invalid timestamp", "timestamp parse error", "err", err)
+		} else {
+			fail(http.StatusBadRequest, "invalid packet", "validation error", "packet", p)
+		}
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	_, err = h.collection.InsertOne(ctx, bson.M{
-		"device_id":   p.DeviceID,
-		"timestamp":   p.Timestamp,
-		"pressure":    p.Pressure,
-		"temperature": p.Temperature,
-	})
+	err := h.packets.InsertPacket(ctx, p)
 	if err != nil {
-		slog.Error("mongo insert error", "err", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, "internal error", "mongo insert error", "err", err)
 		return
 	}
 
 	body, err := json.Marshal(p)
 	if err != nil {
-		slog.Error("marshal for rabbit error", "err", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, "internal error", "marshal for rabbit error", "err", err)
 		return
 	}
 
-	err = h.rabbitCh.PublishWithContext(ctx, "", h.queueName, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        body,
-	})
+	key := queue.RoutingKey(queue.Partition(p.DeviceID, h.partitions))
+	err = h.publisher.Publish(ctx, key, body)
 	if err != nil {
-		slog.Error("rabbit publish error", "err", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, "internal error", "rabbit publish error", "err", err)
 		return
 	}
 
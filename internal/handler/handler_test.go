@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+type failingStore struct {
+	storage.PacketStore
+}
+
+func (failingStore) InsertPacket(context.Context, packet.Packet) error {
+	return errors.New("mongo is down")
+}
+
+func TestHandlePacketValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid json", `{"device_id":`},
+		{"zero device", `{"device_id":0,"timestamp":"2025-01-01T00:00:00Z","pressure":0.05,"temperature":20}`},
+		{"missing timestamp", `{"device_id":1,"pressure":0.05,"temperature":20}`},
+		{"negative pressure", `{"device_id":1,"timestamp":"2025-01-01T00:00:00Z","pressure":-1,"temperature":20}`},
+		{"negative temperature", `{"device_id":1,"timestamp":"2025-01-01T00:00:00Z","pressure":0.05,"temperature":-1}`},
+		{"bad timestamp", `{"device_id":1,"timestamp":"yesterday","pressure":0.05,"temperature":20}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryPacketStore()
+			h := New(store, queue.NewMemoryBroker(), 4)
+
+			rec := httptest.NewRecorder()
+			h.HandlePacket(rec, httptest.NewRequest(http.MethodPost, "/packets", strings.NewReader(tt.body)))
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if n := len(store.Packets(1)); n != 0 {
+				t.Errorf("stored %d packets, want 0", n)
+			}
+		})
+	}
+}
+
+func TestHandlePacketStoreError(t *testing.T) {
+	h := New(failingStore{}, queue.NewMemoryBroker(), 4)
+
+	body := `{"device_id":1,"timestamp":"2025-01-01T00:00:00Z","pressure":0.05,"temperature":20}`
+	rec := httptest.NewRecorder()
+	h.HandlePacket(rec, httptest.NewRequest(http.MethodPost, "/packets", strings.NewReader(body)))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests",
+		},
+		[]string{"method", "path", "status"},
+	)
+
+	httpRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "path"},
+	)
+)
+
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		duration := time.Since(start).Seconds()
+		// шаблон маршрута вместо пути, чтобы id устройств и команд не плодили серии метрик
+		path := r.URL.Path
+		if r.Pattern != "" {
+			path = r.Pattern
+			if _, p, ok := strings.Cut(r.Pattern, " "); ok {
+				path = p
+			}
+		}
+		method := r.Method
+		status := ww.status
+
+		httpRequestsTotal.WithLabelValues(method, path, http.StatusText(status)).Inc()
+		httpRequestDuration.WithLabelValues(method, path).Observe(duration)
+	})
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap даёт http.ResponseController доступ к Flush исходного writer, нужному для потоков
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+const defaultSeriesRange = time.Hour
+
+// SeriesHandler отдаёт историю показаний устройства. Разрешение выбирается по длине
+// периода и срокам хранения, если клиент не указал его явно.
+type SeriesHandler struct {
+	series      storage.SeriesReader
+	retention   storage.Retention
+	maxPoints   int
+	rawMaxRange time.Duration
+}
+
+func NewSeriesHandler(series storage.SeriesReader, retention storage.Retention, maxPoints int, rawMaxRange time.Duration) *SeriesHandler {
+	return &SeriesHandler{
+		series:      series,
+		retention:   retention,
+		maxPoints:   maxPoints,
+		rawMaxRange: rawMaxRange,
+	}
+}
+
+type seriesResponse struct {
+	DeviceID   int             `json:"device_id"`
+	Resolution string          `json:"resolution"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Points     []storage.Point `json:"points"`
+}
+
+func parseTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// HandleSeries обслуживает GET /devices/{device}/packets?from=&to=&resolution=
+func (h *SeriesHandler) HandleSeries(w http.ResponseWriter, r *http.Request) {
+	device, ok := deviceID(r)
+	if !ok {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	q := r.URL.Query()
+	to, err := parseTime(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTime(q.Get("from"), to.Add(-defaultSeriesRange))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	res := storage.ChooseResolution(from, to, now, h.retention, h.maxPoints, h.rawMaxRange)
+	if name := q.Get("resolution"); name != "" && name != "auto" {
+		if res, err = storage.ParseResolution(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	points, err := h.series.ReadSeries(ctx, device, res, from, to, h.maxPoints)
+	if err != nil {
+		slog.Error("read series error", "device_id", device, "resolution", res.Name, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, seriesResponse{
+		DeviceID:   device,
+		Resolution: res.Name,
+		From:       from,
+		To:         to,
+		Points:     points,
+	})
+}
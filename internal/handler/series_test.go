@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+func TestHandleSeries(t *testing.T) {
+	store := storage.NewMemoryPacketStore()
+	now := time.Now().UTC().Truncate(time.Second)
+	// отдельный пакет за позапрошлый час, чтобы часовой агрегат не зависел от момента запуска
+	hour := now.Truncate(time.Hour)
+	for i := 0; i < 10; i++ {
+		ts := now.Add(-time.Duration(i) * time.Minute).Format(time.RFC3339)
+		store.InsertPacket(context.Background(), packet.Packet{DeviceID: 2, Timestamp: ts, Pressure: 0.05, Temperature: 20})
+	}
+	store.InsertPacket(context.Background(), packet.Packet{DeviceID: 2, Timestamp: hour.Add(-90 * time.Minute).Format(time.RFC3339), Pressure: 0.05, Temperature: 20})
+
+	rt := storage.Retention{storage.Raw: 24 * time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /devices/{device}/packets", NewSeriesHandler(store, rt, 2000, 6*time.Hour).HandleSeries)
+
+	tests := []struct {
+		query      string
+		status     int
+		resolution string
+		points     int
+	}{
+		{"", http.StatusOK, "raw", 10},
+		{"?from=" + now.Add(-24*time.Hour).Format(time.RFC3339), http.StatusOK, "1m", 11},
+		{"?resolution=1h&from=" + hour.Add(-2*time.Hour).Format(time.RFC3339) + "&to=" + hour.Add(-time.Hour).Format(time.RFC3339), http.StatusOK, "1h", 1},
+		{"?resolution=5m", http.StatusBadRequest, "", 0},
+		{"?from=tomorrow", http.StatusBadRequest, "", 0},
+		{"?from=" + now.Format(time.RFC3339) + "&to=" + now.Add(-time.Hour).Format(time.RFC3339), http.StatusBadRequest, "", 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/devices/2/packets"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: status = %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp seriesResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Resolution != tt.resolution || len(resp.Points) != tt.points {
+			t.Errorf("%q: resolution %s with %d points, want %s with %d", tt.query, resp.Resolution, len(resp.Points), tt.resolution, tt.points)
+		}
+	}
+}
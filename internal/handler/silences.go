@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/silence"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var silencesCreated = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "silences_created_total",
+		Help: "Total number of silences created",
+	},
+)
+
+// SilenceHandler управляет тишинами на время работ. Закончившиеся тишины
+// удаляются из бд автоматически.
+type SilenceHandler struct {
+	silences storage.SilenceStore
+}
+
+func NewSilenceHandler(silences storage.SilenceStore) *SilenceHandler {
+	return &SilenceHandler{silences: silences}
+}
+
+// Register добавляет маршруты тишин в mux
+func (h *SilenceHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /silences", h.Create)
+	mux.HandleFunc("GET /silences", h.List)
+	mux.HandleFunc("GET /silences/{id}", h.Get)
+	mux.HandleFunc("DELETE /silences/{id}", h.Expire)
+}
+
+func (h *SilenceHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req silence.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	s, err := silence.New(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.silences.CreateSilence(ctx, s); err != nil {
+		slog.Error("create silence error", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	silencesCreated.Inc()
+	slog.Info("silence created", "silence_id", s.ID.Hex(), "devices", s.Devices, "groups", s.Groups, "rules", s.Rules,
+		"starts_at", s.StartsAt, "ends_at", s.EndsAt, "created_by", s.CreatedBy, "reason", s.Reason)
+	writeJSON(w, http.StatusCreated, s)
+}
+
+// List отдаёт действующие и запланированные тишины
+func (h *SilenceHandler) List(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	silences, err := h.silences.Silences(ctx, time.Now())
+	if err != nil {
+		slog.Error("list silences error", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, silences)
+}
+
+func (h *SilenceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	s, err := h.silences.GetSilence(ctx, r.PathValue("id"))
+	writeSilence(w, r, s, err)
+}
+
+// Expire досрочно завершает тишину; запись остаётся до автоматического удаления
+func (h *SilenceHandler) Expire(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	s, err := h.silences.ExpireSilence(ctx, r.PathValue("id"), time.Now())
+	if err == nil {
+		slog.Info("silence expired", "silence_id", s.ID.Hex(), "ends_at", s.EndsAt)
+	}
+	writeSilence(w, r, s, err)
+}
+
+func writeSilence(w http.ResponseWriter, r *http.Request, s silence.Silence, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "silence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("silence error", "silence_id", r.PathValue("id"), "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/silence"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+func TestSilences(t *testing.T) {
+	store := storage.NewMemorySilenceStore()
+	mux := http.NewServeMux()
+	NewSilenceHandler(store).Register(mux)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	end := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	bad := []string{
+		`{"reason":"works","created_by":"ivanov","ends_at":"` + end + `"}`,
+		`{"devices":[7],"created_by":"ivanov","ends_at":"` + end + `"}`,
+		`{"devices":[7],"rules":["pressure_very_low"],"reason":"works","created_by":"ivanov","ends_at":"` + end + `"}`,
+		`{"devices":[7],"reason":"works","created_by":"ivanov","ends_at":"2020-01-01T00:00:00Z"}`,
+		`{"devices":[7],"reason":"works","created_by":"ivanov","ends_at":"2099-01-01T00:00:00Z"}`,
+	}
+	for _, body := range bad {
+		if rec := do(http.MethodPost, "/silences", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", body, rec.Code)
+		}
+	}
+
+	rec := do(http.MethodPost, "/silences", `{"groups":["station-7"],"rules":["pressure_low"],"reason":"valve replacement","created_by":"ivanov","ends_at":"`+end+`"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", rec.Code, rec.Body)
+	}
+	var s silence.Silence
+	json.NewDecoder(rec.Body).Decode(&s)
+
+	a := alert.New(alert.PressureLow, 3, time.Now(), alert.Values{})
+	if !s.Matches(a, map[string][]int{"station-7": {3}}) {
+		t.Errorf("silence %+v does not match %+v", s, a)
+	}
+
+	if rec := do(http.MethodGet, "/silences", ""); !strings.Contains(rec.Body.String(), s.ID.Hex()) {
+		t.Errorf("list = %s", rec.Body)
+	}
+	if rec := do(http.MethodDelete, "/silences/"+s.ID.Hex(), ""); rec.Code != http.StatusOK {
+		t.Fatalf("expire: status = %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/silences", ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("list after expire = %s", rec.Body)
+	}
+	if rec := do(http.MethodGet, "/silences/nope", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown silence: status = %d", rec.Code)
+	}
+
+	// закончившаяся тишина больше не попадает в кэш движка
+	cache := storage.NewSilenceCache(store)
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Match(a, map[string][]int{"station-7": {3}}); ok {
+		t.Error("expired silence still matches")
+	}
+}
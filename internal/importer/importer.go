@@ -0,0 +1,275 @@
+// Package importer загружает историю пакетов из выгрузок SCADA в хранилище пачками.
+// Пакеты проверяются так же, как при приёме контроллером; повторы внутри пачки
+// и пакеты, уже лежащие в хранилище (то же устройство и время), пропускаются.
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+// maxSamples — сколько ошибок чтения и валидации сохраняется в отчёте для показа
+const maxSamples = 10
+
+var ErrTooManyErrors = errors.New("too many invalid records")
+
+type Options struct {
+	BatchSize int
+	// DryRun — только проверить файл и посчитать, что было бы загружено. Повторы
+	// из разных пачек в этом режиме не обнаруживаются, так как в хранилище ничего не пишется.
+	DryRun bool
+	// Publisher — куда публиковать загруженные пакеты для движка правил, nil — не публиковать
+	Publisher  queue.Publisher
+	Partitions int
+	// MaxErrors — после скольких невалидных записей загрузка прерывается, 0 — без ограничения
+	MaxErrors int
+	// Roller — пересчёт агрегатов за загруженные периоды, nil — не пересчитывать
+	Roller Roller
+	// RawRetention — срок хранения сырых пакетов, 0 — бессрочно. Более старые записи
+	// удалит TTL, поэтому они пропускаются, если не задан KeepExpired.
+	RawRetention time.Duration
+	KeepExpired  bool
+}
+
+// Roller пересчитывает агрегаты устройства за период
+type Roller interface {
+	RollupDevice(ctx context.Context, deviceID int, r storage.Resolution, from, to time.Time) error
+}
+
+// Report — итог загрузки. При DryRun Inserted и Published считают, что было бы сделано.
+type Report struct {
+	Read      int      `json:"read"`
+	Invalid   int      `json:"invalid"`
+	Duplicate int      `json:"duplicate"`
+	Existing  int      `json:"existing"`
+	Inserted  int      `json:"inserted"`
+	Published int      `json:"published"`
+	Expired   int      `json:"expired"`
+	Samples   []string `json:"samples,omitempty"`
+}
+
+// Add суммирует отчёты, например по нескольким файлам
+func (r *Report) Add(o Report) {
+	r.Read += o.Read
+	r.Invalid += o.Invalid
+	r.Duplicate += o.Duplicate
+	r.Existing += o.Existing
+	r.Inserted += o.Inserted
+	r.Published += o.Published
+	r.Expired += o.Expired
+	for _, s := range o.Samples {
+		if len(r.Samples) < maxSamples {
+			r.Samples = append(r.Samples, s)
+		}
+	}
+}
+
+type Importer struct {
+	store storage.BulkPacketStore
+	opts  Options
+}
+
+func New(store storage.BulkPacketStore, opts Options) *Importer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	return &Importer{store: store, opts: opts}
+}
+
+type key struct {
+	device int
+	unix   int64
+}
+
+type span struct{ from, to time.Time }
+
+// extend расширяет период устройства до t
+func extend(spans map[int]span, device int, t time.Time) {
+	s, ok := spans[device]
+	if !ok || t.Before(s.from) {
+		s.from = t
+	}
+	if !ok || t.After(s.to) {
+		s.to = t
+	}
+	spans[device] = s
+}
+
+// Import читает выгрузку до конца. Отчёт возвращается и при ошибке — в нём то, что успело загрузиться.
+// Агрегаты за загруженные периоды пересчитываются и при ошибке.
+func (im *Importer) Import(ctx context.Context, r packetio.Reader) (rep Report, err error) {
+	var (
+		batch    []packet.Packet
+		times    []time.Time
+		inserted = make(map[int]span)
+	)
+	flush := func() error {
+		n, err := im.flush(ctx, batch, times, inserted)
+		rep.Add(n)
+		batch, times = batch[:0], times[:0]
+		return err
+	}
+	defer func() {
+		// загруженные пакеты повторный запуск пропустит, поэтому агрегаты считаются и после отмены
+		if rerr := im.rollup(context.WithoutCancel(ctx), inserted); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+	expiredBefore := time.Now().Add(-im.opts.RawRetention)
+
+	for {
+		p, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var rerr *packetio.RecordError
+		if err != nil && !errors.As(err, &rerr) {
+			return rep, err
+		}
+		rep.Read++
+		if err == nil {
+			err = p.Validate()
+		}
+		if err != nil {
+			rep.Invalid++
+			if len(rep.Samples) < maxSamples {
+				rep.Samples = append(rep.Samples, fmt.Sprintf("record %d: %v", rep.Read, err))
+			}
+			if im.opts.MaxErrors > 0 && rep.Invalid >= im.opts.MaxErrors {
+				return rep, ErrTooManyErrors
+			}
+			continue
+		}
+
+		t, _ := time.Parse(time.RFC3339, p.Timestamp)
+		if im.opts.RawRetention > 0 && t.Before(expiredBefore) {
+			rep.Expired++
+			if !im.opts.KeepExpired {
+				if len(rep.Samples) < maxSamples {
+					rep.Samples = append(rep.Samples, fmt.Sprintf("record %d: older than raw retention %s", rep.Read, im.opts.RawRetention))
+				}
+				continue
+			}
+		}
+		batch = append(batch, p)
+		times = append(times, t)
+		if len(batch) >= im.opts.BatchSize {
+			if err := flush(); err != nil {
+				return rep, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// flush отбрасывает повторы внутри пачки и уже сохранённые пакеты, остальное вставляет
+// и при необходимости публикует. Read и Invalid считаются в Import, периоды
+// вставленных пакетов добавляются в inserted.
+func (im *Importer) flush(ctx context.Context, batch []packet.Packet, times []time.Time, inserted map[int]span) (Report, error) {
+	var rep Report
+
+	seen := make(map[key]bool, len(batch))
+	spans := make(map[int]span)
+	unique := batch[:0:0]
+	uniqueTimes := times[:0:0]
+	for i, p := range batch {
+		k := key{p.DeviceID, times[i].Unix()}
+		if seen[k] {
+			rep.Duplicate++
+			continue
+		}
+		seen[k] = true
+		unique = append(unique, p)
+		uniqueTimes = append(uniqueTimes, times[i])
+		extend(spans, p.DeviceID, times[i])
+	}
+
+	existing := make(map[key]bool)
+	for device, s := range spans {
+		stored, err := im.store.PacketTimes(ctx, device, s.from, s.to)
+		if err != nil {
+			return rep, fmt.Errorf("check existing packets: %w", err)
+		}
+		for _, t := range stored {
+			existing[key{device, t.Unix()}] = true
+		}
+	}
+
+	fresh := unique[:0]
+	freshTimes := uniqueTimes[:0]
+	for i, p := range unique {
+		if existing[key{p.DeviceID, uniqueTimes[i].Unix()}] {
+			rep.Existing++
+			continue
+		}
+		fresh = append(fresh, p)
+		freshTimes = append(freshTimes, uniqueTimes[i])
+	}
+	if len(fresh) == 0 {
+		return rep, nil
+	}
+
+	if !im.opts.DryRun {
+		if err := im.store.InsertPackets(ctx, fresh); err != nil {
+			return rep, fmt.Errorf("insert packets: %w", err)
+		}
+		for i, p := range fresh {
+			extend(inserted, p.DeviceID, freshTimes[i])
+		}
+	}
+	rep.Inserted = len(fresh)
+
+	if im.opts.Publisher == nil {
+		return rep, nil
+	}
+	for _, p := range fresh {
+		if !im.opts.DryRun {
+			if err := im.publish(ctx, p); err != nil {
+				// пакеты уже в хранилище: повторный запуск их пропустит и не опубликует
+				return rep, fmt.Errorf("publish packet of device %d at %s: %w", p.DeviceID, p.Timestamp, err)
+			}
+		}
+		rep.Published++
+	}
+	return rep, nil
+}
+
+// rollup пересчитывает агрегаты всех разрешений за периоды загруженных пакетов,
+// расширенные до границ интервалов
+func (im *Importer) rollup(ctx context.Context, inserted map[int]span) error {
+	if im.opts.Roller == nil {
+		return nil
+	}
+	for device, s := range inserted {
+		for _, r := range storage.Rollups {
+			from, to := s.from.Truncate(r.Step), s.to.Truncate(r.Step).Add(r.Step)
+			if err := im.opts.Roller.RollupDevice(ctx, device, r, from, to); err != nil {
+				return fmt.Errorf("rollup %s for device %d: %w", r.Name, device, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (im *Importer) publish(ctx context.Context, p packet.Packet) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	key := queue.RoutingKey(queue.Partition(p.DeviceID, im.opts.Partitions))
+	return im.opts.Publisher.Publish(ctx, key, body)
+}
@@ -0,0 +1,146 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/queue"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+const history = `device_id,timestamp,pressure,temperature
+1,2025-01-01T00:00:00Z,0.05,20
+1,2025-01-01T00:00:10Z,0.05,20
+1,2025-01-01T00:00:10Z,0.05,20
+2,2025-01-01T00:00:00Z,-0.01,20
+2,2025-01-01T00:00:10Z,abc,20
+2,2025-01-01T00:00:20Z,0.05,20
+`
+
+func reader(t *testing.T) packetio.Reader {
+	t.Helper()
+	r, err := packetio.NewCSVReader(strings.NewReader(history))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryPacketStore()
+	store.InsertPacket(ctx, packet.Packet{DeviceID: 1, Timestamp: "2025-01-01T00:00:00Z", Pressure: 0.05, Temperature: 20})
+	broker := queue.NewMemoryBroker()
+	broker.DeclarePartitions("packets", 2)
+
+	im := New(store, Options{BatchSize: 3, Publisher: broker, Partitions: 2})
+	rep, err := im.Import(ctx, reader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := [...]int{rep.Read, rep.Invalid, rep.Duplicate, rep.Existing, rep.Inserted, rep.Published}
+	if want := [...]int{6, 2, 1, 1, 2, 2}; got != want {
+		t.Errorf("read, invalid, duplicate, existing, inserted, published = %v, want %v", got, want)
+	}
+	if n := len(store.Packets(1)); n != 2 {
+		t.Errorf("device 1 has %d packets, want 2", n)
+	}
+	if n := len(store.Packets(2)); n != 1 {
+		t.Errorf("device 2 has %d packets, want 1", n)
+	}
+	if n := broker.Len(queue.PartitionQueue("packets", 0)) + broker.Len(queue.PartitionQueue("packets", 1)); n != 2 {
+		t.Errorf("published %d packets, want 2", n)
+	}
+
+	// повторная загрузка того же файла ничего не добавляет
+	rep, err = New(store, Options{}).Import(ctx, reader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Inserted != 0 || rep.Existing != 3 {
+		t.Errorf("second import = %+v", rep)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	store := storage.NewMemoryPacketStore()
+	rep, err := New(store, Options{DryRun: true}).Import(context.Background(), reader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Inserted != 3 || len(rep.Samples) != 2 {
+		t.Errorf("report = %+v", rep)
+	}
+	if n := len(store.Packets(1)) + len(store.Packets(2)); n != 0 {
+		t.Errorf("dry run stored %d packets", n)
+	}
+}
+
+func TestImportMaxErrors(t *testing.T) {
+	store := storage.NewMemoryPacketStore()
+	rep, err := New(store, Options{MaxErrors: 2}).Import(context.Background(), reader(t))
+	if !errors.Is(err, ErrTooManyErrors) {
+		t.Fatalf("err = %v, want ErrTooManyErrors", err)
+	}
+	// пакеты до прерывания ещё в незаписанной пачке
+	if rep.Invalid != 2 || rep.Inserted != 0 {
+		t.Errorf("report = %+v", rep)
+	}
+}
+
+type rollupCall struct {
+	device   int
+	r        storage.Resolution
+	from, to time.Time
+}
+
+type fakeRoller struct{ calls []rollupCall }
+
+func (f *fakeRoller) RollupDevice(_ context.Context, deviceID int, r storage.Resolution, from, to time.Time) error {
+	f.calls = append(f.calls, rollupCall{deviceID, r, from, to})
+	return nil
+}
+
+func TestImportExpired(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryPacketStore()
+	roller := &fakeRoller{}
+	rep, err := New(store, Options{Roller: roller, RawRetention: time.Hour}).Import(ctx, reader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Expired != 4 || rep.Inserted != 0 || len(roller.calls) != 0 {
+		t.Errorf("report = %+v, rollups = %v, want expired records skipped", rep, roller.calls)
+	}
+
+	rep, err = New(store, Options{Roller: roller, RawRetention: time.Hour, KeepExpired: true}).Import(ctx, reader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Expired != 4 || rep.Inserted != 3 {
+		t.Errorf("report = %+v, want expired records kept", rep)
+	}
+
+	// агрегаты пересчитываются по целым интервалам каждого разрешения
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := map[rollupCall]bool{}
+	for _, device := range []int{1, 2} {
+		for _, r := range storage.Rollups {
+			want[rollupCall{device, r, day, day.Add(r.Step)}] = true
+		}
+	}
+	if len(roller.calls) != len(want) {
+		t.Fatalf("rollups = %v, want %d calls", roller.calls, len(want))
+	}
+	for _, c := range roller.calls {
+		if !want[c] {
+			t.Errorf("unexpected rollup %+v", c)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package loadtest
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	histMin    = 100 * time.Microsecond
+	histGrowth = 1.01 // ширина корзины — 1% от значения
+	histSize   = 1400 // 100µs * 1.01^1400 ≈ 1.1e2 с
+)
+
+// histogram — логарифмическая гистограмма задержек с точностью около 1%.
+// Хранит фиксированное число счётчиков, поэтому длинный прогон не съедает память.
+type histogram struct {
+	counts [histSize + 1]uint64
+	total  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func bucket(d time.Duration) int {
+	if d <= histMin {
+		return 0
+	}
+	i := int(math.Log(float64(d)/float64(histMin))/math.Log(histGrowth)) + 1
+	return min(i, histSize)
+}
+
+func (h *histogram) observe(d time.Duration) {
+	h.counts[bucket(d)]++
+	h.total++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+// quantile возвращает верхнюю границу корзины, в которую попадает квантиль q
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i == histSize {
+				return h.max
+			}
+			return min(time.Duration(float64(histMin)*math.Pow(histGrowth, float64(i))), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
@@ -0,0 +1,314 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Классы результатов запроса
+const (
+	ClassOK      = "ok"
+	Class4xx     = "4xx"
+	Class5xx     = "5xx"
+	ClassTimeout = "timeout"
+	ClassNetwork = "network"
+	// ClassCanceled — запрос прерван остановкой устройства или всего прогона
+	ClassCanceled = "canceled"
+)
+
+const tick = time.Second // шаг пересчёта целевой нагрузки
+
+var (
+	requestDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "loadtest_request_duration_seconds",
+			Help:    "Latency of load test requests to the IoT controller",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+	)
+
+	requestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadtest_requests_total",
+			Help: "Total number of load test requests by result class",
+		},
+		[]string{"class"},
+	)
+
+	activeDevices = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "loadtest_active_devices",
+			Help: "Number of simulated devices currently sending",
+		},
+	)
+
+	targetRate = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "loadtest_target_rate",
+			Help: "Target send rate per device, packets per second",
+		},
+	)
+)
+
+// Stage — этап профиля нагрузки. За Duration число устройств и частота отправки
+// линейно меняются от значений предыдущего этапа (для первого — от нуля) до заданных.
+type Stage struct {
+	Duration time.Duration
+	Devices  int
+	Rate     float64 // пакетов в секунду на устройство
+}
+
+// target возвращает число устройств и частоту в момент elapsed от начала профиля.
+// ok == false, когда профиль закончился.
+func target(stages []Stage, elapsed time.Duration) (devices int, rate float64, ok bool) {
+	var prev Stage
+	for _, s := range stages {
+		if elapsed < s.Duration {
+			f := float64(elapsed) / float64(s.Duration)
+			devices = prev.Devices + int(math.Round(f*float64(s.Devices-prev.Devices)))
+			rate = prev.Rate + f*(s.Rate-prev.Rate)
+			return devices, rate, true
+		}
+		elapsed -= s.Duration
+		prev = s
+	}
+	return 0, 0, false
+}
+
+// Classify относит ошибку отправки к одному из классов результата
+func Classify(err error) string {
+	if err == nil {
+		return ClassOK
+	}
+	var se *sender.StatusError
+	if errors.As(err, &se) {
+		if se.Code >= 500 {
+			return Class5xx
+		}
+		return Class4xx
+	}
+	if errors.Is(err, context.Canceled) {
+		return ClassCanceled
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return ClassTimeout
+	}
+	return ClassNetwork
+}
+
+// Runner генерирует нагрузку по профилю. Каждое устройство отправляет пакеты
+// последовательно (закрытая модель): если контроллер отвечает медленнее периода,
+// фактическая частота падает ниже целевой, что видно в отчёте.
+type Runner struct {
+	stages []Stage
+	sender sender.PacketSender
+
+	rate atomic.Uint64 // math.Float64bits текущей частоты
+
+	mu      sync.Mutex
+	hist    histogram
+	classes map[string]uint64
+}
+
+func NewRunner(stages []Stage, s sender.PacketSender) *Runner {
+	return &Runner{stages: stages, sender: s, classes: make(map[string]uint64)}
+}
+
+// Run выполняет профиль целиком или до отмены ctx и возвращает итог
+func (r *Runner) Run(ctx context.Context) Summary {
+	start := time.Now()
+	var (
+		wg      sync.WaitGroup
+		workers []context.CancelFunc
+	)
+	// итог считается после остановки устройств, чтобы учесть запросы в полёте
+	finish := func() Summary {
+		for _, stop := range workers {
+			stop()
+		}
+		wg.Wait()
+		activeDevices.Set(0)
+		targetRate.Set(0)
+		return r.summary(time.Since(start))
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		devices, rate, ok := target(r.stages, time.Since(start))
+		if !ok {
+			break
+		}
+		r.rate.Store(math.Float64bits(rate))
+		targetRate.Set(rate)
+
+		for len(workers) < devices {
+			wctx, stop := context.WithCancel(ctx)
+			workers = append(workers, stop)
+			id := len(workers)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.device(wctx, id)
+			}()
+		}
+		for len(workers) > devices {
+			workers[len(workers)-1]()
+			workers = workers[:len(workers)-1]
+		}
+		activeDevices.Set(float64(len(workers)))
+
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "load test interrupted", "elapsed", time.Since(start))
+			return finish()
+		case <-ticker.C:
+		}
+	}
+
+	return finish()
+}
+
+func (r *Runner) device(ctx context.Context, id int) {
+	// фаза первой отправки случайна, чтобы устройства не шли строем
+	if rate := math.Float64frombits(r.rate.Load()); rate > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rand.N(time.Duration(float64(time.Second)/rate) + 1)):
+		}
+	}
+
+	for {
+		rate := math.Float64frombits(r.rate.Load())
+		if rate > 0 {
+			sent := time.Now()
+			p := packet.Generate(id, 0.05, 24)
+			err := r.sender.Send(ctx, p)
+			// отправленный запрос учитывается, даже если его прервала остановка
+			class := Classify(err)
+			if err != nil && ctx.Err() != nil {
+				class = ClassCanceled
+			}
+			r.observe(time.Since(sent), class)
+			if ctx.Err() != nil {
+				return
+			}
+
+			wait := time.Duration(float64(time.Second)/rate) - time.Since(sent)
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tick):
+		}
+	}
+}
+
+func (r *Runner) observe(d time.Duration, class string) {
+	requestsTotal.WithLabelValues(class).Inc()
+	// прерванный остановкой запрос не дождался ответа, его длительность ничего не говорит о контроллере
+	if class != ClassCanceled {
+		requestDuration.Observe(d.Seconds())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if class != ClassCanceled {
+		r.hist.observe(d)
+	}
+	r.classes[class]++
+}
+
+// Summary — итог прогона нагрузки
+type Summary struct {
+	Elapsed    time.Duration
+	Requests   uint64
+	Classes    map[string]uint64
+	Mean       time.Duration
+	P50        time.Duration
+	P95        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	Throughput float64 // успешных запросов в секунду
+	ErrorRate  float64 // без прерванных остановкой запросов
+}
+
+func (r *Runner) summary(elapsed time.Duration) Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := Summary{
+		Elapsed:  elapsed,
+		Requests: r.hist.total + r.classes[ClassCanceled],
+		Classes:  make(map[string]uint64, len(r.classes)),
+		Mean:     r.hist.mean(),
+		P50:      r.hist.quantile(0.50),
+		P95:      r.hist.quantile(0.95),
+		P99:      r.hist.quantile(0.99),
+		Max:      r.hist.max,
+	}
+	for class, n := range r.classes {
+		s.Classes[class] = n
+	}
+	if elapsed > 0 {
+		s.Throughput = float64(r.classes[ClassOK]) / elapsed.Seconds()
+	}
+	// доля ошибок считается только по завершившимся запросам, прерванные остановкой не учитываются
+	if completed := r.hist.total; completed > 0 {
+		s.ErrorRate = float64(completed-r.classes[ClassOK]) / float64(completed)
+	}
+	return s
+}
+
+// Print выводит итог в виде таблицы
+func (s Summary) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOAD TEST SUMMARY")
+	fmt.Fprintf(tw, "duration\t%s\n", s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "requests\t%d\n", s.Requests)
+	fmt.Fprintf(tw, "throughput\t%.1f req/s\n", s.Throughput)
+	fmt.Fprintf(tw, "error rate\t%.2f%%\n", s.ErrorRate*100)
+	fmt.Fprintf(tw, "latency mean\t%s\n", s.Mean.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency p50\t%s\n", s.P50.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency p95\t%s\n", s.P95.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency p99\t%s\n", s.P99.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency max\t%s\n", s.Max.Round(time.Microsecond))
+
+	classes := make([]string, 0, len(s.Classes))
+	for class := range s.Classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(tw, "result %s\t%d\n", class, s.Classes[class])
+	}
+	tw.Flush()
+}
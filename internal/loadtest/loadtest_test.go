@@ -0,0 +1,120 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+)
+
+func TestTarget(t *testing.T) {
+	stages := []Stage{
+		{Duration: 10 * time.Second, Devices: 10, Rate: 2},
+		{Duration: 10 * time.Second, Devices: 10, Rate: 2},
+		{Duration: 10 * time.Second, Devices: 0, Rate: 0},
+	}
+	cases := []struct {
+		at      time.Duration
+		devices int
+		rate    float64
+		ok      bool
+	}{
+		{0, 0, 0, true},
+		{5 * time.Second, 5, 1, true},
+		{15 * time.Second, 10, 2, true},
+		{25 * time.Second, 5, 1, true},
+		{30 * time.Second, 0, 0, false},
+	}
+	for _, c := range cases {
+		devices, rate, ok := target(stages, c.at)
+		if devices != c.devices || rate != c.rate || ok != c.ok {
+			t.Errorf("target(%s) = %d, %v, %v; want %d, %v, %v", c.at, devices, rate, ok, c.devices, c.rate, c.ok)
+		}
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 1000; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	for q, want := range map[float64]time.Duration{0.5: 500 * time.Millisecond, 0.95: 950 * time.Millisecond, 0.99: 990 * time.Millisecond} {
+		got := h.quantile(q)
+		if diff := float64(got-want) / float64(want); diff < -0.02 || diff > 0.02 {
+			t.Errorf("p%v = %s, want %s ±2%%", q*100, got, want)
+		}
+	}
+	if h.quantile(1) != time.Second {
+		t.Errorf("p100 = %s, want max", h.quantile(1))
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := map[string]error{
+		ClassOK:       nil,
+		Class4xx:      fmt.Errorf("send: %w", &sender.StatusError{Code: http.StatusBadRequest}),
+		Class5xx:      &sender.StatusError{Code: http.StatusServiceUnavailable},
+		ClassTimeout:  fmt.Errorf("send request: %w", context.DeadlineExceeded),
+		ClassNetwork:  errors.New("connection refused"),
+		ClassCanceled: fmt.Errorf("send request: %w", context.Canceled),
+	}
+	for want, err := range cases {
+		if got := Classify(err); got != want {
+			t.Errorf("Classify(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestRunnerSummary(t *testing.T) {
+	var n atomic.Int64
+	s := sender.SenderFunc(func(context.Context, packet.Packet) error {
+		if n.Add(1)%4 == 0 {
+			return &sender.StatusError{Code: http.StatusInternalServerError}
+		}
+		return nil
+	})
+
+	r := NewRunner([]Stage{{Duration: 1500 * time.Millisecond, Devices: 4, Rate: 50}}, s)
+	summary := r.Run(context.Background())
+
+	if summary.Requests == 0 || summary.Requests != uint64(n.Load()) {
+		t.Fatalf("summary counted %d requests, sender saw %d", summary.Requests, n.Load())
+	}
+	if summary.Classes[Class5xx] == 0 || summary.ErrorRate < 0.2 || summary.ErrorRate > 0.3 {
+		t.Errorf("error rate = %v, classes = %v, want ~25%% 5xx", summary.ErrorRate, summary.Classes)
+	}
+	if summary.Throughput <= 0 {
+		t.Errorf("throughput = %v", summary.Throughput)
+	}
+}
+
+func TestRunnerCountsInterrupted(t *testing.T) {
+	var n atomic.Int64
+	s := sender.SenderFunc(func(ctx context.Context, _ packet.Packet) error {
+		n.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// устройства запускаются на втором шаге профиля и висят в Send до отмены
+	ctx, cancel := context.WithTimeout(context.Background(), tick+300*time.Millisecond)
+	defer cancel()
+	r := NewRunner([]Stage{{Duration: time.Millisecond, Devices: 2, Rate: 100}, {Duration: time.Minute, Devices: 2, Rate: 100}}, s)
+	summary := r.Run(ctx)
+
+	if summary.Requests == 0 || summary.Requests != uint64(n.Load()) {
+		t.Fatalf("summary counted %d requests, sender saw %d", summary.Requests, n.Load())
+	}
+	if summary.Classes[ClassCanceled] != summary.Requests {
+		t.Errorf("classes = %v, want all canceled", summary.Classes)
+	}
+	if summary.ErrorRate != 0 || summary.Max != 0 {
+		t.Errorf("error rate = %v, max = %s, want canceled requests left out", summary.ErrorRate, summary.Max)
+	}
+}
@@ -0,0 +1,287 @@
+// Package alert описывает алерт движка правил — единую схему документа в коллекции
+// алертов для мгновенных и устойчивых правил
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Type string
+
+const (
+	// Instant — показание вышло за порог
+	Instant Type = "instant"
+	// Sustained — давление быстро меняется на окне последних пакетов
+	Sustained Type = "sustained"
+)
+
+// Rule — идентификатор сработавшего правила. Совпадает с прежним текстовым reason,
+// в котором пробелы заменены подчёркиваниями.
+type Rule string
+
+const (
+	PressureLow           Rule = "pressure_low"
+	PressureHigh          Rule = "pressure_high"
+	TemperatureLow        Rule = "temperature_low"
+	TemperatureHigh       Rule = "temperature_high"
+	RapidPressureIncrease Rule = "rapid_pressure_increase"
+	RapidPressureDecrease Rule = "rapid_pressure_decrease"
+)
+
+// Rules — все правила движка
+var Rules = []Rule{PressureLow, PressureHigh, TemperatureLow, TemperatureHigh, RapidPressureIncrease, RapidPressureDecrease}
+
+func (r Rule) Valid() bool {
+	return slices.Contains(Rules, r)
+}
+
+// Reason — человекочитаемое описание правила
+func (r Rule) Reason() string {
+	return strings.ReplaceAll(string(r), "_", " ")
+}
+
+func (r Rule) Type() Type {
+	if r == RapidPressureIncrease || r == RapidPressureDecrease {
+		return Sustained
+	}
+	return Instant
+}
+
+// Severity — важность алерта. Мгновенные правила выдают warning в полосе
+// предупреждения и critical за критическим порогом.
+type Severity string
+
+const (
+	Info     Severity = "info"
+	Warning  Severity = "warning"
+	Critical Severity = "critical"
+)
+
+// Severities — уровни важности по возрастанию
+var Severities = []Severity{Info, Warning, Critical}
+
+func (s Severity) Valid() bool {
+	return slices.Contains(Severities, s)
+}
+
+// AtLeast — уровни не ниже s
+func (s Severity) AtLeast() []Severity {
+	return Severities[max(slices.Index(Severities, s), 0):]
+}
+
+// Below — уровни ниже s
+func (s Severity) Below() []Severity {
+	return Severities[:max(slices.Index(Severities, s), 0)]
+}
+
+// DefaultSeverity — важность по типу: выход за порог критичен, быстрое изменение — предупреждение
+func DefaultSeverity(t Type) Severity {
+	if t == Sustained {
+		return Warning
+	}
+	return Critical
+}
+
+type State string
+
+const (
+	Open         State = "open"
+	Acknowledged State = "acknowledged"
+	Closed       State = "closed"
+)
+
+// Values — показания, на которых сработало правило. У мгновенных правил заполнены
+// давление и температура, у устойчивых — изменение давления на окне.
+type Values struct {
+	Pressure    *float32 `json:"pressure,omitempty" bson:"pressure,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty" bson:"temperature,omitempty"`
+	Change      *float32 `json:"change,omitempty" bson:"change,omitempty"`
+}
+
+type Alert struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id"`
+	Type     Type               `json:"type" bson:"type"`
+	Rule     Rule               `json:"rule" bson:"rule"`
+	Reason   string             `json:"reason" bson:"reason"`
+	Severity Severity           `json:"severity" bson:"severity"`
+	DeviceID int                `json:"device_id" bson:"device_id"`
+	// Timestamp — время пакета, на котором сработало правило
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	Values    Values    `json:"values" bson:"values"`
+	State     State     `json:"state" bson:"state"`
+	// SilenceID — тишина, под которую попал алерт; такие алерты не рассылаются
+	SilenceID string `json:"silence_id,omitempty" bson:"silence_id,omitempty"`
+
+	Assignee       string     `json:"assignee,omitempty" bson:"assignee,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty" bson:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty" bson:"acknowledged_at,omitempty"`
+	ClosedAt       *time.Time `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+	Resolution     Resolution `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	// History — журнал действий операторов в порядке выполнения
+	History []Event `json:"history,omitempty" bson:"history,omitempty"`
+	// Escalation — положение в политике эскалации, nil — алерт не эскалируется
+	Escalation *Escalation `json:"escalation,omitempty" bson:"escalation,omitempty"`
+	// RaisedAt — когда важность алерта повышали в последний раз
+	RaisedAt *time.Time `json:"raised_at,omitempty" bson:"raised_at,omitempty"`
+}
+
+// Escalation — следующий шаг политики и время, когда он наступит. Задержки шагов
+// отсчитываются от Since; после последнего шага NextAt пуст.
+type Escalation struct {
+	Policy string     `json:"policy" bson:"policy"`
+	Since  time.Time  `json:"since" bson:"since"`
+	Step   int        `json:"step" bson:"step"`
+	NextAt *time.Time `json:"next_at,omitempty" bson:"next_at,omitempty"`
+}
+
+// New создаёт открытый алерт. Идентификатор выдаётся сразу, чтобы на алерт можно
+// было сослаться до записи в бд.
+func New(rule Rule, deviceID int, at time.Time, v Values) Alert {
+	now := time.Now().UTC()
+	return Alert{
+		ID:        primitive.NewObjectID(),
+		Type:      rule.Type(),
+		Rule:      rule,
+		Reason:    rule.Reason(),
+		Severity:  DefaultSeverity(rule.Type()),
+		DeviceID:  deviceID,
+		Timestamp: at.UTC(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Values:    v,
+		State:     Open,
+	}
+}
+
+// Float — указатель на значение для Values
+func Float(v float32) *float32 {
+	return &v
+}
+
+// Action — действие оператора над алертом
+type Action string
+
+const (
+	Ack     Action = "ack"
+	Unack   Action = "unack"
+	Assign  Action = "assign"
+	Comment Action = "comment"
+	Close   Action = "close"
+	// Escalate записывает планировщик эскалаций, а не оператор
+	Escalate Action = "escalate"
+	// Raise записывает движок, когда показания открытого алерта пересекли порог
+	// более высокой важности
+	Raise Action = "raise"
+)
+
+// Resolution — код, с которым закрыт алерт
+type Resolution string
+
+const (
+	// Fixed — причина устранена
+	Fixed Resolution = "fixed"
+	// FalsePositive — показания в норме, правило сработало ошибочно
+	FalsePositive Resolution = "false_positive"
+	// Maintenance — ожидаемое срабатывание во время работ на станции
+	Maintenance Resolution = "maintenance"
+	// Duplicate — тот же инцидент уже ведётся по другому алерту
+	Duplicate Resolution = "duplicate"
+)
+
+func (r Resolution) valid() bool {
+	switch r {
+	case Fixed, FalsePositive, Maintenance, Duplicate:
+		return true
+	}
+	return false
+}
+
+// maxComment — ограничение на длину комментария, чтобы журнал не раздувал документ
+const maxComment = 4096
+
+// ErrTransition — действие недопустимо в текущем состоянии алерта
+var ErrTransition = errors.New("action is not allowed in the current alert state")
+
+// Event — запись журнала: кто, когда и что сделал с алертом
+type Event struct {
+	Action     Action     `json:"action" bson:"action"`
+	Operator   string     `json:"operator" bson:"operator"`
+	At         time.Time  `json:"at" bson:"at"`
+	Comment    string     `json:"comment,omitempty" bson:"comment,omitempty"`
+	Assignee   string     `json:"assignee,omitempty" bson:"assignee,omitempty"`
+	Resolution Resolution `json:"resolution,omitempty" bson:"resolution,omitempty"`
+}
+
+func (e Event) Validate() error {
+	if strings.TrimSpace(e.Operator) == "" {
+		return errors.New("operator is required")
+	}
+	if len(e.Comment) > maxComment {
+		return fmt.Errorf("comment is longer than %d bytes", maxComment)
+	}
+	switch e.Action {
+	case Ack, Unack, Escalate, Raise:
+	case Assign:
+		if strings.TrimSpace(e.Assignee) == "" {
+			return errors.New("assignee is required")
+		}
+	case Comment:
+		if strings.TrimSpace(e.Comment) == "" {
+			return errors.New("comment is required")
+		}
+	case Close:
+		if !e.Resolution.valid() {
+			return fmt.Errorf("unknown resolution %q", e.Resolution)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", e.Action)
+	}
+	return nil
+}
+
+// Apply выполняет действие и записывает его в журнал. Подтвердить можно только
+// открытый алерт, снять подтверждение — только подтверждённый; закрытый алерт
+// принимает лишь комментарии.
+func (a *Alert) Apply(e Event) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if a.State == Closed && e.Action != Comment {
+		return fmt.Errorf("%w: alert is %s", ErrTransition, a.State)
+	}
+	at := e.At.UTC()
+
+	switch e.Action {
+	case Ack:
+		if a.State != Open {
+			return fmt.Errorf("%w: alert is %s", ErrTransition, a.State)
+		}
+		a.State = Acknowledged
+		a.AcknowledgedBy = e.Operator
+		a.AcknowledgedAt = &at
+	case Unack:
+		if a.State != Acknowledged {
+			return fmt.Errorf("%w: alert is %s", ErrTransition, a.State)
+		}
+		a.State = Open
+		a.AcknowledgedBy = ""
+		a.AcknowledgedAt = nil
+	case Assign:
+		a.Assignee = e.Assignee
+	case Close:
+		a.State = Closed
+		a.ClosedAt = &at
+		a.Resolution = e.Resolution
+	}
+	a.History = append(a.History, e)
+	a.UpdatedAt = at
+	return nil
+}
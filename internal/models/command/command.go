@@ -0,0 +1,109 @@
+// Package command описывает протокол команд платформы для устройств. Протокол общий
+// для симулятора и реальных шлюзов:
+//
+//	POST /devices/{id}/commands              — платформа создаёт команду
+//	GET  /devices/{id}/commands?wait=30s     — устройство забирает команды (long polling)
+//	POST /devices/{id}/commands/{cmd}/ack    — устройство сообщает результат выполнения
+//	GET  /commands/{cmd}                     — платформа смотрит состояние команды
+//
+// Выданная, но не подтверждённая команда выдаётся повторно через таймаут, поэтому
+// устройство должно выполнять команды идемпотентно.
+package command
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type Type string
+
+const (
+	// SetPeriod меняет период отправки показаний, params.period — в секундах
+	SetPeriod Type = "set_period"
+	// CloseValve перекрывает отсечной клапан
+	CloseValve Type = "close_valve"
+	// OpenValve открывает отсечной клапан
+	OpenValve Type = "open_valve"
+	// Recalibrate сбрасывает накопленный дрейф датчиков
+	Recalibrate Type = "recalibrate"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Final сообщает, что команда выполнена или отклонена устройством
+func (s Status) Final() bool {
+	return s == StatusSucceeded || s == StatusFailed
+}
+
+type Command struct {
+	ID          string             `json:"id" bson:"_id"`
+	DeviceID    int                `json:"device_id" bson:"device_id"`
+	Type        Type               `json:"type" bson:"type"`
+	Params      map[string]float64 `json:"params,omitempty" bson:"params,omitempty"`
+	Status      Status             `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	DeliveredAt *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	AckedAt     *time.Time         `json:"acked_at,omitempty" bson:"acked_at,omitempty"`
+}
+
+// Request — тело запроса на создание команды
+type Request struct {
+	Type   Type               `json:"type"`
+	Params map[string]float64 `json:"params,omitempty"`
+}
+
+// Ack — подтверждение выполнения команды устройством
+type Ack struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r Request) Validate() error {
+	switch r.Type {
+	case SetPeriod:
+		if r.Params["period"] <= 0 {
+			return fmt.Errorf("%s: params.period must be positive", r.Type)
+		}
+	case CloseValve, OpenValve, Recalibrate:
+	default:
+		return fmt.Errorf("unknown command type %q", r.Type)
+	}
+	return nil
+}
+
+func (a Ack) Validate() error {
+	if !a.Status.Final() {
+		return fmt.Errorf("ack status must be %q or %q", StatusSucceeded, StatusFailed)
+	}
+	return nil
+}
+
+// New создаёт команду в статусе pending
+func New(deviceID int, r Request) Command {
+	return Command{
+		ID:        NewID(),
+		DeviceID:  deviceID,
+		Type:      r.Type,
+		Params:    r.Params,
+		Status:    StatusPending,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// NewID возвращает случайный идентификатор команды
+func NewID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
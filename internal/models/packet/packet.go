@@ -1,16 +1,35 @@
 package packet
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"time"
 )
 
 type Packet struct {
-	Timestamp   string  `json:"timestamp"`
-	DeviceID    int     `json:"device_id"`
-	Pressure    float32 `json:"pressure"`
-	Temperature float32 `json:"temperature"`
+	Timestamp   string  `json:"timestamp" bson:"timestamp"`
+	DeviceID    int     `json:"device_id" bson:"device_id"`
+	Pressure    float32 `json:"pressure" bson:"pressure"`
+	Temperature float32 `json:"temperature" bson:"temperature"`
+}
+
+var (
+	ErrInvalid   = errors.New("invalid packet")
+	ErrTimestamp = errors.New("invalid timestamp")
+)
+
+// Validate проверяет пакет по правилам приёма контроллера: устройство и время заданы,
+// время в RFC3339, давление и температура неотрицательны
+func (p Packet) Validate() error {
+	if p.DeviceID <= 0 || p.Timestamp == "" || p.Pressure < 0 || p.Temperature < 0 {
+		return ErrInvalid
+	}
+	if _, err := time.Parse(time.RFC3339, p.Timestamp); err != nil {
+		return fmt.Errorf("%w: %w", ErrTimestamp, err)
+	}
+	return nil
 }
 
 // Генерация реалистичных значений для газораспределительной станции
@@ -0,0 +1,129 @@
+// Package silence описывает окна обслуживания: на время работ алерты по выбранным
+// устройствам, группам устройств или правилам записываются с пометкой и не рассылаются
+package silence
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxDuration — самое длинное окно: бессрочная тишина скрыла бы реальную аварию
+const MaxDuration = 30 * 24 * time.Hour
+
+// Silence действует на алерт, если время пакета попадает в [StartsAt, EndsAt) и алерт
+// подходит под все заданные условия: устройство входит в Devices или в одну из Groups,
+// правило — в Rules. Пустое условие не ограничивает, но хотя бы одно должно быть задано.
+type Silence struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Devices   []int              `json:"devices,omitempty" bson:"devices,omitempty"`
+	Groups    []string           `json:"groups,omitempty" bson:"groups,omitempty"`
+	Rules     []alert.Rule       `json:"rules,omitempty" bson:"rules,omitempty"`
+	StartsAt  time.Time          `json:"starts_at" bson:"starts_at"`
+	EndsAt    time.Time          `json:"ends_at" bson:"ends_at"`
+	Reason    string             `json:"reason" bson:"reason"`
+	CreatedBy string             `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Request — тело запроса на создание тишины. Пустой StartsAt — с текущего момента.
+type Request struct {
+	Devices   []int        `json:"devices,omitempty"`
+	Groups    []string     `json:"groups,omitempty"`
+	Rules     []alert.Rule `json:"rules,omitempty"`
+	StartsAt  time.Time    `json:"starts_at"`
+	EndsAt    time.Time    `json:"ends_at"`
+	Reason    string       `json:"reason"`
+	CreatedBy string       `json:"created_by"`
+}
+
+func (r Request) Validate() error {
+	if len(r.Devices) == 0 && len(r.Groups) == 0 && len(r.Rules) == 0 {
+		return errors.New("at least one of devices, groups or rules is required")
+	}
+	for _, d := range r.Devices {
+		if d <= 0 {
+			return fmt.Errorf("invalid device %d", d)
+		}
+	}
+	for _, rule := range r.Rules {
+		if !rule.Valid() {
+			return fmt.Errorf("unknown rule %q", rule)
+		}
+	}
+	if strings.TrimSpace(r.Reason) == "" {
+		return errors.New("reason is required")
+	}
+	if strings.TrimSpace(r.CreatedBy) == "" {
+		return errors.New("created_by is required")
+	}
+	if r.EndsAt.IsZero() {
+		return errors.New("ends_at is required")
+	}
+	if !r.StartsAt.IsZero() && !r.EndsAt.After(r.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// New создаёт тишину по проверенному запросу
+func New(r Request, now time.Time) (Silence, error) {
+	if err := r.Validate(); err != nil {
+		return Silence{}, err
+	}
+	now = now.UTC()
+	start := r.StartsAt.UTC()
+	if start.IsZero() {
+		start = now
+	}
+	end := r.EndsAt.UTC()
+	if !end.After(now) {
+		return Silence{}, errors.New("ends_at must be in the future")
+	}
+	if end.Sub(start) > MaxDuration {
+		return Silence{}, fmt.Errorf("silence is longer than %s", MaxDuration)
+	}
+	return Silence{
+		ID:        primitive.NewObjectID(),
+		Devices:   r.Devices,
+		Groups:    r.Groups,
+		Rules:     r.Rules,
+		StartsAt:  start,
+		EndsAt:    end,
+		Reason:    r.Reason,
+		CreatedBy: r.CreatedBy,
+		CreatedAt: now,
+	}, nil
+}
+
+// Active сообщает, действует ли тишина в момент at
+func (s Silence) Active(at time.Time) bool {
+	return !at.Before(s.StartsAt) && at.Before(s.EndsAt)
+}
+
+// Matches проверяет алерт; groups — состав групп устройств: имя -> устройства
+func (s Silence) Matches(a alert.Alert, groups map[string][]int) bool {
+	if !s.Active(a.Timestamp) {
+		return false
+	}
+	if len(s.Rules) > 0 && !slices.Contains(s.Rules, a.Rule) {
+		return false
+	}
+	if len(s.Devices) == 0 && len(s.Groups) == 0 {
+		return true
+	}
+	if slices.Contains(s.Devices, a.DeviceID) {
+		return true
+	}
+	for _, g := range s.Groups {
+		if slices.Contains(groups[g], a.DeviceID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+// Package notify рассылает уведомления о новых алертах по каналам (webhook, журнал).
+// Алерты читаются из коллекции именованным подписчиком, поэтому после перезапуска
+// рассылка продолжается с того же места.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// sendTimeout — ограничение на отправку одного уведомления в один канал
+const sendTimeout = 10 * time.Second
+
+var (
+	notificationsSent = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notifications_sent_total",
+			Help: "Total number of alert notifications sent, by channel and result",
+		},
+		[]string{"channel", "result"},
+	)
+
+	notificationsSuppressed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notifications_suppressed_total",
+			Help: "Total number of alert notifications skipped, by reason",
+		},
+		[]string{"reason"},
+	)
+)
+
+// Channel — получатель уведомлений
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, a alert.Alert) error
+}
+
+// Notifier отправляет во все каналы каждый новый алерт и каждое повышение его
+// важности. Алерты под тишиной не рассылаются. Если по тому же устройству и правилу
+// уже есть подтверждённый оператором алерт, повторное уведомление тоже не
+// отправляется: инцидент уже в работе.
+type Notifier struct {
+	source   storage.AlertSource
+	alerts   storage.AlertRepository
+	channels []Channel
+}
+
+func New(source storage.AlertSource, alerts storage.AlertRepository, channels ...Channel) *Notifier {
+	return &Notifier{source: source, alerts: alerts, channels: channels}
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	slog.Info("notifier started", "channels", len(n.channels))
+	for a := range n.source.WatchAlerts(ctx, storage.AlertFilter{}) {
+		n.handle(ctx, a)
+	}
+	slog.Info("notifier stopped")
+}
+
+func (n *Notifier) handle(ctx context.Context, a alert.Alert) {
+	if reason, err := n.suppressed(ctx, a); err != nil {
+		// при недоступной бд лучше уведомить лишний раз, чем пропустить инцидент
+		slog.Error("notifier: suppression check error", "alert_id", a.ID.Hex(), "err", err)
+	} else if reason != "" {
+		notificationsSuppressed.WithLabelValues(reason).Inc()
+		slog.Info("notification suppressed", "alert_id", a.ID.Hex(), "device_id", a.DeviceID, "rule", a.Rule, "reason", reason)
+		return
+	}
+
+	for _, ch := range n.channels {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := ch.Send(sendCtx, a)
+		cancel()
+		if err != nil {
+			notificationsSent.WithLabelValues(ch.Name(), "error").Inc()
+			slog.Error("send notification error", "channel", ch.Name(), "alert_id", a.ID.Hex(), "err", err)
+			continue
+		}
+		notificationsSent.WithLabelValues(ch.Name(), "ok").Inc()
+	}
+}
+
+// suppressed возвращает причину, по которой уведомление не нужно, или пустую строку.
+// Подтверждение алерта меньшей важности не глушит уведомления о более важном
+func (n *Notifier) suppressed(ctx context.Context, a alert.Alert) (string, error) {
+	if a.SilenceID != "" {
+		return "silenced", nil
+	}
+	acked, err := n.alerts.CountAlerts(ctx, storage.AlertQuery{
+		Devices:    []int{a.DeviceID},
+		Rules:      []alert.Rule{a.Rule},
+		States:     []alert.State{alert.Acknowledged},
+		Severities: a.Severity.AtLeast(),
+		Limit:      1,
+	})
+	if err != nil {
+		return "", err
+	}
+	if acked > 0 {
+		return "acknowledged", nil
+	}
+	return "", nil
+}
+
+// Webhook отправляет алерт POST-запросом с телом в JSON
+type Webhook struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(name, url string) *Webhook {
+	return &Webhook{name: name, url: url, client: &http.Client{}}
+}
+
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+func (w *Webhook) Send(ctx context.Context, a alert.Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: unexpected status %s", w.name, resp.Status)
+	}
+	return nil
+}
+
+// Log пишет уведомление в журнал приложения, откуда его забирает logstash
+type Log struct{}
+
+func (Log) Name() string {
+	return "log"
+}
+
+func (Log) Send(_ context.Context, a alert.Alert) error {
+	slog.Warn("alert notification", "alert_id", a.ID.Hex(), "device_id", a.DeviceID, "rule", a.Rule,
+		"severity", a.Severity, "timestamp", a.Timestamp)
+	return nil
+}
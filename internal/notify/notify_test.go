@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	sent []alert.Alert
+}
+
+func (r *recorder) Name() string { return "test" }
+
+func (r *recorder) Send(_ context.Context, a alert.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, a)
+	return nil
+}
+
+func TestNotifierSuppression(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryAlertStore()
+	ch := &recorder{}
+	n := New(store, store, ch)
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	first := alert.New(alert.PressureHigh, 1, at, alert.Values{})
+	store.InsertAlert(ctx, first)
+	n.handle(ctx, first)
+
+	if _, err := store.ApplyAlertEvent(ctx, first.ID.Hex(), alert.Event{Action: alert.Ack, Operator: "ivanov", At: at}); err != nil {
+		t.Fatal(err)
+	}
+	// то же правило на том же устройстве — уже в работе
+	n.handle(ctx, alert.New(alert.PressureHigh, 1, at.Add(time.Second), alert.Values{}))
+	// другое правило и другое устройство уведомляются
+	n.handle(ctx, alert.New(alert.TemperatureHigh, 1, at.Add(time.Second), alert.Values{}))
+	n.handle(ctx, alert.New(alert.PressureHigh, 2, at.Add(time.Second), alert.Values{}))
+	silenced := alert.New(alert.PressureLow, 3, at, alert.Values{})
+	silenced.SilenceID = "65f000000000000000000001"
+	n.handle(ctx, silenced)
+
+	if _, err := store.ApplyAlertEvent(ctx, first.ID.Hex(), alert.Event{Action: alert.Close, Operator: "ivanov", At: at, Resolution: alert.Fixed}); err != nil {
+		t.Fatal(err)
+	}
+	n.handle(ctx, alert.New(alert.PressureHigh, 1, at.Add(2*time.Second), alert.Values{}))
+
+	if len(ch.sent) != 4 {
+		t.Fatalf("sent %d notifications, want 4", len(ch.sent))
+	}
+	if a := ch.sent[3]; a.DeviceID != 1 || a.Rule != alert.PressureHigh {
+		t.Errorf("after close = %+v", a)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got alert.Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.DeviceID == 13 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook("ops", srv.URL)
+	a := alert.New(alert.TemperatureLow, 7, time.Now(), alert.Values{Temperature: alert.Float(2)})
+	if err := hook.Send(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != a.ID || got.Rule != alert.TemperatureLow {
+		t.Errorf("webhook received %+v", got)
+	}
+	if err := hook.Send(context.Background(), alert.New(alert.TemperatureLow, 13, time.Now(), alert.Values{})); err == nil {
+		t.Error("expected error on 502")
+	}
+}
+
+// subscribed — источник из уже оформленной подписки
+type subscribed <-chan alert.Alert
+
+func (s subscribed) WatchAlerts(context.Context, storage.AlertFilter) <-chan alert.Alert {
+	return s
+}
+
+func TestNotifierSeesRaise(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := storage.NewMemoryAlertStore()
+	ch := &recorder{}
+	// подписываемся до запуска, чтобы не потерять алерты, вставленные сразу
+	n := New(subscribed(store.WatchAlerts(ctx, storage.AlertFilter{})), store, ch)
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	warning := alert.New(alert.PressureHigh, 1, at, alert.Values{Pressure: alert.Float(0.065)})
+	warning.Severity = alert.Warning
+	store.InsertAlert(ctx, warning)
+
+	critical := alert.New(alert.PressureHigh, 1, at.Add(time.Minute), alert.Values{Pressure: alert.Float(0.08)})
+	raised, ok, err := store.RaiseSeverity(ctx, critical, alert.Event{Action: alert.Raise, Operator: "engine", At: critical.CreatedAt})
+	if err != nil || !ok {
+		t.Fatalf("raise = %v, %v", ok, err)
+	}
+	if raised.ID != warning.ID || !raised.Timestamp.Equal(critical.Timestamp) || *raised.Values.Pressure != 0.08 || raised.RaisedAt == nil {
+		t.Errorf("raised alert = %+v", raised)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		ch.mu.Lock()
+		sent := len(ch.sent)
+		ch.mu.Unlock()
+		if sent == 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(ch.sent) != 2 || ch.sent[1].ID != warning.ID || ch.sent[1].Severity != alert.Critical {
+		t.Fatalf("sent %+v, want the warning and then its raise to critical", ch.sent)
+	}
+}
@@ -0,0 +1,281 @@
+package packetio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+// Reader последовательно читает пакеты из выгрузки. В конце данных возвращает io.EOF.
+type Reader interface {
+	Read() (packet.Packet, error)
+}
+
+// RecordError — ошибка разбора одной записи выгрузки. После неё чтение можно продолжить,
+// остальные ошибки Read окончательные.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Mapping задаёт имена полей выгрузки, из которых берутся поля пакета.
+// TimeLayout — формат времени в выгрузке: пустой — RFC3339 как есть, "unix" — секунды epoch,
+// иначе layout из пакета time. Прочитанное время приводится к RFC3339 UTC.
+type Mapping struct {
+	DeviceID    string
+	Timestamp   string
+	Pressure    string
+	Temperature string
+	TimeLayout  string
+}
+
+var DefaultMapping = Mapping{
+	DeviceID:    "device_id",
+	Timestamp:   "timestamp",
+	Pressure:    "pressure",
+	Temperature: "temperature",
+}
+
+func (m Mapping) fields() []string {
+	return []string{m.DeviceID, m.Timestamp, m.Pressure, m.Temperature}
+}
+
+// build собирает пакет из строковых значений полей в порядке fields()
+func (m Mapping) build(values []string) (packet.Packet, error) {
+	deviceID, err := strconv.Atoi(strings.TrimSpace(values[0]))
+	if err != nil {
+		return packet.Packet{}, fmt.Errorf("%s: %w", m.DeviceID, err)
+	}
+	timestamp, err := m.timestamp(strings.TrimSpace(values[1]))
+	if err != nil {
+		return packet.Packet{}, fmt.Errorf("%s: %w", m.Timestamp, err)
+	}
+	pressure, err := strconv.ParseFloat(strings.TrimSpace(values[2]), 32)
+	if err != nil {
+		return packet.Packet{}, fmt.Errorf("%s: %w", m.Pressure, err)
+	}
+	temperature, err := strconv.ParseFloat(strings.TrimSpace(values[3]), 32)
+	if err != nil {
+		return packet.Packet{}, fmt.Errorf("%s: %w", m.Temperature, err)
+	}
+
+	return packet.Packet{
+		DeviceID:    deviceID,
+		Timestamp:   timestamp,
+		Pressure:    float32(pressure),
+		Temperature: float32(temperature),
+	}, nil
+}
+
+func (m Mapping) timestamp(v string) (string, error) {
+	switch m.TimeLayout {
+	case "":
+		return v, nil
+	case "unix":
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", err
+		}
+		return time.Unix(0, int64(secs*float64(time.Second))).UTC().Format(time.RFC3339), nil
+	default:
+		t, err := time.Parse(m.TimeLayout, v)
+		if err != nil {
+			return "", err
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	}
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+	mapping Mapping
+}
+
+// NewNDJSONReader читает по одному JSON-объекту пакета на строку, пустые строки пропускаются
+func NewNDJSONReader(r io.Reader) Reader {
+	return NewNDJSONReaderMapping(r, DefaultMapping)
+}
+
+// NewNDJSONReaderMapping читает NDJSON с произвольными именами полей. Значения
+// могут быть как числами, так и строками.
+func NewNDJSONReaderMapping(r io.Reader, m Mapping) Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ndjsonReader{scanner: scanner, mapping: m}
+}
+
+func (r *ndjsonReader) Read() (packet.Packet, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		p, err := r.decode([]byte(line))
+		if err != nil {
+			return packet.Packet{}, &RecordError{Line: r.line, Err: err}
+		}
+		return p, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return packet.Packet{}, err
+	}
+	return packet.Packet{}, io.EOF
+}
+
+func (r *ndjsonReader) decode(line []byte) (packet.Packet, error) {
+	if r.mapping == DefaultMapping {
+		var p packet.Packet
+		err := json.Unmarshal(line, &p)
+		return p, err
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(line, &obj); err != nil {
+		return packet.Packet{}, err
+	}
+	names := r.mapping.fields()
+	values := make([]string, len(names))
+	for i, name := range names {
+		raw, ok := obj[name]
+		if !ok {
+			return packet.Packet{}, fmt.Errorf("missing field %q", name)
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			// не строка — берём литерал числа как есть
+			s = string(raw)
+		}
+		values[i] = s
+	}
+	return r.mapping.build(values)
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []int // индексы колонок в порядке Mapping.fields()
+	mapping Mapping
+	line    int
+}
+
+// NewCSVReader читает CSV с заголовком device_id,timestamp,pressure,temperature (порядок колонок любой)
+func NewCSVReader(r io.Reader) (Reader, error) {
+	return NewCSVReaderMapping(r, DefaultMapping)
+}
+
+// NewCSVReaderMapping читает CSV с заголовком, имена колонок берутся из m.
+// Лишние колонки игнорируются.
+func NewCSVReaderMapping(r io.Reader, m Mapping) (Reader, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	var columns []int
+	for _, name := range m.fields() {
+		i, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("csv header: missing column %q", name)
+		}
+		columns = append(columns, i)
+	}
+
+	return &csvReader{r: cr, columns: columns, mapping: m, line: 1}, nil
+}
+
+func (r *csvReader) Read() (packet.Packet, error) {
+	record, err := r.r.Read()
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		r.line = perr.Line
+		return packet.Packet{}, &RecordError{Line: perr.Line, Err: perr.Err}
+	}
+	if err != nil {
+		return packet.Packet{}, err
+	}
+	r.line++
+
+	values := make([]string, len(r.columns))
+	for i, c := range r.columns {
+		values[i] = record[c]
+	}
+	p, err := r.mapping.build(values)
+	if err != nil {
+		return packet.Packet{}, &RecordError{Line: r.line, Err: err}
+	}
+	return p, nil
+}
+
+// Open открывает файл выгрузки, формат определяется по расширению (.csv, .ndjson, .jsonl)
+func Open(path string) (Reader, io.Closer, error) {
+	return OpenFormat(path, "", DefaultMapping)
+}
+
+// OpenFormat открывает файл выгрузки в формате format ("csv" или "ndjson") с заданным
+// соответствием полей. Пустой format — определение по расширению.
+func OpenFormat(path, format string, m Mapping) (Reader, io.Closer, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch format {
+	case "csv":
+		r, err := NewCSVReaderMapping(f, m)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return r, f, nil
+	case "ndjson", "jsonl", "json":
+		return NewNDJSONReaderMapping(f, m), f, nil
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("unsupported file format: %s", path)
+	}
+}
+
+// ReadAll читает все пакеты до конца выгрузки
+func ReadAll(r Reader) ([]packet.Packet, error) {
+	var packets []packet.Packet
+	for {
+		p, err := r.Read()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, p)
+	}
+}
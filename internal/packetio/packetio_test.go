@@ -0,0 +1,49 @@
+package packetio
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+func TestMapping(t *testing.T) {
+	m := Mapping{
+		DeviceID:    "station",
+		Timestamp:   "ts",
+		Pressure:    "p_mpa",
+		Temperature: "t_c",
+		TimeLayout:  "unix",
+	}
+	want := packet.Packet{DeviceID: 7, Timestamp: "2025-03-01T10:00:00Z", Pressure: 0.05, Temperature: 21.5}
+
+	csvData := "extra,t_c,station,p_mpa,ts\nx,21.5,7,0.05,1740823200\n"
+	ndjsonData := `{"station":"7","ts":1740823200,"p_mpa":0.05,"t_c":"21.5","extra":true}` + "\n"
+
+	cr, err := NewCSVReaderMapping(strings.NewReader(csvData), m)
+	if err != nil {
+		t.Fatalf("csv reader: %v", err)
+	}
+	readers := map[string]Reader{
+		"csv":    cr,
+		"ndjson": NewNDJSONReaderMapping(strings.NewReader(ndjsonData), m),
+	}
+
+	for name, r := range readers {
+		got, err := ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: read: %v", name, err)
+		}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestMappingMissingColumn(t *testing.T) {
+	m := DefaultMapping
+	m.Pressure = "p_mpa"
+	if _, err := NewCSVReaderMapping(strings.NewReader("device_id,timestamp,pressure,temperature\n"), m); err == nil {
+		t.Error("missing mapped column accepted")
+	}
+}
@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+type memMessage struct {
+	id          uint64
+	body        []byte
+	headers     map[string]string
+	redelivered bool
+}
+
+type memQueue struct {
+	messages []memMessage
+	ready    chan struct{} // сигнал о появлении сообщений
+	consumed bool
+}
+
+func (q *memQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// MemoryBroker — брокер сообщений внутри процесса с семантикой, повторяющей RabbitMQ:
+// маршрутизация по ключу, эксклюзивные подписчики, ack/nack и повторная доставка.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	seq      uint64
+	queues   map[string]*memQueue
+	bindings map[string][]string // ключ маршрутизации -> очереди
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:   make(map[string]*memQueue),
+		bindings: make(map[string][]string),
+	}
+}
+
+func (b *MemoryBroker) DeclareQueue(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.declare(name)
+}
+
+func (b *MemoryBroker) declare(name string) *memQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memQueue{ready: make(chan struct{}, 1)}
+		b.queues[name] = q
+	}
+	return q
+}
+
+func (b *MemoryBroker) Bind(queue, routingKey string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.declare(queue)
+	for _, name := range b.bindings[routingKey] {
+		if name == queue {
+			return
+		}
+	}
+	b.bindings[routingKey] = append(b.bindings[routingKey], queue)
+}
+
+// DeclarePartitions повторяет топологию DeclarePartitions для RabbitMQ
+func (b *MemoryBroker) DeclarePartitions(prefix string, n int) {
+	for i := 0; i < n; i++ {
+		b.Bind(PartitionQueue(prefix, i), RoutingKey(i))
+	}
+}
+
+// Publish кладёт сообщение во все очереди, привязанные к ключу. Если привязок нет,
+// ключ трактуется как имя очереди (как default exchange в RabbitMQ).
+// Сообщения без получателя отбрасываются.
+func (b *MemoryBroker) Publish(ctx context.Context, routingKey string, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	headers := make(map[string]string)
+	tracing.Inject(ctx, propagation.MapCarrier(headers))
+
+	targets := b.bindings[routingKey]
+	if len(targets) == 0 {
+		if _, ok := b.queues[routingKey]; ok {
+			targets = []string{routingKey}
+		}
+	}
+	for _, name := range targets {
+		q := b.queues[name]
+		b.seq++
+		q.messages = append(q.messages, memMessage{id: b.seq, body: append([]byte(nil), body...), headers: headers})
+		q.notify()
+	}
+	return nil
+}
+
+// Len возвращает число сообщений, ожидающих доставки в очереди
+func (b *MemoryBroker) Len(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[queue]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, queue string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	q, ok := b.queues[queue]
+	if !ok {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("queue %s not found", queue)
+	}
+	if q.consumed {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("queue %s already has an exclusive consumer", queue)
+	}
+	q.consumed = true
+	b.mu.Unlock()
+
+	out := make(chan Delivery)
+	go b.consume(ctx, q, out)
+	return out, nil
+}
+
+func (b *MemoryBroker) consume(ctx context.Context, q *memQueue, out chan<- Delivery) {
+	var (
+		inflightMu sync.Mutex
+		inflight   = make(map[uint64]memMessage)
+	)
+
+	defer func() {
+		close(out)
+
+		// неподтверждённые сообщения возвращаются в очередь на свои исходные места
+		inflightMu.Lock()
+		pending := inflight
+		inflight = nil
+		inflightMu.Unlock()
+
+		b.mu.Lock()
+		for _, m := range pending {
+			m.redelivered = true
+			q.insert(m)
+		}
+		q.consumed = false
+		if len(q.messages) > 0 {
+			q.notify()
+		}
+		b.mu.Unlock()
+	}()
+
+	settle := func(id uint64) (memMessage, error) {
+		inflightMu.Lock()
+		defer inflightMu.Unlock()
+		m, ok := inflight[id]
+		if !ok {
+			return memMessage{}, fmt.Errorf("delivery %d already settled or subscription closed", id)
+		}
+		delete(inflight, id)
+		return m, nil
+	}
+
+	for {
+		b.mu.Lock()
+		if len(q.messages) == 0 {
+			b.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.ready:
+				continue
+			}
+		}
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		b.mu.Unlock()
+
+		d := Delivery{
+			Body:        m.body,
+			Redelivered: m.redelivered,
+			Headers:     m.headers,
+			ack: func() error {
+				_, err := settle(m.id)
+				return err
+			},
+			nack: func(requeue bool) error {
+				m, err := settle(m.id)
+				if err != nil || !requeue {
+					return err
+				}
+				m.redelivered = true
+				b.mu.Lock()
+				q.insert(m)
+				q.notify()
+				b.mu.Unlock()
+				return nil
+			},
+		}
+
+		inflightMu.Lock()
+		inflight[m.id] = m
+		inflightMu.Unlock()
+
+		select {
+		case out <- d:
+			continue
+		case <-q.ready:
+			// очередь изменилась, пока получатель был занят (например, nack вернул
+			// более раннее сообщение) — кладём сообщение обратно и берём голову заново
+		case <-ctx.Done():
+		}
+
+		inflightMu.Lock()
+		delete(inflight, m.id)
+		inflightMu.Unlock()
+
+		b.mu.Lock()
+		q.insert(m)
+		b.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// insert возвращает сообщение в очередь, сохраняя порядок публикации
+func (q *memQueue) insert(m memMessage) {
+	i := sort.Search(len(q.messages), func(i int) bool { return q.messages[i].id > m.id })
+	q.messages = append(q.messages, memMessage{})
+	copy(q.messages[i+1:], q.messages[i:])
+	q.messages[i] = m
+}
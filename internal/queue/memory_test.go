@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, msgs <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-msgs:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+	}
+	return Delivery{}
+}
+
+func TestMemoryBrokerRouting(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBroker()
+	b.DeclarePartitions("packets", 2)
+	b.DeclareQueue("direct")
+
+	b.Publish(ctx, RoutingKey(1), []byte("p1"))
+	b.Publish(ctx, "direct", []byte("d"))
+	b.Publish(ctx, "unrouted", []byte("lost"))
+
+	if n := b.Len(PartitionQueue("packets", 0)); n != 0 {
+		t.Errorf("partition 0 has %d messages, want 0", n)
+	}
+	if n := b.Len(PartitionQueue("packets", 1)); n != 1 {
+		t.Errorf("partition 1 has %d messages, want 1", n)
+	}
+	if n := b.Len("direct"); n != 1 {
+		t.Errorf("direct queue has %d messages, want 1", n)
+	}
+}
+
+func TestMemoryBrokerAckNack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := NewMemoryBroker()
+	b.DeclareQueue("q")
+	b.Publish(ctx, "q", []byte("a"))
+	b.Publish(ctx, "q", []byte("b"))
+
+	msgs, err := b.Subscribe(ctx, "q")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	d := receive(t, msgs)
+	if string(d.Body) != "a" || d.Redelivered {
+		t.Fatalf("got %q redelivered=%v, want fresh a", d.Body, d.Redelivered)
+	}
+	if err := d.Nack(true); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+
+	d = receive(t, msgs)
+	if string(d.Body) != "a" || !d.Redelivered {
+		t.Fatalf("got %q redelivered=%v, want redelivered a", d.Body, d.Redelivered)
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := d.Ack(); err == nil {
+		t.Error("second ack succeeded, want error")
+	}
+
+	d = receive(t, msgs)
+	if string(d.Body) != "b" {
+		t.Fatalf("got %q, want b", d.Body)
+	}
+	if err := d.Nack(false); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+	if n := b.Len("q"); n != 0 {
+		t.Errorf("queue has %d messages after reject, want 0", n)
+	}
+}
+
+func TestMemoryBrokerRequeueOnUnsubscribe(t *testing.T) {
+	b := NewMemoryBroker()
+	b.DeclareQueue("q")
+	for _, body := range []string{"a", "b", "c"} {
+		b.Publish(context.Background(), "q", []byte(body))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, err := b.Subscribe(ctx, "q")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if _, err := b.Subscribe(ctx, "q"); err == nil {
+		t.Error("second subscriber accepted, want exclusive consumer error")
+	}
+
+	first := receive(t, msgs)
+	receive(t, msgs) // b остаётся неподтверждённым
+	first.Ack()
+	cancel()
+
+	// подписка освобождается асинхронно
+	deadline := time.Now().Add(time.Second)
+	for {
+		msgs, err = b.Subscribe(context.Background(), "q")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("resubscribe: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d := receive(t, msgs)
+	if string(d.Body) != "b" || !d.Redelivered {
+		t.Errorf("got %q redelivered=%v, want redelivered b", d.Body, d.Redelivered)
+	}
+	d.Ack()
+	if d := receive(t, msgs); string(d.Body) != "c" || d.Redelivered {
+		t.Errorf("got %q redelivered=%v, want fresh c", d.Body, d.Redelivered)
+	}
+}
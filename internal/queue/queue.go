@@ -0,0 +1,34 @@
+package queue
+
+import "context"
+
+// Delivery — сообщение, полученное подписчиком. Каждое сообщение должно быть
+// подтверждено (Ack) или отклонено (Nack), иначе оно вернётся в очередь при отписке.
+type Delivery struct {
+	Body        []byte
+	Redelivered bool
+	// Headers — строковые заголовки сообщения, в том числе контекст трассировки
+	Headers map[string]string
+
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+func (d Delivery) Ack() error {
+	return d.ack()
+}
+
+func (d Delivery) Nack(requeue bool) error {
+	return d.nack(requeue)
+}
+
+// Publisher публикует сообщение, передавая в заголовках контекст трассировки из ctx
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte) error
+}
+
+type Subscriber interface {
+	// Subscribe эксклюзивно подписывается на очередь. Канал закрывается при отмене ctx
+	// или потере соединения; неподтверждённые сообщения возвращаются в очередь.
+	Subscribe(ctx context.Context, queue string) (<-chan Delivery, error)
+}
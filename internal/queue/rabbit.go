@@ -1,7 +1,16 @@
 package queue
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewRabbitConnection(uri string) (*amqp.Connection, error) {
@@ -19,3 +28,148 @@ func DeclareQueue(ch *amqp.Channel, name string) error {
 	)
 	return err
 }
+
+// DeclarePartitions объявляет direct exchange и n очередей-партиций prefix.0 ... prefix.(n-1),
+// каждая из которых привязана к exchange по ключу с номером партиции.
+func DeclarePartitions(ch *amqp.Channel, exchange, prefix string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid partition count: %d", n)
+	}
+
+	err := ch.ExchangeDeclare(exchange, amqp.ExchangeDirect, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("declare exchange: %w", err)
+	}
+
+	for i := 0; i < n; i++ {
+		name := PartitionQueue(prefix, i)
+		if err := DeclareQueue(ch, name); err != nil {
+			return fmt.Errorf("declare queue %s: %w", name, err)
+		}
+		if err := ch.QueueBind(name, RoutingKey(i), exchange, false, nil); err != nil {
+			return fmt.Errorf("bind queue %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Partition возвращает номер партиции для устройства. Все пакеты одного устройства
+// всегда попадают в одну и ту же партицию.
+func Partition(deviceID, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strconv.Itoa(deviceID)))
+	return int(h.Sum32() % uint32(n))
+}
+
+func PartitionQueue(prefix string, partition int) string {
+	return prefix + "." + strconv.Itoa(partition)
+}
+
+func RoutingKey(partition int) string {
+	return strconv.Itoa(partition)
+}
+
+// RabbitPublisher публикует сообщения в exchange RabbitMQ
+type RabbitPublisher struct {
+	ch       *amqp.Channel
+	exchange string
+}
+
+func NewRabbitPublisher(ch *amqp.Channel, exchange string) *RabbitPublisher {
+	return &RabbitPublisher{ch: ch, exchange: exchange}
+}
+
+func (p *RabbitPublisher) Publish(ctx context.Context, routingKey string, body []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "rabbitmq publish", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", p.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	carrier := make(map[string]string)
+	tracing.Inject(ctx, propagation.MapCarrier(carrier))
+	headers := make(amqp.Table, len(carrier))
+	for k, v := range carrier {
+		headers[k] = v
+	}
+	return p.ch.PublishWithContext(ctx, p.exchange, routingKey, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         body,
+	})
+}
+
+// RabbitSubscriber открывает отдельный канал на каждую подписку, чтобы отписка
+// одной очереди (закрытие канала) возвращала в очередь только её сообщения
+type RabbitSubscriber struct {
+	conn     *amqp.Connection
+	prefetch int
+}
+
+func NewRabbitSubscriber(conn *amqp.Connection, prefetch int) *RabbitSubscriber {
+	return &RabbitSubscriber{conn: conn, prefetch: prefetch}
+}
+
+func (s *RabbitSubscriber) Subscribe(ctx context.Context, queue string) (<-chan Delivery, error) {
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("open channel: %w", err)
+	}
+	if err := ch.Qos(s.prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("set qos: %w", err)
+	}
+
+	msgs, err := ch.Consume(queue, "", false, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("consume: %w", err)
+	}
+
+	out := make(chan Delivery)
+	go func() {
+		defer close(out)
+		defer ch.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				d := Delivery{
+					Body:        msg.Body,
+					Redelivered: msg.Redelivered,
+					Headers:     stringHeaders(msg.Headers),
+					ack:         func() error { return msg.Ack(false) },
+					nack:        func(requeue bool) error { return msg.Nack(false, requeue) },
+				}
+				select {
+				case out <- d:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// stringHeaders оставляет строковые заголовки AMQP
+func stringHeaders(t amqp.Table) map[string]string {
+	if len(t) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(t))
+	for k, v := range t {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		}
+	}
+	return out
+}
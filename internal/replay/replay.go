@@ -0,0 +1,115 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+)
+
+// Options — параметры воспроизведения записи
+type Options struct {
+	// Speed — множитель скорости: 1 — исходный темп, N — в N раз быстрее, 0 — без пауз
+	Speed float64
+	// RewriteTime заменяет метки времени на текущие, сохраняя интервалы между пакетами.
+	// При Speed > 1 или 0 метки опережали бы часы, такие сочетания отклоняет конфигурация.
+	RewriteTime bool
+}
+
+// Stats — итог воспроизведения одной записи
+type Stats struct {
+	Sent    int
+	Failed  int
+	Skipped int
+}
+
+// Run воспроизводит пакеты из r в исходном порядке. Паузы между отправками считаются
+// от первого пакета записи, поэтому задержки отправки не накапливаются.
+// Пакеты с непарсящимся временем пропускаются; если время идёт назад, пакет
+// отправляется сразу.
+func Run(ctx context.Context, r packetio.Reader, s sender.PacketSender, opts Options) (Stats, error) {
+	var (
+		stats Stats
+		first time.Time // время первого пакета в записи
+		start time.Time // момент его отправки
+	)
+
+	for {
+		p, err := r.Read()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		t, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping packet with invalid timestamp",
+				"device_id", p.DeviceID, "timestamp", p.Timestamp, "err", err)
+			stats.Skipped++
+			continue
+		}
+
+		if first.IsZero() {
+			first, start = t, time.Now()
+		}
+		offset := t.Sub(first)
+
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(offset) / opts.Speed))
+			if err := sleepUntil(ctx, due); err != nil {
+				return stats, err
+			}
+		}
+		if opts.RewriteTime {
+			// интервалы сохраняются в масштабе исходной записи, а не ускоренного
+			// воспроизведения, чтобы правила движка видели те же промежутки
+			p.Timestamp = start.Add(offset).UTC().Format(time.RFC3339)
+		}
+
+		if err := s.Send(ctx, p); err != nil {
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			slog.ErrorContext(ctx, "send error", "device_id", p.DeviceID, "err", err)
+			stats.Failed++
+			continue
+		}
+		stats.Sent++
+	}
+}
+
+func sleepUntil(ctx context.Context, due time.Time) error {
+	d := time.Until(due)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RunFile открывает запись и воспроизводит её
+func RunFile(ctx context.Context, path, format string, m packetio.Mapping, s sender.PacketSender, opts Options) (Stats, error) {
+	r, closer, err := packetio.OpenFormat(path, format, m)
+	if err != nil {
+		return Stats{}, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer closer.Close()
+
+	stats, err := Run(ctx, r, s, opts)
+	if err != nil {
+		return stats, fmt.Errorf("replay %s: %w", path, err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,89 @@
+package replay
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/packetio"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+)
+
+const recording = `device_id,timestamp,pressure,temperature
+1,2025-03-01T10:00:00Z,0.05,20
+1,2025-03-01T10:00:02Z,0.051,20
+2,bad,0.05,20
+1,2025-03-01T10:00:04Z,0.052,21
+`
+
+func replayRecording(t *testing.T, opts Options) ([]packet.Packet, []time.Time, Stats) {
+	t.Helper()
+	r, err := packetio.NewCSVReader(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("csv reader: %v", err)
+	}
+
+	var (
+		sent []packet.Packet
+		at   []time.Time
+	)
+	s := sender.SenderFunc(func(_ context.Context, p packet.Packet) error {
+		sent = append(sent, p)
+		at = append(at, time.Now())
+		return nil
+	})
+
+	stats, err := Run(context.Background(), r, s, opts)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return sent, at, stats
+}
+
+func TestReplayAsFastAsPossible(t *testing.T) {
+	start := time.Now()
+	sent, _, stats := replayRecording(t, Options{Speed: 0})
+
+	if time.Since(start) > time.Second {
+		t.Errorf("replay with speed 0 took %s", time.Since(start))
+	}
+	if stats.Sent != 3 || stats.Skipped != 1 {
+		t.Errorf("stats = %+v, want 3 sent and 1 skipped", stats)
+	}
+	if sent[2].Timestamp != "2025-03-01T10:00:04Z" {
+		t.Errorf("timestamp changed without rewrite: %s", sent[2].Timestamp)
+	}
+}
+
+func TestReplayAccelerated(t *testing.T) {
+	// 4 секунды записи при x20 — около 200 мс
+	_, at, _ := replayRecording(t, Options{Speed: 20})
+
+	if d := at[2].Sub(at[0]); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("accelerated replay span = %s, want ~200ms", d)
+	}
+}
+
+func TestReplayRewriteTime(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	sent, _, _ := replayRecording(t, Options{Speed: 0, RewriteTime: true})
+
+	var times []time.Time
+	for _, p := range sent {
+		ts, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil {
+			t.Fatalf("rewritten timestamp: %v", err)
+		}
+		times = append(times, ts)
+	}
+	if times[0].Before(before.Truncate(time.Second)) {
+		t.Errorf("first timestamp %s not rewritten to now", times[0])
+	}
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d != 2*time.Second {
+			t.Errorf("interval %d = %s, want 2s", i, d)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package rollup
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxChunkSteps ограничивает число интервалов за один проход агрегации,
+// чтобы догоняющий расчёт после простоя не строил огромные группировки
+const maxChunkSteps = 1440
+
+var (
+	runsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rollup_runs_total",
+			Help: "Total number of rollup aggregation runs",
+		},
+		[]string{"resolution", "result"},
+	)
+
+	lagSeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rollup_lag_seconds",
+			Help: "Time between now and the end of the last aggregated bucket",
+		},
+		[]string{"resolution"},
+	)
+)
+
+// Roller пересчитывает агрегаты за период
+type Roller interface {
+	Rollup(ctx context.Context, r storage.Resolution, from, to time.Time) error
+}
+
+// State хранит, до какого момента агрегаты уже посчитаны
+type State interface {
+	Watermark(ctx context.Context, r storage.Resolution) (time.Time, bool, error)
+	SetWatermark(ctx context.Context, r storage.Resolution, until time.Time) error
+}
+
+// Job периодически досчитывает агрегаты по закрытым интервалам. Интервал считается
+// закрытым через lateness после его конца; пакеты, пришедшие позже, в агрегаты не попадут.
+type Job struct {
+	roller   Roller
+	state    State
+	interval time.Duration
+	lateness time.Duration
+	backfill time.Duration
+}
+
+// NewJob создаёт задачу. backfill — насколько назад считать агрегаты при первом запуске.
+func NewJob(roller Roller, state State, interval, lateness, backfill time.Duration) *Job {
+	return &Job{
+		roller:   roller,
+		state:    state,
+		interval: interval,
+		lateness: lateness,
+		backfill: backfill,
+	}
+}
+
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "rollup error", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce досчитывает агрегаты всех разрешений до момента now - lateness
+func (j *Job) RunOnce(ctx context.Context, now time.Time) error {
+	for _, r := range storage.Rollups {
+		if err := j.runResolution(ctx, r, now); err != nil {
+			runsTotal.WithLabelValues(r.Name, "error").Inc()
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Job) runResolution(ctx context.Context, r storage.Resolution, now time.Time) error {
+	from, ok, err := j.state.Watermark(ctx, r)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		from = now.Add(-j.backfill).Truncate(r.Step)
+	}
+	end := now.Add(-j.lateness).Truncate(r.Step)
+
+	for from.Before(end) {
+		to := from.Add(maxChunkSteps * r.Step)
+		if to.After(end) {
+			to = end
+		}
+		if err := j.roller.Rollup(ctx, r, from, to); err != nil {
+			return err
+		}
+		if err := j.state.SetWatermark(ctx, r, to); err != nil {
+			return err
+		}
+		runsTotal.WithLabelValues(r.Name, "ok").Inc()
+		slog.DebugContext(ctx, "rollup done", "resolution", r.Name, "from", from, "to", to)
+		from = to
+	}
+
+	lagSeconds.WithLabelValues(r.Name).Set(now.Sub(from).Seconds())
+	return nil
+}
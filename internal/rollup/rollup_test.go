@@ -0,0 +1,78 @@
+package rollup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+type call struct {
+	res      string
+	from, to time.Time
+}
+
+type fakeStore struct {
+	calls      []call
+	watermarks map[string]time.Time
+}
+
+func (f *fakeStore) Rollup(_ context.Context, r storage.Resolution, from, to time.Time) error {
+	f.calls = append(f.calls, call{r.Name, from, to})
+	return nil
+}
+
+func (f *fakeStore) Watermark(_ context.Context, r storage.Resolution) (time.Time, bool, error) {
+	t, ok := f.watermarks[r.Name]
+	return t, ok, nil
+}
+
+func (f *fakeStore) SetWatermark(_ context.Context, r storage.Resolution, until time.Time) error {
+	f.watermarks[r.Name] = until
+	return nil
+}
+
+func TestJobRunOnce(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStore{watermarks: make(map[string]time.Time)}
+	job := NewJob(store, store, time.Minute, 2*time.Minute, 48*time.Hour)
+
+	now := time.Date(2025, 3, 3, 12, 30, 30, 0, time.UTC)
+	if err := job.RunOnce(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1m: двое суток догоняются кусками не длиннее 1440 интервалов, последний интервал ещё открыт
+	wantEnd := time.Date(2025, 3, 3, 12, 28, 0, 0, time.UTC)
+	if got := store.watermarks["1m"]; !got.Equal(wantEnd) {
+		t.Errorf("1m watermark = %s, want %s", got, wantEnd)
+	}
+	var minutely []call
+	for _, c := range store.calls {
+		if c.res == "1m" {
+			minutely = append(minutely, c)
+		}
+	}
+	if len(minutely) != 2 || minutely[0].to.Sub(minutely[0].from) != 24*time.Hour {
+		t.Errorf("1m calls = %+v, want 2 chunks of at most a day", minutely)
+	}
+	for i := 1; i < len(minutely); i++ {
+		if !minutely[i].from.Equal(minutely[i-1].to) {
+			t.Errorf("gap between chunks %d and %d", i-1, i)
+		}
+	}
+
+	if got := store.watermarks["1d"]; !got.Equal(time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("1d watermark = %s", got)
+	}
+
+	// повторный запуск в том же интервале ничего не пересчитывает
+	store.calls = nil
+	if err := job.RunOnce(ctx, now.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.calls) != 0 {
+		t.Errorf("second run recomputed %+v", store.calls)
+	}
+}
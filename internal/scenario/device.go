@@ -0,0 +1,142 @@
+package scenario
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultPressure         = 0.05
+	defaultTemperature      = 24.0
+	defaultPressureNoise    = 0.002
+	defaultTemperatureNoise = 0.5
+)
+
+// Sample — показания устройства в момент времени. Send=false означает пропуск отправки.
+type Sample struct {
+	Pressure    float32
+	Temperature float32
+	Send        bool
+}
+
+// Device вычисляет показания одного устройства по сценарию.
+// Время отсчитывается от начала сценария, поэтому при одинаковом seed и периоде
+// последовательность показаний полностью воспроизводима.
+type Device struct {
+	ID     int
+	spec   DeviceSpec
+	base   Baseline
+	rng    *rand.Rand
+	fired  map[int]bool // одноразовые выбросы, которые уже сработали
+	last   Sample
+	frozen bool
+
+	// Signal, если задан, заменяет постоянный baseline: события и шум сценария
+	// накладываются на его показания
+	Signal func(elapsed time.Duration) (pressure, temperature float64)
+}
+
+func (s *Scenario) NewDevices() []*Device {
+	devices := make([]*Device, len(s.Devices))
+	for i, spec := range s.Devices {
+		devices[i] = &Device{
+			ID:    spec.ID,
+			spec:  spec,
+			base:  merge(spec.Baseline, s.Defaults),
+			rng:   rand.New(rand.NewPCG(s.Seed, uint64(spec.ID))),
+			fired: make(map[int]bool),
+		}
+	}
+	return devices
+}
+
+func merge(b, defaults Baseline) Baseline {
+	pick := func(v, d *float64, fallback float64) *float64 {
+		if v != nil {
+			return v
+		}
+		if d != nil {
+			return d
+		}
+		return &fallback
+	}
+	return Baseline{
+		Pressure:         pick(b.Pressure, defaults.Pressure, defaultPressure),
+		Temperature:      pick(b.Temperature, defaults.Temperature, defaultTemperature),
+		PressureNoise:    pick(b.PressureNoise, defaults.PressureNoise, defaultPressureNoise),
+		TemperatureNoise: pick(b.TemperatureNoise, defaults.TemperatureNoise, defaultTemperatureNoise),
+	}
+}
+
+// At возвращает показания на момент elapsed от начала сценария. Вызывается с неубывающим elapsed.
+func (d *Device) At(elapsed time.Duration) Sample {
+	pressure := *d.base.Pressure
+	temperature := *d.base.Temperature
+	if d.Signal != nil {
+		pressure, temperature = d.Signal(elapsed)
+	}
+	send, freeze := true, false
+
+	for i, e := range d.spec.Events {
+		if elapsed < e.At {
+			continue
+		}
+		since := elapsed - e.At
+		active := e.Duration == 0 || since < e.Duration
+
+		switch e.Type {
+		case EventStep:
+			if active {
+				apply(e.Metric, e.Delta, &pressure, &temperature)
+			}
+		case EventRamp:
+			progress := math.Min(1, float64(since)/float64(e.Duration))
+			apply(e.Metric, e.Delta*progress, &pressure, &temperature)
+		case EventLeak:
+			if active {
+				// значение экспоненциально стремится к target
+				decay := math.Exp(-float64(since) / float64(e.Tau))
+				if e.Metric == MetricTemperature {
+					temperature = e.Target + (temperature-e.Target)*decay
+				} else {
+					pressure = e.Target + (pressure-e.Target)*decay
+				}
+			}
+		case EventSpike:
+			// выброс без длительности приходится ровно на один отсчёт
+			if e.Duration > 0 && active || e.Duration == 0 && !d.fired[i] {
+				apply(e.Metric, e.Delta, &pressure, &temperature)
+				d.fired[i] = true
+			}
+		case EventFreeze:
+			freeze = freeze || active
+		case EventDropout:
+			send = send && !active
+		}
+	}
+
+	// шум генерируется на каждом шаге, чтобы последовательность rng не зависела от событий
+	pressure += d.rng.NormFloat64() * *d.base.PressureNoise
+	temperature += d.rng.NormFloat64() * *d.base.TemperatureNoise
+
+	sample := Sample{
+		Pressure:    float32(math.Max(0, pressure)),
+		Temperature: float32(math.Max(0, temperature)),
+		Send:        send,
+	}
+	if freeze && d.frozen {
+		sample.Pressure, sample.Temperature = d.last.Pressure, d.last.Temperature
+	}
+	d.frozen = freeze
+	d.last = sample
+	return sample
+}
+
+func apply(metric string, delta float64, pressure, temperature *float64) {
+	if metric == MetricTemperature {
+		*temperature += delta
+	} else {
+		*pressure += delta
+	}
+}
@@ -0,0 +1,125 @@
+package scenario
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EventStep    = "step"    // скачкообразное изменение на delta
+	EventRamp    = "ramp"    // линейное изменение на delta за duration
+	EventLeak    = "leak"    // экспоненциальный спад к target с постоянной tau (утечка)
+	EventFreeze  = "freeze"  // датчик залипает на последнем значении
+	EventDropout = "dropout" // устройство перестаёт отправлять пакеты
+	EventSpike   = "spike"   // кратковременный выброс на delta
+
+	MetricPressure    = "pressure"
+	MetricTemperature = "temperature"
+)
+
+// Scenario описывает воспроизводимый сценарий симуляции
+type Scenario struct {
+	Seed     uint64        `yaml:"seed"`
+	Duration time.Duration `yaml:"duration"` // 0 — без ограничения
+	Defaults Baseline      `yaml:"defaults"`
+	Devices  []DeviceSpec  `yaml:"devices"`
+}
+
+type Baseline struct {
+	Pressure         *float64 `yaml:"pressure"`
+	Temperature      *float64 `yaml:"temperature"`
+	PressureNoise    *float64 `yaml:"pressure_noise"`
+	TemperatureNoise *float64 `yaml:"temperature_noise"`
+}
+
+type DeviceSpec struct {
+	ID       int `yaml:"id"`
+	Baseline `yaml:",inline"`
+	Events   []Event `yaml:"events"`
+}
+
+type Event struct {
+	Type     string        `yaml:"type"`
+	At       time.Duration `yaml:"at"`
+	Duration time.Duration `yaml:"duration"`
+	Metric   string        `yaml:"metric"`
+	Delta    float64       `yaml:"delta"`
+	Target   float64       `yaml:"target"`
+	Tau      time.Duration `yaml:"tau"`
+}
+
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) Validate() error {
+	if len(s.Devices) == 0 {
+		return fmt.Errorf("no devices")
+	}
+	seen := make(map[int]bool)
+	for _, d := range s.Devices {
+		if d.ID <= 0 {
+			return fmt.Errorf("device id must be positive, got %d", d.ID)
+		}
+		if seen[d.ID] {
+			return fmt.Errorf("duplicate device %d", d.ID)
+		}
+		seen[d.ID] = true
+
+		for i, e := range d.Events {
+			if err := e.validate(); err != nil {
+				return fmt.Errorf("device %d event %d: %w", d.ID, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (e Event) validate() error {
+	if e.At < 0 || e.Duration < 0 {
+		return fmt.Errorf("negative offset or duration")
+	}
+	switch e.Type {
+	case EventStep, EventSpike:
+	case EventRamp:
+		if e.Duration == 0 {
+			return fmt.Errorf("ramp requires duration")
+		}
+	case EventLeak:
+		if e.Tau <= 0 {
+			return fmt.Errorf("leak requires positive tau")
+		}
+	case EventFreeze, EventDropout:
+		if e.Duration == 0 {
+			return fmt.Errorf("%s requires duration", e.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+
+	switch e.Metric {
+	case MetricPressure, MetricTemperature:
+		return nil
+	case "":
+		if e.Type == EventLeak {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown metric %q", e.Metric)
+}
@@ -0,0 +1,130 @@
+package scenario
+
+import (
+	"testing"
+	"time"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+func newDevice(t *testing.T, events ...Event) *Device {
+	t.Helper()
+	s := &Scenario{
+		Seed:     1,
+		Defaults: Baseline{Pressure: ptr(0.05), Temperature: ptr(20), PressureNoise: ptr(0), TemperatureNoise: ptr(0)},
+		Devices:  []DeviceSpec{{ID: 1, Events: events}},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	return s.NewDevices()[0]
+}
+
+func near(a float32, b float64) bool {
+	d := float64(a) - b
+	return d < 1e-6 && d > -1e-6
+}
+
+func TestEvents(t *testing.T) {
+	t.Run("step", func(t *testing.T) {
+		d := newDevice(t, Event{Type: EventStep, At: time.Minute, Duration: time.Minute, Metric: MetricPressure, Delta: 0.03})
+		for _, c := range []struct {
+			at   time.Duration
+			want float64
+		}{{0, 0.05}, {time.Minute, 0.08}, {90 * time.Second, 0.08}, {2 * time.Minute, 0.05}} {
+			if s := d.At(c.at); !near(s.Pressure, c.want) {
+				t.Errorf("pressure at %s = %v, want %v", c.at, s.Pressure, c.want)
+			}
+		}
+	})
+
+	t.Run("ramp", func(t *testing.T) {
+		d := newDevice(t, Event{Type: EventRamp, At: 0, Duration: 10 * time.Second, Metric: MetricTemperature, Delta: 10})
+		if s := d.At(5 * time.Second); !near(s.Temperature, 25) {
+			t.Errorf("temperature mid-ramp = %v, want 25", s.Temperature)
+		}
+		if s := d.At(time.Minute); !near(s.Temperature, 30) {
+			t.Errorf("temperature after ramp = %v, want 30", s.Temperature)
+		}
+	})
+
+	t.Run("leak", func(t *testing.T) {
+		d := newDevice(t, Event{Type: EventLeak, At: 0, Tau: time.Second, Target: 0})
+		if s := d.At(time.Hour); s.Pressure > 0.0001 {
+			t.Errorf("pressure after long leak = %v, want ~0", s.Pressure)
+		}
+	})
+
+	t.Run("spike once", func(t *testing.T) {
+		d := newDevice(t, Event{Type: EventSpike, At: 10 * time.Second, Metric: MetricPressure, Delta: 0.1})
+		d.At(5 * time.Second)
+		if s := d.At(12 * time.Second); !near(s.Pressure, 0.15) {
+			t.Errorf("spike sample = %v, want 0.15", s.Pressure)
+		}
+		if s := d.At(14 * time.Second); !near(s.Pressure, 0.05) {
+			t.Errorf("sample after spike = %v, want 0.05", s.Pressure)
+		}
+	})
+
+	t.Run("dropout and freeze", func(t *testing.T) {
+		d := newDevice(t,
+			Event{Type: EventDropout, At: time.Minute, Duration: time.Minute},
+			Event{Type: EventFreeze, At: 3 * time.Minute, Duration: time.Minute},
+			Event{Type: EventRamp, At: 3 * time.Minute, Duration: time.Minute, Metric: MetricPressure, Delta: 1},
+		)
+		if s := d.At(90 * time.Second); s.Send {
+			t.Error("sample sent during dropout")
+		}
+		first := d.At(3 * time.Minute)
+		if s := d.At(3*time.Minute + 30*time.Second); s.Pressure != first.Pressure {
+			t.Errorf("frozen pressure = %v, want %v", s.Pressure, first.Pressure)
+		}
+		if s := d.At(5 * time.Minute); !near(s.Pressure, 1.05) {
+			t.Errorf("pressure after freeze = %v, want 1.05", s.Pressure)
+		}
+	})
+}
+
+func TestSignal(t *testing.T) {
+	d := newDevice(t, Event{Type: EventStep, At: time.Minute, Metric: MetricPressure, Delta: 0.03})
+	d.Signal = func(elapsed time.Duration) (float64, float64) {
+		return 0.1 + elapsed.Minutes()*0.01, 30
+	}
+	if s := d.At(0); !near(s.Pressure, 0.1) || !near(s.Temperature, 30) {
+		t.Errorf("sample = %+v, want signal 0.1/30", s)
+	}
+	if s := d.At(2 * time.Minute); !near(s.Pressure, 0.15) {
+		t.Errorf("pressure with step = %v, want 0.15", s.Pressure)
+	}
+}
+
+func TestDeterministicSeed(t *testing.T) {
+	s := &Scenario{Seed: 7, Devices: []DeviceSpec{{ID: 1}, {ID: 2}}}
+	a, b := s.NewDevices(), s.NewDevices()
+
+	for i := 0; i < 100; i++ {
+		at := time.Duration(i) * time.Second
+		if a[0].At(at) != b[0].At(at) {
+			t.Fatalf("device 1 differs at %s for the same seed", at)
+		}
+		if a[1].At(at) != b[1].At(at) {
+			t.Fatalf("device 2 differs at %s for the same seed", at)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []Event{
+		{Type: "earthquake", Metric: MetricPressure},
+		{Type: EventRamp, Metric: MetricPressure},
+		{Type: EventStep, Metric: "humidity"},
+		{Type: EventLeak},
+		{Type: EventFreeze},
+	}
+	for _, e := range bad {
+		s := &Scenario{Devices: []DeviceSpec{{ID: 1, Events: []Event{e}}}}
+		if err := s.Validate(); err == nil {
+			t.Errorf("event %+v accepted, want error", e)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package sender
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	segmentExt   = ".seg"
+	cursorFile   = "cursor"
+	recordHeader = 8 // длина (uint32) + crc32 (uint32)
+)
+
+var ErrBufferEmpty = errors.New("buffer is empty")
+
+var (
+	bufferDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sender_buffer_depth",
+			Help: "Number of packets waiting in the on-disk buffer",
+		},
+	)
+
+	bufferBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sender_buffer_bytes",
+			Help: "Disk space used by the on-disk buffer",
+		},
+	)
+
+	bufferDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sender_buffer_dropped_total",
+			Help: "Total number of buffered packets dropped because the buffer exceeded its size limit",
+		},
+	)
+)
+
+type segment struct {
+	id      uint64
+	size    int64
+	records int
+}
+
+// Buffer — персистентная очередь пакетов на диске: сегментированный журнал только на дозапись.
+// Запись идёт в последний сегмент, чтение — с позиции курсора, которая сохраняется
+// после каждого подтверждения. Полностью прочитанные сегменты удаляются.
+// При превышении maxBytes удаляются самые старые сегменты (drop-oldest).
+type Buffer struct {
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+
+	mu       sync.Mutex
+	segments []*segment // от старых к новым, последний — активный
+	active   *os.File
+	reader   *os.File
+	readSeg  uint64
+	readOff  int64
+	depth    int
+	size     int64
+	pending  *packet.Packet // прочитанный, но ещё не подтверждённый пакет
+	nextOff  int64
+}
+
+func OpenBuffer(dir string, segmentBytes, maxBytes int64) (*Buffer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	b := &Buffer{dir: dir, segmentBytes: segmentBytes, maxBytes: maxBytes}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	if len(b.segments) == 0 {
+		if err := b.roll(); err != nil {
+			return nil, err
+		}
+	} else {
+		last := b.segments[len(b.segments)-1]
+		f, err := os.OpenFile(b.segmentPath(last.id), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		b.active = f
+	}
+
+	b.report()
+	slog.Info("sender buffer opened", "dir", dir, "depth", b.depth, "segments", len(b.segments))
+	return b, nil
+}
+
+func (b *Buffer) segmentPath(id uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// load восстанавливает состояние после перезапуска: список сегментов, курсор и глубину очереди
+func (b *Buffer) load() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		b.segments = append(b.segments, &segment{id: id})
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i].id < b.segments[j].id })
+
+	if data, err := os.ReadFile(filepath.Join(b.dir, cursorFile)); err == nil {
+		fmt.Sscanf(string(data), "%d %d", &b.readSeg, &b.readOff)
+	}
+
+	kept := b.segments[:0]
+	for _, s := range b.segments {
+		if s.id < b.readSeg {
+			os.Remove(b.segmentPath(s.id))
+			continue
+		}
+		from := int64(0)
+		if s.id == b.readSeg {
+			from = b.readOff
+		}
+		if err := b.scan(s, from); err != nil {
+			return err
+		}
+		kept = append(kept, s)
+	}
+	b.segments = kept
+
+	if len(b.segments) > 0 && b.segments[0].id != b.readSeg {
+		b.readSeg, b.readOff = b.segments[0].id, 0
+	}
+	return nil
+}
+
+// scan подсчитывает целые записи сегмента после from и обрезает оборванную запись в конце
+func (b *Buffer) scan(s *segment, from int64) error {
+	f, err := os.OpenFile(b.segmentPath(s.id), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var off int64
+	for {
+		n, err := readRecord(r, nil)
+		if err != nil {
+			if err != io.EOF {
+				slog.Warn("truncating damaged buffer segment", "segment", s.id, "offset", off, "err", err)
+				if err := f.Truncate(off); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if off >= from {
+			s.records++
+			b.depth++
+		}
+		off += n
+	}
+	s.size = off
+	b.size += off
+	return nil
+}
+
+func readRecord(r io.Reader, dst *packet.Packet) (int64, error) {
+	var header [recordHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("partial record header")
+		}
+		return 0, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	sum := binary.BigEndian.Uint32(header[4:])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, fmt.Errorf("partial record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return 0, fmt.Errorf("record checksum mismatch")
+	}
+	if dst != nil {
+		if err := json.Unmarshal(payload, dst); err != nil {
+			return 0, err
+		}
+	}
+	return int64(recordHeader) + int64(length), nil
+}
+
+func (b *Buffer) roll() error {
+	var id uint64 = 1
+	if len(b.segments) > 0 {
+		id = b.segments[len(b.segments)-1].id + 1
+	}
+	f, err := os.OpenFile(b.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if b.active != nil {
+		b.active.Close()
+	}
+	b.active = f
+	b.segments = append(b.segments, &segment{id: id})
+	if len(b.segments) == 1 {
+		b.readSeg, b.readOff = id, 0
+	}
+	return nil
+}
+
+// Append дописывает пакет в конец очереди и синхронизирует файл на диск
+func (b *Buffer) Append(p packet.Packet) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeader:], payload)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	last := b.segments[len(b.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > b.segmentBytes {
+		if err := b.roll(); err != nil {
+			return err
+		}
+		last = b.segments[len(b.segments)-1]
+	}
+
+	if _, err := b.active.Write(record); err != nil {
+		return err
+	}
+	if err := b.active.Sync(); err != nil {
+		return err
+	}
+	last.size += int64(len(record))
+	last.records++
+	b.size += int64(len(record))
+	b.depth++
+
+	b.enforceLimit()
+	b.report()
+	return nil
+}
+
+// enforceLimit удаляет самые старые сегменты, пока буфер не уложится в maxBytes.
+// Активный сегмент не удаляется никогда.
+func (b *Buffer) enforceLimit() {
+	for b.size > b.maxBytes && len(b.segments) > 1 {
+		oldest := b.segments[0]
+		dropped := oldest.records
+		if oldest.id == b.readSeg && b.pending != nil {
+			b.pending = nil
+		}
+		if b.reader != nil && b.readSeg == oldest.id {
+			b.reader.Close()
+			b.reader = nil
+		}
+		os.Remove(b.segmentPath(oldest.id))
+
+		b.segments = b.segments[1:]
+		b.size -= oldest.size
+		b.depth -= dropped
+		b.readSeg, b.readOff = b.segments[0].id, 0
+		b.saveCursor()
+
+		bufferDropped.Add(float64(dropped))
+		slog.Warn("sender buffer full, dropped oldest segment", "segment", oldest.id, "packets", dropped)
+	}
+}
+
+// Peek возвращает самый старый неподтверждённый пакет, не удаляя его из очереди
+func (b *Buffer) Peek() (packet.Packet, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending != nil {
+		return *b.pending, nil
+	}
+	if b.depth == 0 {
+		return packet.Packet{}, ErrBufferEmpty
+	}
+
+	for {
+		if b.reader == nil {
+			f, err := os.Open(b.segmentPath(b.readSeg))
+			if err != nil {
+				return packet.Packet{}, err
+			}
+			if _, err := f.Seek(b.readOff, io.SeekStart); err != nil {
+				f.Close()
+				return packet.Packet{}, err
+			}
+			b.reader = f
+		}
+
+		var p packet.Packet
+		n, err := readRecord(b.reader, &p)
+		if err == io.EOF {
+			// сегмент дочитан — переходим к следующему
+			if !b.advanceSegment() {
+				return packet.Packet{}, ErrBufferEmpty
+			}
+			continue
+		}
+		if err != nil {
+			return packet.Packet{}, err
+		}
+		b.pending = &p
+		b.nextOff = b.readOff + n
+		return p, nil
+	}
+}
+
+func (b *Buffer) advanceSegment() bool {
+	if len(b.segments) < 2 || b.segments[0].id != b.readSeg {
+		return false
+	}
+	b.reader.Close()
+	b.reader = nil
+
+	done := b.segments[0]
+	os.Remove(b.segmentPath(done.id))
+	b.segments = b.segments[1:]
+	b.size -= done.size
+
+	b.readSeg, b.readOff = b.segments[0].id, 0
+	b.saveCursor()
+	b.report()
+	return true
+}
+
+// Ack подтверждает отправку пакета, полученного через Peek
+func (b *Buffer) Ack() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending == nil {
+		return nil
+	}
+	b.pending = nil
+	b.readOff = b.nextOff
+	b.depth--
+	b.segments[0].records--
+	b.report()
+	return b.saveCursor()
+}
+
+func (b *Buffer) saveCursor() error {
+	tmp := filepath.Join(b.dir, cursorFile+".tmp")
+	data := fmt.Sprintf("%d %d", b.readSeg, b.readOff)
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(b.dir, cursorFile))
+}
+
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.depth
+}
+
+func (b *Buffer) report() {
+	bufferDepth.Set(float64(b.depth))
+	bufferBytes.Set(float64(b.size))
+}
+
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.reader != nil {
+		b.reader.Close()
+	}
+	return b.active.Close()
+}
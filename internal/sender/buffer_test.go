@@ -0,0 +1,211 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+func pkt(deviceID int) packet.Packet {
+	return packet.Packet{DeviceID: deviceID, Timestamp: "2025-01-01T00:00:00Z", Pressure: 0.05, Temperature: 20}
+}
+
+func drainBuffer(t *testing.T, b *Buffer) []int {
+	t.Helper()
+	var ids []int
+	for {
+		p, err := b.Peek()
+		if err == ErrBufferEmpty {
+			return ids
+		}
+		if err != nil {
+			t.Fatalf("peek: %v", err)
+		}
+		ids = append(ids, p.DeviceID)
+		if err := b.Ack(); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+	}
+}
+
+func TestBufferOrderAcrossSegmentsAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	b, err := OpenBuffer(dir, 200, 1<<20)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 1; i <= 10; i++ {
+		if err := b.Append(pkt(i)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	// читаем часть и перезапускаемся: подтверждённые пакеты не должны вернуться
+	for i := 1; i <= 3; i++ {
+		p, err := b.Peek()
+		if err != nil || p.DeviceID != i {
+			t.Fatalf("peek = %v, %v; want device %d", p.DeviceID, err, i)
+		}
+		b.Ack()
+	}
+	b.Peek() // прочитан, но не подтверждён
+	b.Close()
+
+	b, err = OpenBuffer(dir, 200, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer b.Close()
+
+	if n := b.Len(); n != 7 {
+		t.Errorf("depth after restart = %d, want 7", n)
+	}
+	got := drainBuffer(t, b)
+	want := []int{4, 5, 6, 7, 8, 9, 10}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestBufferDropsOldest(t *testing.T) {
+	b, err := OpenBuffer(t.TempDir(), 200, 500)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer b.Close()
+
+	for i := 1; i <= 20; i++ {
+		if err := b.Append(pkt(i)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	got := drainBuffer(t, b)
+	if len(got) == 0 || len(got) >= 20 {
+		t.Fatalf("got %d packets, want oldest ones dropped", len(got))
+	}
+	if got[len(got)-1] != 20 {
+		t.Errorf("newest packet = %d, want 20", got[len(got)-1])
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] != got[i-1]+1 {
+			t.Fatalf("packets out of order: %v", got)
+		}
+	}
+}
+
+type flakySender struct {
+	mu   sync.Mutex
+	down bool
+	sent []int
+}
+
+func (s *flakySender) Send(_ context.Context, p packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("connection refused")
+	}
+	s.sent = append(s.sent, p.DeviceID)
+	return nil
+}
+
+func TestForwarderReplaysInOrder(t *testing.T) {
+	b, err := OpenBuffer(t.TempDir(), 1<<20, 1<<30)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer b.Close()
+
+	next := &flakySender{down: true}
+	f := NewForwarder(next, b, time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	for i := 1; i <= 5; i++ {
+		if err := f.Send(ctx, pkt(i)); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	next.mu.Lock()
+	next.down = false
+	next.mu.Unlock()
+
+	// пока буфер не опустел, новые пакеты встают в очередь за старыми
+	f.Send(ctx, pkt(6))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	next.mu.Lock()
+	defer next.mu.Unlock()
+	if len(next.sent) != 6 {
+		t.Fatalf("sent %v, want 6 packets", next.sent)
+	}
+	for i, id := range next.sent {
+		if id != i+1 {
+			t.Fatalf("sent %v, want in order", next.sent)
+		}
+	}
+}
+
+func TestForwarderDropsRejected(t *testing.T) {
+	b, err := OpenBuffer(t.TempDir(), 1<<20, 1<<30)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer b.Close()
+
+	next := &flakySender{down: true}
+	// пакет устройства 1 контроллер отвергает как некорректный
+	reject := SenderFunc(func(ctx context.Context, p packet.Packet) error {
+		if p.DeviceID == 1 {
+			return &StatusError{Code: http.StatusBadRequest}
+		}
+		return next.Send(ctx, p)
+	})
+	f := NewForwarder(reject, b, time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var se *StatusError
+	if err := f.Send(ctx, pkt(1)); !errors.As(err, &se) || b.Len() != 0 {
+		t.Fatalf("send rejected = %v, buffer len %d; want StatusError and nothing buffered", err, b.Len())
+	}
+
+	// отвергнутый пакет в голове буфера не задерживает следующие
+	b.Append(pkt(1))
+	f.Send(ctx, pkt(2))
+	f.Send(ctx, pkt(3))
+	next.mu.Lock()
+	next.down = false
+	next.mu.Unlock()
+	go f.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	next.mu.Lock()
+	defer next.mu.Unlock()
+	if b.Len() != 0 || len(next.sent) != 2 || next.sent[0] != 2 || next.sent[1] != 3 {
+		t.Fatalf("sent %v, buffer len %d; want [2 3] and empty buffer", next.sent, b.Len())
+	}
+}
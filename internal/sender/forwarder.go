@@ -0,0 +1,131 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var rejectedTotal = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "sender_rejected_total",
+		Help: "Total number of packets dropped because the IoT controller rejected them with a non-retryable status",
+	},
+)
+
+// PacketSender отправляет пакет в IoT контроллер
+type PacketSender interface {
+	Send(ctx context.Context, p packet.Packet) error
+}
+
+// SenderFunc позволяет использовать обычную функцию как PacketSender
+type SenderFunc func(ctx context.Context, p packet.Packet) error
+
+func (f SenderFunc) Send(ctx context.Context, p packet.Packet) error {
+	return f(ctx, p)
+}
+
+// Forwarder реализует store-and-forward: пакеты, которые не удалось отправить,
+// складываются в дисковый буфер и досылаются по порядку, когда контроллер снова доступен.
+// Пока буфер не пуст, новые пакеты тоже идут в буфер, чтобы не нарушать порядок.
+// Пакеты, отвергнутые контроллером с неповторяемым кодом (4xx, кроме 429), не
+// буферизуются: повтор их не исправит, а застрявший пакет остановил бы весь буфер.
+type Forwarder struct {
+	next       PacketSender
+	buf        *Buffer
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	wake       chan struct{}
+}
+
+func NewForwarder(next PacketSender, buf *Buffer, minBackoff, maxBackoff time.Duration) *Forwarder {
+	return &Forwarder{
+		next:       next,
+		buf:        buf,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Send возвращает ошибку, если контроллер отверг пакет или пакет не удалось ни
+// отправить, ни сохранить в буфер
+func (f *Forwarder) Send(ctx context.Context, p packet.Packet) error {
+	if f.buf.Len() == 0 {
+		err := f.next.Send(ctx, p)
+		if err == nil {
+			return nil
+		}
+		if Rejected(err) {
+			rejectedTotal.Inc()
+			return err
+		}
+		slog.WarnContext(ctx, "send failed, buffering packet", "device_id", p.DeviceID, "err", err)
+	}
+
+	if err := f.buf.Append(p); err != nil {
+		return err
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run досылает буферизованные пакеты с экспоненциальной задержкой между неудачными попытками
+func (f *Forwarder) Run(ctx context.Context) {
+	backoff := f.minBackoff
+
+	for {
+		p, err := f.buf.Peek()
+		if err == ErrBufferEmpty {
+			select {
+			case <-ctx.Done():
+				return
+			case <-f.wake:
+				continue
+			}
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "read buffer error", "err", err)
+		} else if err = f.next.Send(ctx, p); err == nil {
+			if err := f.buf.Ack(); err != nil {
+				slog.ErrorContext(ctx, "buffer ack error", "err", err)
+			}
+			if backoff != f.minBackoff {
+				slog.InfoContext(ctx, "controller reachable again, replaying buffer", "depth", f.buf.Len())
+			}
+			backoff = f.minBackoff
+			continue
+		} else if Rejected(err) {
+			rejectedTotal.Inc()
+			slog.WarnContext(ctx, "buffered packet rejected by controller, dropping", "device_id", p.DeviceID, "err", err)
+			if err := f.buf.Ack(); err != nil {
+				slog.ErrorContext(ctx, "buffer ack error", "err", err)
+			}
+			continue
+		}
+
+		// джиттер, чтобы симуляторы не ломились в контроллер одновременно после восстановления
+		delay := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, f.maxBackoff)
+	}
+}
+
+// Rejected сообщает, что контроллер отверг пакет и повтор бесполезен
+func Rejected(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && !se.retryable()
+}
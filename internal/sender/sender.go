@@ -2,42 +2,195 @@ package sender
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-const (
-	contentType = "application/json"
-	timeout     = 5 * time.Second
+const contentType = "application/json"
+
+var (
+	requestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sender_requests_total",
+			Help: "Total number of HTTP requests sent to the IoT controller",
+		},
+		[]string{"result"},
+	)
+
+	requestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sender_request_duration_seconds",
+			Help:    "Duration of HTTP requests sent to the IoT controller",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+
+	retriesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sender_retries_total",
+			Help: "Total number of retried HTTP requests",
+		},
+	)
 )
 
+// StatusError — ответ контроллера с кодом вне 2xx
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+func (e *StatusError) retryable() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+type ClientConfig struct {
+	URL          string
+	Timeout      time.Duration
+	MaxRetries   int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	Gzip         bool
+	MaxIdleConns int
+}
+
+// Client отправляет пакеты в IoT контроллер через общий пул соединений.
+// Сетевые ошибки, 5xx и 429 повторяются с экспоненциальной задержкой и джиттером;
+// заголовок Retry-After имеет приоритет над расчётной задержкой.
+type Client struct {
+	cfg  ClientConfig
+	http *http.Client
+}
+
+func NewClient(cfg ClientConfig) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+
+	return &Client{
+		cfg: cfg,
+		http: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+		},
+	}
+}
+
 // Send отправляет один пакет в IoT контроллер по HTTP POST
-func Send(url string, p packet.Packet) error {
+func (c *Client) Send(ctx context.Context, p packet.Packet) error {
 	body, err := json.Marshal(p)
 	if err != nil {
 		return fmt.Errorf("marshal packet: %w", err)
 	}
+	return c.SendRaw(ctx, body)
+}
+
+// SendRaw отправляет произвольное тело запроса как есть
+func (c *Client) SendRaw(ctx context.Context, body []byte) error {
+	if c.cfg.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("gzip body: %w", err)
+		}
+		body = buf.Bytes()
+	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	backoff := c.cfg.MinBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, body)
+		if err == nil || attempt >= c.cfg.MaxRetries || !retryable(ctx, err) {
+			return err
+		}
+
+		delay := backoff/2 + rand.N(backoff/2+1)
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			delay = se.RetryAfter
+		}
+		backoff = min(backoff*2, c.cfg.MaxBackoff)
+
+		retriesTotal.Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", contentType)
+	if c.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := c.http.Do(req)
 	if err != nil {
+		observe("error", start)
 		return fmt.Errorf("send request: %w", err)
 	}
-	defer resp.Body.Close()
+	// тело дочитывается, чтобы соединение вернулось в пул
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	observe(strconv.Itoa(resp.StatusCode/100)+"xx", start)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &StatusError{Code: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 	}
-
 	return nil
 }
+
+func observe(result string, start time.Time) {
+	requestsTotal.WithLabelValues(result).Inc()
+	requestDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.retryable()
+	}
+	return true
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package sender
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+func testClient(url string) *Client {
+	return NewClient(ClientConfig{
+		URL:          url,
+		Timeout:      time.Second,
+		MaxRetries:   3,
+		MinBackoff:   time.Millisecond,
+		MaxBackoff:   5 * time.Millisecond,
+		MaxIdleConns: 2,
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  int // ожидаемый код ошибки, 0 — успех
+		wantHits int32
+	}{
+		{"success", []int{202}, 0, 1},
+		{"retry 5xx", []int{503, 502, 202}, 0, 3},
+		{"retry 429", []int{429, 202}, 0, 2},
+		{"no retry 4xx", []int{400, 202}, 400, 1},
+		{"give up", []int{500, 500, 500, 500, 202}, 500, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := hits.Add(1)
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			err := testClient(srv.URL).Send(context.Background(), pkt(1))
+
+			var se *StatusError
+			switch {
+			case tt.wantErr == 0 && err != nil:
+				t.Errorf("send: %v", err)
+			case tt.wantErr != 0 && (!errors.As(err, &se) || se.Code != tt.wantErr):
+				t.Errorf("send error = %v, want status %d", err, tt.wantErr)
+			}
+			if hits.Load() != tt.wantHits {
+				t.Errorf("requests = %d, want %d", hits.Load(), tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestClientHonorsRetryAfter(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	if err := testClient(srv.URL).Send(context.Background(), pkt(1)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least Retry-After 1s", elapsed)
+	}
+}
+
+func TestClientGzip(t *testing.T) {
+	got := make(chan packet.Packet, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip reader: %v", err)
+			return
+		}
+		var p packet.Packet
+		json.NewDecoder(zr).Decode(&p)
+		got <- p
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c := testClient(srv.URL)
+	c.cfg.Gzip = true
+	if err := c.Send(context.Background(), pkt(42)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if p := <-got; p.DeviceID != 42 {
+		t.Errorf("received device %d, want 42", p.DeviceID)
+	}
+}
@@ -0,0 +1,63 @@
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+)
+
+const minPeriod = 100 * time.Millisecond
+
+// Control — состояние устройства, которым платформа управляет командами
+type Control struct {
+	mu          sync.Mutex
+	period      time.Duration
+	valveClosed bool
+	recalibrate bool // сбросить дрейф на следующем отсчёте
+}
+
+func NewControl(period time.Duration) *Control {
+	return &Control{period: period}
+}
+
+// Execute применяет команду платформы, подходит как downlink.Executor
+func (c *Control) Execute(_ context.Context, cmd command.Command) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch cmd.Type {
+	case command.SetPeriod:
+		period := time.Duration(cmd.Params["period"] * float64(time.Second))
+		if period < minPeriod {
+			return fmt.Errorf("period %s is below minimum %s", period, minPeriod)
+		}
+		c.period = period
+	case command.CloseValve:
+		c.valveClosed = true
+	case command.OpenValve:
+		c.valveClosed = false
+	case command.Recalibrate:
+		c.recalibrate = true
+	default:
+		return fmt.Errorf("unsupported command type %q", cmd.Type)
+	}
+	return nil
+}
+
+type controlState struct {
+	period      time.Duration
+	valveClosed bool
+	recalibrate bool
+}
+
+// take возвращает текущее состояние; запрос на калибровку выдаётся один раз
+func (c *Control) take() controlState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := controlState{period: c.period, valveClosed: c.valveClosed, recalibrate: c.recalibrate}
+	c.recalibrate = false
+	return st
+}
@@ -0,0 +1,36 @@
+package sensor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+)
+
+func TestControlExecute(t *testing.T) {
+	ctx := context.Background()
+	c := NewControl(time.Second)
+
+	if err := c.Execute(ctx, command.Command{Type: command.SetPeriod, Params: map[string]float64{"period": 0.5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Execute(ctx, command.Command{Type: command.SetPeriod, Params: map[string]float64{"period": 0.001}}); err == nil {
+		t.Error("period below minimum accepted")
+	}
+	c.Execute(ctx, command.Command{Type: command.CloseValve})
+	c.Execute(ctx, command.Command{Type: command.Recalibrate})
+
+	st := c.take()
+	if st.period != 500*time.Millisecond || !st.valveClosed || !st.recalibrate {
+		t.Errorf("state = %+v, want period 500ms, valve closed, recalibrate", st)
+	}
+	if c.take().recalibrate {
+		t.Error("recalibrate request returned twice")
+	}
+
+	c.Execute(ctx, command.Command{Type: command.OpenValve})
+	if c.take().valveClosed {
+		t.Error("valve still closed after open_valve")
+	}
+}
@@ -0,0 +1,327 @@
+package sensor
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/data_simulator"
+)
+
+const kelvin = 273.15
+
+// Reading — показания датчика до упаковки в пакет
+type Reading struct {
+	Pressure    float64
+	Temperature float64
+}
+
+// Stage — звено модели сигнала: получает показания от предыдущего звена и
+// возвращает изменённые. Звенья могут хранить состояние (инерция, дрейф),
+// поэтому для каждого устройства создаются свои экземпляры.
+type Stage interface {
+	Apply(t time.Time, r Reading) Reading
+}
+
+// Params — числовые параметры звена из конфига
+type Params map[string]float64
+
+func (p Params) get(name string, def float64) float64 {
+	if v, ok := p[name]; ok {
+		return v
+	}
+	return def
+}
+
+// StageFactory создаёт звено по параметрам. rng — генератор устройства.
+type StageFactory func(p Params, rng *rand.Rand) (Stage, error)
+
+var stageFactories = map[string]StageFactory{
+	"constant":    newConstant,
+	"consumption": newConsumption,
+	"ambient":     newAmbient,
+	"coupling":    newCoupling,
+	"noise":       newNoise,
+	"quantize":    newQuantize,
+}
+
+// RegisterStage добавляет новый тип звена, доступный в конфиге по имени
+func RegisterStage(name string, f StageFactory) {
+	stageFactories[name] = f
+}
+
+// Model — цепочка звеньев, применяемых по порядку. Время модели может идти быстрее
+// реального (timeScale), чтобы суточные циклы были видны за минуты.
+type Model struct {
+	stages    []Stage
+	start     time.Time
+	timeScale float64
+}
+
+func NewModel(specs []config.ModelStage, timeScale float64, rng *rand.Rand) (*Model, error) {
+	m := &Model{start: time.Now(), timeScale: timeScale}
+	for i, spec := range specs {
+		factory, ok := stageFactories[spec.Type]
+		if !ok {
+			return nil, fmt.Errorf("stage %d: unknown type %q, known: %v", i, spec.Type, StageTypes())
+		}
+		st, err := factory(Params(spec.Params), rng)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d (%s): %w", i, spec.Type, err)
+		}
+		m.stages = append(m.stages, st)
+	}
+	return m, nil
+}
+
+// ModelFor строит модель сигнала устройства по конфигу. Возвращает nil, если
+// устройству не назначен профиль — тогда используется генератор по умолчанию.
+func ModelFor(cfg *config.Config, deviceID int) (*Model, error) {
+	name, ok := cfg.Models.Devices[deviceID]
+	if !ok {
+		name = cfg.Models.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+	specs, ok := cfg.Models.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("device %d: unknown model profile %q", deviceID, name)
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	rng := rand.New(rand.NewPCG(seed, uint64(deviceID)))
+
+	m, err := NewModel(specs, cfg.Models.TimeScale, rng)
+	if err != nil {
+		return nil, fmt.Errorf("device %d: model profile %q: %w", deviceID, name, err)
+	}
+	return m, nil
+}
+
+func (m *Model) modelTime(now time.Time) time.Time {
+	return m.start.Add(time.Duration(float64(now.Sub(m.start)) * m.timeScale))
+}
+
+// Sample возвращает показания в момент now
+func (m *Model) Sample(now time.Time) Reading {
+	t := m.modelTime(now)
+	var r Reading
+	for _, st := range m.stages {
+		r = st.Apply(t, r)
+	}
+	return r
+}
+
+// recalibrator — звено, состояние которого сбрасывается калибровкой датчика
+type recalibrator interface {
+	recalibrate(t time.Time)
+}
+
+// Recalibrate сбрасывает накопленный дрейф датчиков
+func (m *Model) Recalibrate(now time.Time) {
+	t := m.modelTime(now)
+	for _, st := range m.stages {
+		if r, ok := st.(recalibrator); ok {
+			r.recalibrate(t)
+		}
+	}
+}
+
+// hourOfDay возвращает час суток в дробном виде
+func hourOfDay(t time.Time) float64 {
+	h, m, s := t.Clock()
+	return float64(h) + float64(m)/60 + float64(s)/3600
+}
+
+// cyclic возвращает расстояние между часами суток с учётом перехода через полночь
+func cyclic(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 24)
+	return min(d, 24-d)
+}
+
+type constant struct {
+	pressure, temperature float64
+}
+
+// newConstant задаёт исходные значения цепочки
+func newConstant(p Params, _ *rand.Rand) (Stage, error) {
+	return constant{pressure: p.get("pressure", 0.05), temperature: p.get("temperature", 15)}, nil
+}
+
+func (c constant) Apply(_ time.Time, _ Reading) Reading {
+	return Reading{Pressure: c.pressure, Temperature: c.temperature}
+}
+
+// consumption — давление в сети падает при росте потребления газа: утренний и
+// вечерний пики, ночью давление близко к номинальному
+type consumption struct {
+	mean, amplitude     float64
+	morning, evening, w float64
+}
+
+func newConsumption(p Params, _ *rand.Rand) (Stage, error) {
+	c := consumption{
+		mean:      p.get("mean", 0.05),
+		amplitude: p.get("amplitude", 0.005),
+		morning:   p.get("morning_peak", 7.5),
+		evening:   p.get("evening_peak", 19),
+		w:         p.get("width", 2),
+	}
+	if c.w <= 0 {
+		return nil, fmt.Errorf("width must be positive")
+	}
+	return c, nil
+}
+
+func (c consumption) Apply(t time.Time, r Reading) Reading {
+	h := hourOfDay(t)
+	peak := func(at float64) float64 {
+		d := cyclic(h, at) / c.w
+		return math.Exp(-d * d / 2)
+	}
+	load := min(1, peak(c.morning)+peak(c.evening))
+	r.Pressure = c.mean - c.amplitude*load
+	return r
+}
+
+// ambient — температура газа следует за суточной кривой температуры воздуха
+// с инерцией первого порядка (постоянная времени lag_hours)
+type ambient struct {
+	mean, amplitude, peak float64
+	tau                   time.Duration
+	last                  time.Time
+	value                 float64
+}
+
+func newAmbient(p Params, _ *rand.Rand) (Stage, error) {
+	a := &ambient{
+		mean:      p.get("mean", 15),
+		amplitude: p.get("amplitude", 7),
+		peak:      p.get("peak_hour", 15),
+		tau:       time.Duration(p.get("lag_hours", 1) * float64(time.Hour)),
+	}
+	if a.tau < 0 {
+		return nil, fmt.Errorf("lag_hours must not be negative")
+	}
+	return a, nil
+}
+
+func (a *ambient) air(t time.Time) float64 {
+	return a.mean + a.amplitude*math.Cos(2*math.Pi*(hourOfDay(t)-a.peak)/24)
+}
+
+func (a *ambient) Apply(t time.Time, r Reading) Reading {
+	target := a.air(t)
+	switch {
+	case a.last.IsZero() || a.tau == 0:
+		a.value = target
+	case t.After(a.last):
+		k := 1 - math.Exp(-float64(t.Sub(a.last))/float64(a.tau))
+		a.value += (target - a.value) * k
+	}
+	a.last = t
+	r.Temperature = a.value
+	return r
+}
+
+// coupling связывает давление с температурой по закону Гей-Люссака:
+// p ~ T (в кельвинах) относительно опорной температуры; factor ослабляет связь
+type coupling struct {
+	factor, reference float64
+}
+
+func newCoupling(p Params, _ *rand.Rand) (Stage, error) {
+	return coupling{factor: p.get("factor", 1), reference: p.get("reference", 15)}, nil
+}
+
+func (c coupling) Apply(_ time.Time, r Reading) Reading {
+	ratio := (r.Temperature + kelvin) / (c.reference + kelvin)
+	r.Pressure *= 1 + c.factor*(ratio-1)
+	return r
+}
+
+// noise — шум датчика и дрейф нуля: линейный (в сутки) и случайное блуждание
+type noise struct {
+	rng                    *rand.Rand
+	pSigma, tSigma         float64
+	pDrift, tDrift         float64 // в сутки
+	pWalk, tWalk           float64 // в корень из суток
+	start, last            time.Time
+	pWalkValue, tWalkValue float64
+}
+
+func newNoise(p Params, rng *rand.Rand) (Stage, error) {
+	return &noise{
+		rng:    rng,
+		pSigma: p.get("pressure", 0.0005),
+		tSigma: p.get("temperature", 0.2),
+		pDrift: p.get("pressure_drift", 0),
+		tDrift: p.get("temperature_drift", 0),
+		pWalk:  p.get("pressure_walk", 0),
+		tWalk:  p.get("temperature_walk", 0),
+	}, nil
+}
+
+func (n *noise) recalibrate(t time.Time) {
+	n.start, n.last = t, t
+	n.pWalkValue, n.tWalkValue = 0, 0
+}
+
+func (n *noise) Apply(t time.Time, r Reading) Reading {
+	if n.start.IsZero() {
+		n.start, n.last = t, t
+	}
+	days := t.Sub(n.start).Hours() / 24
+	if dt := t.Sub(n.last).Hours() / 24; dt > 0 {
+		n.pWalkValue += n.rng.NormFloat64() * n.pWalk * math.Sqrt(dt)
+		n.tWalkValue += n.rng.NormFloat64() * n.tWalk * math.Sqrt(dt)
+	}
+	n.last = t
+
+	r.Pressure += n.rng.NormFloat64()*n.pSigma + n.pDrift*days + n.pWalkValue
+	r.Temperature += n.rng.NormFloat64()*n.tSigma + n.tDrift*days + n.tWalkValue
+	return r
+}
+
+// quantize округляет показания до разрешения АЦП датчика. Давление не уходит
+// ниже нуля — датчик избыточного давления отрицательных значений не выдаёт.
+type quantize struct {
+	pStep, tStep float64
+}
+
+func newQuantize(p Params, _ *rand.Rand) (Stage, error) {
+	q := quantize{pStep: p.get("pressure", 0.001), tStep: p.get("temperature", 0.1)}
+	if q.pStep < 0 || q.tStep < 0 {
+		return nil, fmt.Errorf("steps must not be negative")
+	}
+	return q, nil
+}
+
+func round(v, step float64) float64 {
+	if step == 0 {
+		return v
+	}
+	return math.Round(v/step) * step
+}
+
+func (q quantize) Apply(_ time.Time, r Reading) Reading {
+	r.Pressure = max(0, round(r.Pressure, q.pStep))
+	r.Temperature = round(r.Temperature, q.tStep)
+	return r
+}
+
+// StageTypes возвращает имена зарегистрированных типов звеньев
+func StageTypes() []string {
+	names := make([]string, 0, len(stageFactories))
+	for name := range stageFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
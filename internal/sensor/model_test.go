@@ -0,0 +1,96 @@
+package sensor
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	config "github.com/pochkachaiki/iot4gds/internal/config/data_simulator"
+)
+
+func day(h float64) time.Time {
+	return time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(h * float64(time.Hour)))
+}
+
+func newTestModel(t *testing.T, seed uint64, specs ...config.ModelStage) *Model {
+	t.Helper()
+	m, err := NewModel(specs, 1, rand.New(rand.NewPCG(seed, 1)))
+	if err != nil {
+		t.Fatalf("new model: %v", err)
+	}
+	m.start = day(0)
+	return m
+}
+
+func TestConsumptionCycle(t *testing.T) {
+	m := newTestModel(t, 1, config.ModelStage{Type: "consumption", Params: map[string]float64{"mean": 0.05, "amplitude": 0.01}})
+
+	night := m.Sample(day(1)).Pressure
+	morning := m.Sample(day(7.5)).Pressure
+	evening := m.Sample(day(19)).Pressure
+	if night < 0.0495 || morning > 0.0401 || evening > 0.0401 {
+		t.Errorf("pressure night=%v morning=%v evening=%v, want peaks of consumption to lower pressure", night, morning, evening)
+	}
+}
+
+func TestAmbientLag(t *testing.T) {
+	m := newTestModel(t, 1, config.ModelStage{Type: "ambient", Params: map[string]float64{"mean": 10, "amplitude": 10, "peak_hour": 15, "lag_hours": 2}})
+
+	var warmest float64
+	var warmestAt int
+	for h := 0; h <= 48*6; h++ {
+		hour := float64(h) / 6
+		r := m.Sample(day(hour))
+		if hour >= 24 && r.Temperature > warmest {
+			warmest, warmestAt = r.Temperature, h
+		}
+	}
+	// инерция сдвигает максимум позже пика воздуха и срезает амплитуду
+	if peak := float64(warmestAt)/6 - 24; peak <= 15 || peak > 18 {
+		t.Errorf("gas temperature peaks at %.1fh, want after 15h", peak)
+	}
+	if warmest >= 20 || warmest < 15 {
+		t.Errorf("warmest gas temperature = %v, want damped below air maximum 20", warmest)
+	}
+}
+
+func TestCouplingAndQuantize(t *testing.T) {
+	m := newTestModel(t, 1,
+		config.ModelStage{Type: "constant", Params: map[string]float64{"pressure": 0.05, "temperature": 44.0}},
+		config.ModelStage{Type: "coupling", Params: map[string]float64{"reference": 15}},
+		config.ModelStage{Type: "quantize", Params: map[string]float64{"pressure": 0.0001, "temperature": 0.5}},
+	)
+	r := m.Sample(day(0))
+	// (44 + 273.15) / (15 + 273.15) ≈ 1.1006
+	if math.Abs(r.Pressure-0.0550) > 1e-9 || r.Temperature != 44 {
+		t.Errorf("got %+v, want pressure 0.0550 and temperature 44", r)
+	}
+}
+
+func TestNoiseDriftDeterministic(t *testing.T) {
+	spec := config.ModelStage{Type: "noise", Params: map[string]float64{"pressure": 0.001, "temperature": 0, "pressure_drift": 0.01}}
+	a := newTestModel(t, 42, config.ModelStage{Type: "constant"}, spec)
+	b := newTestModel(t, 42, config.ModelStage{Type: "constant"}, spec)
+
+	var first, last Reading
+	for h := 0; h <= 240; h++ {
+		ra, rb := a.Sample(day(float64(h))), b.Sample(day(float64(h)))
+		if ra != rb {
+			t.Fatalf("same seed diverged at %dh: %+v vs %+v", h, ra, rb)
+		}
+		if h == 0 {
+			first = ra
+		}
+		last = ra
+	}
+	if drift := last.Pressure - first.Pressure; drift < 0.09 || drift > 0.11 {
+		t.Errorf("drift over 10 days = %v, want ~0.1", drift)
+	}
+}
+
+func TestUnknownStage(t *testing.T) {
+	if _, err := NewModel([]config.ModelStage{{Type: "weather"}}, 1, rand.New(rand.NewPCG(1, 1))); err == nil {
+		t.Error("unknown stage type accepted")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	config "github.com/pochkachaiki/iot4gds/internal/config/data_simulator"
 	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/scenario"
 	"github.com/pochkachaiki/iot4gds/internal/sender"
 )
 
@@ -20,8 +21,13 @@ const (
 	deltaMPa               = deltaPa / 1e6
 )
 
-func Run(ctx context.Context, cfg *config.Config, deviceID int) {
-	ticker := time.NewTicker(time.Duration(cfg.MsgPeriod) * time.Second)
+// Run отправляет показания устройства с периодом msg_period. Если model задана,
+// показания берутся из неё, иначе — из packet.Generate. Случайные аномалии
+// давления накладываются поверх в обоих случаях. Если ctrl задан, устройство
+// подчиняется командам платформы: меняет период, закрывает клапан, сбрасывает дрейф.
+func Run(ctx context.Context, cfg *config.Config, deviceID int, model *Model, ctrl *Control, s sender.PacketSender) {
+	period := time.Duration(cfg.MsgPeriod) * time.Second
+	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
 	slog.InfoContext(ctx, "device started", "device_id", deviceID, "msg_period", cfg.MsgPeriod)
@@ -30,6 +36,7 @@ func Run(ctx context.Context, cfg *config.Config, deviceID int) {
 	anomalyDirection := 0
 	anomalyIncrement := float32(deltaMPa / float64(anomalyDuration-1))
 	currentMeanPressure := float32(defaultMeanPressure)
+	valve := float32(1) // доля давления после клапана: 1 — открыт, к 0 — закрыт
 
 	for {
 		select {
@@ -37,6 +44,29 @@ func Run(ctx context.Context, cfg *config.Config, deviceID int) {
 			slog.InfoContext(ctx, "device stopped", "device_id", deviceID)
 			return
 		case <-ticker.C:
+			if ctrl != nil {
+				st := ctrl.take()
+				if st.period != period {
+					period = st.period
+					ticker.Reset(period)
+					slog.InfoContext(ctx, "reporting period changed", "device_id", deviceID, "period", period)
+				}
+				if st.recalibrate {
+					anomalyCounter = 0
+					currentMeanPressure = defaultMeanPressure
+					if model != nil {
+						model.Recalibrate(time.Now())
+					}
+					slog.InfoContext(ctx, "device recalibrated", "device_id", deviceID)
+				}
+				// давление за клапаном стравливается и восстанавливается постепенно
+				if st.valveClosed {
+					valve *= 0.5
+				} else if valve < 1 {
+					valve = min(1, valve+(1-valve)*0.5+0.01)
+				}
+			}
+
 			if anomalyCounter == 0 {
 				if rand.Float32() < anomalyProbability {
 					anomalyCounter = anomalyDuration
@@ -58,8 +88,21 @@ func Run(ctx context.Context, cfg *config.Config, deviceID int) {
 				}
 			}
 
-			p := packet.Generate(deviceID, currentMeanPressure, defaultMeanTemperature)
-			err := sender.Send(cfg.IotSystemUrl, p)
+			var p packet.Packet
+			if model != nil {
+				r := model.Sample(time.Now())
+				p = packet.Packet{
+					DeviceID:    deviceID,
+					Timestamp:   time.Now().UTC().Format(time.RFC3339),
+					Pressure:    float32(r.Pressure) + currentMeanPressure - defaultMeanPressure,
+					Temperature: float32(r.Temperature),
+				}
+			} else {
+				p = packet.Generate(deviceID, currentMeanPressure, defaultMeanTemperature)
+			}
+			p.Pressure *= valve
+
+			err := s.Send(ctx, p)
 			if err != nil {
 				slog.ErrorContext(ctx, "send error", "device_id", deviceID, "err", err)
 			} else {
@@ -68,3 +111,53 @@ func Run(ctx context.Context, cfg *config.Config, deviceID int) {
 		}
 	}
 }
+
+// RunScenario отправляет показания устройства по сценарию. Время сценария считается
+// по номеру отсчёта, а не по часам, поэтому данные воспроизводимы между запусками.
+// Если model задана, события сценария накладываются на её показания вместо baseline.
+func RunScenario(ctx context.Context, cfg *config.Config, dev *scenario.Device, model *Model, duration time.Duration, s sender.PacketSender) {
+	if model != nil {
+		dev.Signal = func(elapsed time.Duration) (float64, float64) {
+			r := model.Sample(model.start.Add(elapsed))
+			return r.Pressure, r.Temperature
+		}
+	}
+
+	period := time.Duration(cfg.MsgPeriod * float32(time.Second))
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	slog.InfoContext(ctx, "scenario device started", "device_id", dev.ID, "msg_period", cfg.MsgPeriod)
+
+	for tick := 0; ; tick++ {
+		elapsed := time.Duration(tick) * period
+		if duration > 0 && elapsed > duration {
+			slog.InfoContext(ctx, "scenario finished", "device_id", dev.ID)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "device stopped", "device_id", dev.ID)
+			return
+		case <-ticker.C:
+		}
+
+		sample := dev.At(elapsed)
+		if !sample.Send {
+			continue
+		}
+
+		p := packet.Packet{
+			DeviceID:    dev.ID,
+			Timestamp:   time.Now().UTC().Format(time.RFC3339),
+			Pressure:    sample.Pressure,
+			Temperature: sample.Temperature,
+		}
+		if err := s.Send(ctx, p); err != nil {
+			slog.ErrorContext(ctx, "send error", "device_id", dev.ID, "err", err)
+		} else {
+			slog.InfoContext(ctx, "sent packet", "device_id", dev.ID, "elapsed", elapsed, "pressure", p.Pressure, "temperature", p.Temperature)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertQuery — условия поиска алертов. Пустое поле — без ограничения, период [From, To)
+// по времени пакета. Результат упорядочен от новых к старым.
+type AlertQuery struct {
+	Devices    []int
+	Types      []alert.Type
+	Rules      []alert.Rule
+	Severities []alert.Severity
+	States     []alert.State
+	Assignees  []string
+	From, To   time.Time
+	Limit      int
+	Offset     int
+}
+
+func (q AlertQuery) Match(a alert.Alert) bool {
+	return (len(q.Devices) == 0 || slices.Contains(q.Devices, a.DeviceID)) &&
+		(len(q.Types) == 0 || slices.Contains(q.Types, a.Type)) &&
+		(len(q.Rules) == 0 || slices.Contains(q.Rules, a.Rule)) &&
+		(len(q.Severities) == 0 || slices.Contains(q.Severities, a.Severity)) &&
+		(len(q.States) == 0 || slices.Contains(q.States, a.State)) &&
+		(len(q.Assignees) == 0 || slices.Contains(q.Assignees, a.Assignee)) &&
+		(q.From.IsZero() || !a.Timestamp.Before(q.From)) &&
+		(q.To.IsZero() || a.Timestamp.Before(q.To))
+}
+
+func (q AlertQuery) filter() bson.M {
+	f := bson.M{}
+	in := func(field string, values any, n int) {
+		if n > 0 {
+			f[field] = bson.M{"$in": values}
+		}
+	}
+	in("device_id", q.Devices, len(q.Devices))
+	in("type", q.Types, len(q.Types))
+	in("rule", q.Rules, len(q.Rules))
+	in("severity", q.Severities, len(q.Severities))
+	in("state", q.States, len(q.States))
+	in("assignee", q.Assignees, len(q.Assignees))
+
+	period := bson.M{}
+	if !q.From.IsZero() {
+		period["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		period["$lt"] = q.To
+	}
+	if len(period) > 0 {
+		f["timestamp"] = period
+	}
+	return f
+}
+
+// AlertRepository — запись и поиск алертов
+type AlertRepository interface {
+	AlertStore
+	GetAlert(ctx context.Context, id string) (alert.Alert, error)
+	FindAlerts(ctx context.Context, q AlertQuery) ([]alert.Alert, error)
+	CountAlerts(ctx context.Context, q AlertQuery) (int64, error)
+	// ApplyAlertEvent выполняет действие оператора и возвращает обновлённый алерт.
+	// Ошибки перехода состояния возвращаются из alert.Apply как есть.
+	ApplyAlertEvent(ctx context.Context, id string, e alert.Event) (alert.Alert, error)
+}
+
+// EscalationStore — выборка и продвижение алертов по шагам эскалации
+type EscalationStore interface {
+	// DueEscalations возвращает открытые алерты, у которых шаг эскалации наступил к now
+	DueEscalations(ctx context.Context, now time.Time, limit int) ([]alert.Alert, error)
+	// AdvanceEscalation переводит алерт на следующий шаг и записывает e в журнал, если
+	// алерт всё ещё открыт и стоит на шаге step. nextAt == nil — шагов больше нет.
+	// false — алерт успели подтвердить, закрыть или продвинуть.
+	AdvanceEscalation(ctx context.Context, id primitive.ObjectID, step int, nextAt *time.Time, e alert.Event) (bool, error)
+	// CountAlerts — по нему проверяются подтверждённые алерты того же устройства и правила
+	CountAlerts(ctx context.Context, q AlertQuery) (int64, error)
+}
+
+// SeverityStore — повышение важности открытого алерта вместо записи нового
+type SeverityStore interface {
+	// RaiseSeverity находит последний открытый алерт того же устройства и правила с
+	// важностью ниже a.Severity и переносит в него важность, время, показания и
+	// эскалацию a, записывая e в журнал. Повышенный алерт заново отдаётся подписчикам
+	// WatchAlerts. false — такого алерта нет.
+	RaiseSeverity(ctx context.Context, a alert.Alert, e alert.Event) (alert.Alert, bool, error)
+}
+
+// applyRetries — сколько раз повторяется действие, если алерт изменили параллельно
+const applyRetries = 5
+
+type MongoAlertStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoAlertStore(coll *mongo.Collection) *MongoAlertStore {
+	return &MongoAlertStore{coll: coll}
+}
+
+func (s *MongoAlertStore) InsertAlert(ctx context.Context, a alert.Alert) (err error) {
+	ctx, span := startSpan(ctx, s.coll, "insert")
+	defer func() { tracing.End(span, err) }()
+
+	_, err = s.coll.InsertOne(ctx, a)
+	return err
+}
+
+func (s *MongoAlertStore) GetAlert(ctx context.Context, id string) (alert.Alert, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return alert.Alert{}, ErrNotFound
+	}
+	var a alert.Alert
+	err = s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return alert.Alert{}, ErrNotFound
+	}
+	return a, err
+}
+
+func (s *MongoAlertStore) FindAlerts(ctx context.Context, q AlertQuery) ([]alert.Alert, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	if q.Offset > 0 {
+		opts.SetSkip(int64(q.Offset))
+	}
+	cursor, err := s.coll.Find(ctx, q.filter(), opts)
+	if err != nil {
+		return nil, err
+	}
+	alerts := []alert.Alert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (s *MongoAlertStore) CountAlerts(ctx context.Context, q AlertQuery) (int64, error) {
+	return s.coll.CountDocuments(ctx, q.filter())
+}
+
+// ApplyAlertEvent читает алерт, применяет действие и записывает его обратно, только
+// если updated_at не изменился с момента чтения (оптимистичная блокировка)
+func (s *MongoAlertStore) ApplyAlertEvent(ctx context.Context, id string, e alert.Event) (alert.Alert, error) {
+	for range applyRetries {
+		a, err := s.GetAlert(ctx, id)
+		if err != nil {
+			return alert.Alert{}, err
+		}
+		prev := a.UpdatedAt
+		if err := a.Apply(e); err != nil {
+			return alert.Alert{}, err
+		}
+		res, err := s.coll.ReplaceOne(ctx, bson.M{"_id": a.ID, "updated_at": prev}, a)
+		if err != nil {
+			return alert.Alert{}, err
+		}
+		if res.MatchedCount == 1 {
+			return a, nil
+		}
+	}
+	return alert.Alert{}, ErrConflict
+}
+
+func (s *MongoAlertStore) DueEscalations(ctx context.Context, now time.Time, limit int) ([]alert.Alert, error) {
+	filter := bson.M{"state": alert.Open, "escalation.next_at": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "escalation.next_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var alerts []alert.Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (s *MongoAlertStore) AdvanceEscalation(ctx context.Context, id primitive.ObjectID, step int, nextAt *time.Time, e alert.Event) (bool, error) {
+	set := bson.M{"escalation.step": step + 1, "updated_at": e.At}
+	update := bson.M{"$set": set, "$push": bson.M{"history": e}}
+	if nextAt != nil {
+		set["escalation.next_at"] = *nextAt
+	} else {
+		update["$unset"] = bson.M{"escalation.next_at": ""}
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": id, "state": alert.Open, "escalation.step": step}, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (s *MongoAlertStore) RaiseSeverity(ctx context.Context, a alert.Alert, e alert.Event) (_ alert.Alert, _ bool, err error) {
+	ctx, span := startSpan(ctx, s.coll, "findAndModify")
+	defer func() { tracing.End(span, err) }()
+
+	filter := bson.M{
+		"device_id": a.DeviceID,
+		"rule":      a.Rule,
+		"state":     alert.Open,
+		"severity":  bson.M{"$in": a.Severity.Below()},
+	}
+	set := bson.M{"severity": a.Severity, "timestamp": a.Timestamp, "values": a.Values, "updated_at": e.At, "raised_at": e.At}
+	update := bson.M{"$set": set, "$push": bson.M{"history": e}}
+	if a.Escalation != nil {
+		set["escalation"] = a.Escalation
+	} else {
+		update["$unset"] = bson.M{"escalation": ""}
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetReturnDocument(options.After)
+
+	var raised alert.Alert
+	err = s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&raised)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return alert.Alert{}, false, nil
+	}
+	if err != nil {
+		return alert.Alert{}, false, err
+	}
+	return raised, true, nil
+}
+
+// createRaiseIndexes — индекс для опроса повышенных алертов без change streams
+func createRaiseIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Alerts).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "raised_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"raised_at": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+func createEscalationIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Alerts).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "state", Value: 1}, {Key: "escalation.next_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{
+			"escalation.next_at": bson.M{"$exists": true},
+		}),
+	})
+	return err
+}
+
+// normalizeAlerts приводит алерты, записанные до появления модели alert, к её схеме:
+// строковое время становится датой, rule выводится из reason, показания переносятся
+// в values. Выполняется одним обновлением на сервере, документы не выгружаются.
+func normalizeAlerts(ctx context.Context, db *mongo.Database, s Schema) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"timestamp": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$timestamp"}, "string"}},
+				bson.M{"$dateFromString": bson.M{"dateString": "$timestamp"}},
+				"$timestamp",
+			}},
+			"rule": bson.M{"$replaceAll": bson.M{"input": "$reason", "find": " ", "replacement": "_"}},
+			"severity": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$type", string(alert.Sustained)}},
+				string(alert.DefaultSeverity(alert.Sustained)),
+				string(alert.DefaultSeverity(alert.Instant)),
+			}},
+			"state":      bson.M{"$ifNull": bson.A{"$state", string(alert.Open)}},
+			"values":     bson.M{"pressure": "$pressure", "temperature": "$temperature", "change": "$change"},
+			"created_at": bson.M{"$toDate": "$_id"},
+			"updated_at": "$$NOW",
+		}}},
+		{{Key: "$unset", Value: bson.A{"pressure", "temperature", "change"}}},
+	}
+	res, err := db.Collection(s.Alerts).UpdateMany(ctx, bson.M{"rule": bson.M{"$exists": false}}, pipeline)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount > 0 {
+		slog.Info("alerts normalized", "count", res.ModifiedCount)
+	}
+	return nil
+}
+
+// legacyAlert — то же преобразование для алерта старого формата, прочитанного в память
+func legacyAlert(m bson.M) (alert.Alert, error) {
+	a := alert.Alert{State: alert.Open}
+	a.ID, _ = m["_id"].(primitive.ObjectID)
+	if t, ok := m["type"].(string); ok {
+		a.Type = alert.Type(t)
+	}
+	a.Reason, _ = m["reason"].(string)
+	a.Rule = alert.Rule(strings.ReplaceAll(a.Reason, " ", "_"))
+	a.Severity = alert.DefaultSeverity(a.Type)
+
+	switch v := m["device_id"].(type) {
+	case int32:
+		a.DeviceID = int(v)
+	case int64:
+		a.DeviceID = int(v)
+	}
+
+	switch v := m["timestamp"].(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return alert.Alert{}, fmt.Errorf("alert timestamp %q: %w", v, err)
+		}
+		a.Timestamp = t.UTC()
+	case primitive.DateTime:
+		a.Timestamp = v.Time().UTC()
+	}
+
+	value := func(name string) *float32 {
+		if v, ok := m[name].(float64); ok {
+			return alert.Float(float32(v))
+		}
+		return nil
+	}
+	a.Values = alert.Values{Pressure: value("pressure"), Temperature: value("temperature"), Change: value("change")}
+	if !a.ID.IsZero() {
+		a.CreatedAt = a.ID.Timestamp().UTC()
+	}
+	a.UpdatedAt = a.CreatedAt
+	return a, nil
+}
+
+// decodeAlert читает алерт в любом из форматов
+func decodeAlert(raw bson.Raw) (alert.Alert, error) {
+	if _, err := raw.LookupErr("rule"); err == nil {
+		var a alert.Alert
+		err := bson.Unmarshal(raw, &a)
+		return a, err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return alert.Alert{}, err
+	}
+	return legacyAlert(m)
+}
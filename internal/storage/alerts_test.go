@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDecodeLegacyAlert(t *testing.T) {
+	id := primitive.NewObjectID()
+	raw, err := bson.Marshal(bson.M{
+		"_id": id, "type": "sustained", "device_id": int32(3),
+		"timestamp": "2025-03-01T10:00:00Z", "reason": "rapid pressure decrease", "change": -0.2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := decodeAlert(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != id || a.Rule != alert.RapidPressureDecrease || a.Severity != alert.Warning || a.State != alert.Open {
+		t.Errorf("alert = %+v", a)
+	}
+	if a.DeviceID != 3 || !a.Timestamp.Equal(time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("device/timestamp = %d %s", a.DeviceID, a.Timestamp)
+	}
+	if a.Values.Change == nil || *a.Values.Change != -0.2 || a.Values.Pressure != nil {
+		t.Errorf("values = %+v", a.Values)
+	}
+
+	// алерт новой схемы читается как есть
+	typed := alert.New(alert.PressureHigh, 1, a.Timestamp, alert.Values{Pressure: alert.Float(0.09)})
+	raw, _ = bson.Marshal(typed)
+	if back, err := decodeAlert(raw); err != nil || back.Rule != alert.PressureHigh || *back.Values.Pressure != 0.09 {
+		t.Errorf("typed alert = %+v, %v", back, err)
+	}
+}
+
+func TestMemoryFindAlerts(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryAlertStore()
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		s.InsertAlert(ctx, alert.New(alert.PressureLow, 1+i%2, at.Add(time.Duration(i)*time.Minute), alert.Values{}))
+	}
+	s.InsertAlert(ctx, alert.New(alert.RapidPressureIncrease, 1, at, alert.Values{}))
+
+	q := AlertQuery{Devices: []int{1}, Severities: []alert.Severity{alert.Critical}, From: at.Add(time.Minute), Limit: 1}
+	got, err := s.FindAlerts(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].Timestamp.Equal(at.Add(4*time.Minute)) {
+		t.Errorf("alerts = %+v", got)
+	}
+	if n, _ := s.CountAlerts(ctx, q); n != 2 {
+		t.Errorf("count = %d, want 2", n)
+	}
+
+	if a, err := s.GetAlert(ctx, got[0].ID.Hex()); err != nil || a.ID != got[0].ID {
+		t.Errorf("get = %+v, %v", a, err)
+	}
+	if _, err := s.GetAlert(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get unknown: err = %v", err)
+	}
+}
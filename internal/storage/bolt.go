@@ -0,0 +1,623 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	packetsBucket  = []byte("packets")
+	alertsBucket   = []byte("alerts")
+	outboxBucket   = []byte("outbox")
+	alertIDsBucket = []byte("alert_ids")       // id алерта -> ключ в alerts
+	alertFeed      = []byte("alert_feed")      // лента новых и повышенных алертов для подписчиков
+	watchBucket    = []byte("watch_positions") // позиции именованных подписчиков в ленте
+	silencesBucket = []byte("silences")
+	commandsBucket = []byte("commands")
+)
+
+// BoltStore — встраиваемое хранилище пакетов и алертов в одном файле bbolt.
+// Используется в однопроцессном режиме на станциях без Mongo.
+// Пакеты лежат во вложенных бакетах по устройствам с ключом (время, seq),
+// поэтому выборка последних пакетов не требует сортировки.
+type BoltStore struct {
+	db     *bolt.DB
+	outbox bool
+
+	mu      sync.Mutex
+	changed chan struct{} // закрывается, когда в ленте алертов появляются записи
+}
+
+// OpenBoltStore открывает (или создаёт) файл хранилища. Если outbox включён, каждый
+// сохранённый пакет дополнительно ставится в очередь на отправку в центральную систему.
+func OpenBoltStore(path string, outbox bool) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		indexed := tx.Bucket(alertIDsBucket) != nil
+		for _, name := range [][]byte{packetsBucket, alertsBucket, outboxBucket, alertIDsBucket, alertFeed, watchBucket, silencesBucket, commandsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if indexed {
+			return nil
+		}
+		// файл из версии без индекса: индексируем уже записанные алерты
+		ids := tx.Bucket(alertIDsBucket)
+		return tx.Bucket(alertsBucket).ForEach(func(k, v []byte) error {
+			a, err := decodeAlert(v)
+			if err != nil || a.ID.IsZero() {
+				return err
+			}
+			return ids.Put(a.ID[:], k)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db, outbox: outbox, changed: make(chan struct{})}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func packetKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func (s *BoltStore) InsertPacket(_ context.Context, p packet.Packet) error {
+	t, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		return fmt.Errorf("parse timestamp: %w", err)
+	}
+	value, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		device, err := tx.Bucket(packetsBucket).CreateBucketIfNotExists([]byte(strconv.Itoa(p.DeviceID)))
+		if err != nil {
+			return err
+		}
+		seq, err := device.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := device.Put(packetKey(t, seq), value); err != nil {
+			return err
+		}
+
+		if !s.outbox {
+			return nil
+		}
+		outbox := tx.Bucket(outboxBucket)
+		seq, err = outbox.NextSequence()
+		if err != nil {
+			return err
+		}
+		return outbox.Put(seqKey(seq), value)
+	})
+}
+
+func (s *BoltStore) RecentPackets(_ context.Context, deviceID int, until time.Time, n int) ([]packet.Packet, error) {
+	var packets []packet.Packet
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		device := tx.Bucket(packetsBucket).Bucket([]byte(strconv.Itoa(deviceID)))
+		if device == nil {
+			return nil
+		}
+
+		c := device.Cursor()
+		bound := packetKey(until.Add(time.Nanosecond), 0)
+		k, v := c.Seek(bound)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		for ; k != nil && len(packets) < n; k, v = c.Prev() {
+			if bytes.Compare(k, bound) >= 0 {
+				continue
+			}
+			var p packet.Packet
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			packets = append(packets, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// собирали от новых к старым
+	for i, j := 0, len(packets)-1; i < j; i, j = i+1, j-1 {
+		packets[i], packets[j] = packets[j], packets[i]
+	}
+	return packets, nil
+}
+
+func (s *BoltStore) InsertAlert(_ context.Context, a alert.Alert) error {
+	value, err := bson.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		alerts := tx.Bucket(alertsBucket)
+		seq, err := alerts.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := seqKey(seq)
+		if err := alerts.Put(key, value); err != nil {
+			return err
+		}
+		if !a.ID.IsZero() {
+			if err := tx.Bucket(alertIDsBucket).Put(a.ID[:], key); err != nil {
+				return err
+			}
+		}
+		return appendFeed(tx, value)
+	})
+	if err != nil {
+		return err
+	}
+	s.signal()
+	return nil
+}
+
+// Alerts возвращает все сохранённые алерты в порядке записи. Алерты, записанные
+// до появления модели alert, приводятся к ней при чтении.
+func (s *BoltStore) Alerts() ([]alert.Alert, error) {
+	var alerts []alert.Alert
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(alertsBucket).ForEach(func(_, v []byte) error {
+			a, err := decodeAlert(v)
+			if err != nil {
+				return err
+			}
+			alerts = append(alerts, a)
+			return nil
+		})
+	})
+	return alerts, err
+}
+
+// OutboxEntry — пакет, ожидающий отправки в центральную систему
+type OutboxEntry struct {
+	Seq    uint64
+	Packet packet.Packet
+}
+
+// PendingOutbox возвращает до n самых старых неотправленных пакетов
+func (s *BoltStore) PendingOutbox(n int) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, v := c.First(); k != nil && len(entries) < n; k, v = c.Next() {
+			var p packet.Packet
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			entries = append(entries, OutboxEntry{Seq: binary.BigEndian.Uint64(k), Packet: p})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// AckOutbox удаляет из очереди на отправку все пакеты до seq включительно
+func (s *BoltStore) AckOutbox(seq uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= seq; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// OutboxLen возвращает число неотправленных пакетов
+func (s *BoltStore) OutboxLen() (int, error) {
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(outboxBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// alertFeedLimit — сколько последних записей хранит лента. Подписчик, отставший
+// сильнее, пропустит самые старые алерты.
+const alertFeedLimit = 10000
+
+// appendFeed добавляет в ленту подписчиков снимок алерта на момент записи
+func appendFeed(tx *bolt.Tx, value []byte) error {
+	feed := tx.Bucket(alertFeed)
+	seq, err := feed.NextSequence()
+	if err != nil {
+		return err
+	}
+	if first, _ := feed.Cursor().First(); first != nil && seq-binary.BigEndian.Uint64(first) >= alertFeedLimit {
+		if err := feed.Delete(first); err != nil {
+			return err
+		}
+	}
+	return feed.Put(seqKey(seq), value)
+}
+
+// signal будит подписчиков ленты алертов
+func (s *BoltStore) signal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *BoltStore) changes() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// alertKey возвращает ключ алерта в бакете alerts
+func alertKey(tx *bolt.Tx, id primitive.ObjectID) []byte {
+	if id.IsZero() {
+		return nil
+	}
+	return tx.Bucket(alertIDsBucket).Get(id[:])
+}
+
+// updateAlert изменяет алерт в одной транзакции. Ошибка fn отменяет изменение.
+func (s *BoltStore) updateAlert(id primitive.ObjectID, fn func(tx *bolt.Tx, key []byte, a *alert.Alert) error) (alert.Alert, error) {
+	var a alert.Alert
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key := alertKey(tx, id)
+		if key == nil {
+			return ErrNotFound
+		}
+		alerts := tx.Bucket(alertsBucket)
+		var err error
+		if a, err = decodeAlert(alerts.Get(key)); err != nil {
+			return err
+		}
+		if err := fn(tx, key, &a); err != nil {
+			return err
+		}
+		value, err := bson.Marshal(a)
+		if err != nil {
+			return err
+		}
+		return alerts.Put(key, value)
+	})
+	return a, err
+}
+
+func (s *BoltStore) GetAlert(_ context.Context, id string) (alert.Alert, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return alert.Alert{}, ErrNotFound
+	}
+	var a alert.Alert
+	err = s.db.View(func(tx *bolt.Tx) error {
+		key := alertKey(tx, oid)
+		if key == nil {
+			return ErrNotFound
+		}
+		a, err = decodeAlert(tx.Bucket(alertsBucket).Get(key))
+		return err
+	})
+	return a, err
+}
+
+// FindAlerts перебирает бакет целиком: алертов на одной станции немного
+func (s *BoltStore) FindAlerts(_ context.Context, q AlertQuery) ([]alert.Alert, error) {
+	alerts, err := s.Alerts()
+	if err != nil {
+		return nil, err
+	}
+	return findAlerts(alerts, q), nil
+}
+
+func (s *BoltStore) CountAlerts(ctx context.Context, q AlertQuery) (int64, error) {
+	q.Limit, q.Offset = 0, 0
+	found, err := s.FindAlerts(ctx, q)
+	return int64(len(found)), err
+}
+
+func (s *BoltStore) ApplyAlertEvent(_ context.Context, id string, e alert.Event) (alert.Alert, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return alert.Alert{}, ErrNotFound
+	}
+	return s.updateAlert(oid, func(_ *bolt.Tx, _ []byte, a *alert.Alert) error {
+		return a.Apply(e)
+	})
+}
+
+func (s *BoltStore) DueEscalations(_ context.Context, now time.Time, limit int) ([]alert.Alert, error) {
+	alerts, err := s.Alerts()
+	if err != nil {
+		return nil, err
+	}
+	return dueEscalations(alerts, now, limit), nil
+}
+
+func (s *BoltStore) AdvanceEscalation(_ context.Context, id primitive.ObjectID, step int, nextAt *time.Time, e alert.Event) (bool, error) {
+	errStale := errors.New("escalation already advanced")
+	_, err := s.updateAlert(id, func(_ *bolt.Tx, _ []byte, a *alert.Alert) error {
+		if a.State != alert.Open || a.Escalation == nil || a.Escalation.Step != step {
+			return errStale
+		}
+		a.Escalation = &alert.Escalation{Policy: a.Escalation.Policy, Since: a.Escalation.Since, Step: step + 1, NextAt: nextAt}
+		a.History = append(a.History, e)
+		a.UpdatedAt = e.At
+		return nil
+	})
+	if errors.Is(err, errStale) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *BoltStore) RaiseSeverity(_ context.Context, a alert.Alert, e alert.Event) (alert.Alert, bool, error) {
+	var raised alert.Alert
+	var found []byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		alerts := tx.Bucket(alertsBucket)
+		err := alerts.ForEach(func(k, v []byte) error {
+			prev, err := decodeAlert(v)
+			if err != nil {
+				return err
+			}
+			if raisable(prev, a) && (found == nil || !prev.Timestamp.Before(raised.Timestamp)) {
+				found, raised = k, prev
+			}
+			return nil
+		})
+		if err != nil || found == nil {
+			return err
+		}
+		raised = raise(raised, a, e)
+		value, err := bson.Marshal(raised)
+		if err != nil {
+			return err
+		}
+		if err := alerts.Put(found, value); err != nil {
+			return err
+		}
+		// повышенный алерт снова попадает в ленту, чтобы подписчики разослали его
+		return appendFeed(tx, value)
+	})
+	if err != nil || found == nil {
+		return alert.Alert{}, false, err
+	}
+	s.signal()
+	return raised, true, nil
+}
+
+// WatchAlerts отдаёт алерты, попавшие в ленту после подписки
+func (s *BoltStore) WatchAlerts(ctx context.Context, filter AlertFilter) <-chan alert.Alert {
+	return s.Consumer("").WatchAlerts(ctx, filter)
+}
+
+// Consumer возвращает подписчика, сохраняющего позицию в ленте под именем name,
+// как AlertWatcher.Consumer. Пустое имя — позиция не сохраняется.
+func (s *BoltStore) Consumer(name string) *BoltAlertWatcher {
+	return &BoltAlertWatcher{store: s, consumer: name}
+}
+
+// BoltAlertWatcher читает ленту алертов BoltStore. Именованный подписчик после
+// перезапуска продолжает с сохранённой позиции — доставка «хотя бы один раз».
+type BoltAlertWatcher struct {
+	store    *BoltStore
+	consumer string
+}
+
+func (w *BoltAlertWatcher) WatchAlerts(ctx context.Context, filter AlertFilter) <-chan alert.Alert {
+	out := make(chan alert.Alert)
+	// позиция берётся до возврата, чтобы алерты, вставленные сразу после подписки, не терялись
+	pos, err := w.position()
+	go func() {
+		defer close(out)
+		if err == nil {
+			err = w.run(ctx, filter, pos, out)
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Error("bolt alert watcher error", "consumer", w.consumer, "err", err)
+		}
+	}()
+	return out
+}
+
+func (w *BoltAlertWatcher) run(ctx context.Context, filter AlertFilter, pos uint64, out chan<- alert.Alert) error {
+	for {
+		// канал берётся до чтения, чтобы не пропустить запись между чтением и ожиданием
+		changed := w.store.changes()
+		alerts, last, err := w.read(pos)
+		if err != nil {
+			return err
+		}
+		for _, a := range alerts {
+			if !filter.Match(a) {
+				continue
+			}
+			select {
+			case out <- a:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if last != pos {
+			pos = last
+			if err := w.savePosition(pos); err != nil {
+				return err
+			}
+		}
+		if len(alerts) == watchBatch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// read возвращает до watchBatch алертов ленты после позиции pos и новую позицию
+func (w *BoltAlertWatcher) read(pos uint64) ([]alert.Alert, uint64, error) {
+	var alerts []alert.Alert
+	err := w.store.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(alertFeed).Cursor()
+		for k, v := c.Seek(seqKey(pos + 1)); k != nil && len(alerts) < watchBatch; k, v = c.Next() {
+			pos = binary.BigEndian.Uint64(k)
+			a, err := decodeAlert(v)
+			if err != nil {
+				return err
+			}
+			alerts = append(alerts, a)
+		}
+		return nil
+	})
+	return alerts, pos, err
+}
+
+// position возвращает сохранённую позицию подписчика, а для нового подписчика —
+// конец ленты
+func (w *BoltAlertWatcher) position() (uint64, error) {
+	var pos uint64
+	err := w.store.db.View(func(tx *bolt.Tx) error {
+		if w.consumer != "" {
+			if v := tx.Bucket(watchBucket).Get([]byte(w.consumer)); v != nil {
+				pos = binary.BigEndian.Uint64(v)
+				return nil
+			}
+		}
+		if k, _ := tx.Bucket(alertFeed).Cursor().Last(); k != nil {
+			pos = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return pos, err
+}
+
+func (w *BoltAlertWatcher) savePosition(pos uint64) error {
+	if w.consumer == "" {
+		return nil
+	}
+	return w.store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(watchBucket).Put([]byte(w.consumer), seqKey(pos))
+	})
+}
+
+// putDoc сохраняет v в бакет в bson
+func putDoc(b *bolt.Bucket, key []byte, v any) error {
+	value, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+// getDoc читает документ бакета в v, ErrNotFound — если ключа нет
+func getDoc(b *bolt.Bucket, key []byte, v any) error {
+	value := b.Get(key)
+	if value == nil {
+		return ErrNotFound
+	}
+	return bson.Unmarshal(value, v)
+}
+
+// ScanPackets передаёт fn пакеты устройств devices за [from, to) по возрастанию
+// времени. Пустой devices — все устройства. Период собирается в памяти, чтобы
+// упорядочить пакеты разных устройств, поэтому запрашивайте его частями.
+func (s *BoltStore) ScanPackets(_ context.Context, devices []int, from, to time.Time, fn func(PacketDocument) error) error {
+	var docs []PacketDocument
+	err := s.db.View(func(tx *bolt.Tx) error {
+		packets := tx.Bucket(packetsBucket)
+		var names [][]byte
+		if len(devices) == 0 {
+			err := packets.ForEachBucket(func(k []byte) error {
+				names = append(names, k)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range devices {
+			names = append(names, []byte(strconv.Itoa(id)))
+		}
+
+		bound := packetKey(to, 0)
+		for _, name := range names {
+			device := packets.Bucket(name)
+			if device == nil {
+				continue
+			}
+			c := device.Cursor()
+			for k, v := c.Seek(packetKey(from, 0)); k != nil && bytes.Compare(k, bound) < 0; k, v = c.Next() {
+				var p packet.Packet
+				if err := json.Unmarshal(v, &p); err != nil {
+					return err
+				}
+				t, err := time.Parse(time.RFC3339, p.Timestamp)
+				if err != nil {
+					continue
+				}
+				docs = append(docs, PacketDocument{DeviceID: p.DeviceID, Timestamp: t, Pressure: p.Pressure, Temperature: p.Temperature})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].Timestamp.Before(docs[j].Timestamp) })
+	for _, d := range docs {
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
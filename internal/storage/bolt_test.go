@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func openTestBolt(t *testing.T, outbox bool) *BoltStore {
+	t.Helper()
+	s, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"), outbox)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBoltStoreRecentPackets(t *testing.T) {
+	ctx := context.Background()
+	s := openTestBolt(t, false)
+
+	for _, sec := range []int{3, 1, 4, 0, 2} {
+		p := packet.Packet{DeviceID: 7, Timestamp: fmt.Sprintf("2025-01-01T00:00:0%dZ", sec), Pressure: float32(sec)}
+		if err := s.InsertPacket(ctx, p); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	got, err := s.RecentPackets(ctx, 7, time.Date(2025, 1, 1, 0, 0, 3, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatalf("recent packets: %v", err)
+	}
+	if len(got) != 2 || got[0].Pressure != 2 || got[1].Pressure != 3 {
+		t.Errorf("got %+v, want pressures [2 3]", got)
+	}
+
+	got, err = s.RecentPackets(ctx, 7, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), 10)
+	if err != nil {
+		t.Fatalf("recent packets: %v", err)
+	}
+	if len(got) != 5 || got[4].Pressure != 4 {
+		t.Errorf("got %+v, want all 5 packets ending with pressure 4", got)
+	}
+
+	got, err = s.RecentPackets(ctx, 8, time.Now(), 10)
+	if err != nil || len(got) != 0 {
+		t.Errorf("unknown device: got %+v, %v", got, err)
+	}
+}
+
+func TestBoltStoreOutbox(t *testing.T) {
+	ctx := context.Background()
+	s := openTestBolt(t, true)
+
+	for i := 0; i < 5; i++ {
+		p := packet.Packet{DeviceID: 1, Timestamp: fmt.Sprintf("2025-01-01T00:00:0%dZ", i)}
+		if err := s.InsertPacket(ctx, p); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	entries, err := s.PendingOutbox(3)
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(entries) != 3 || entries[0].Packet.Timestamp != "2025-01-01T00:00:00Z" {
+		t.Fatalf("pending = %+v", entries)
+	}
+
+	if err := s.AckOutbox(entries[1].Seq); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if n, _ := s.OutboxLen(); n != 3 {
+		t.Errorf("outbox len = %d, want 3", n)
+	}
+	entries, _ = s.PendingOutbox(10)
+	if len(entries) != 3 || entries[0].Packet.Timestamp != "2025-01-01T00:00:02Z" {
+		t.Errorf("pending after ack = %+v", entries)
+	}
+}
+
+func TestBoltStoreAlerts(t *testing.T) {
+	ctx := context.Background()
+	s := openTestBolt(t, false)
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.InsertAlert(ctx, alert.New(alert.PressureHigh, 1, at, alert.Values{}))
+	s.InsertAlert(ctx, alert.New(alert.PressureLow, 2, at, alert.Values{Pressure: alert.Float(0.01)}))
+
+	alerts, err := s.Alerts()
+	if err != nil {
+		t.Fatalf("alerts: %v", err)
+	}
+	if len(alerts) != 2 || alerts[1].Rule != alert.PressureLow || *alerts[1].Values.Pressure != 0.01 {
+		t.Errorf("alerts = %v", alerts)
+	}
+}
+
+func TestBoltStoreAlertRepository(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := OpenBoltStore(path, false)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := alert.New(alert.PressureHigh, 1, at, alert.Values{})
+	second := alert.New(alert.PressureLow, 2, at.Add(time.Second), alert.Values{})
+	s.InsertAlert(ctx, first)
+	s.InsertAlert(ctx, second)
+
+	found, err := s.FindAlerts(ctx, AlertQuery{Devices: []int{1}})
+	if err != nil || len(found) != 1 || found[0].ID != first.ID {
+		t.Fatalf("find = %v, %v", found, err)
+	}
+	if _, err := s.ApplyAlertEvent(ctx, first.ID.Hex(), alert.Event{Action: alert.Ack, Operator: "op", At: at}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, err := s.GetAlert(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get unknown = %v, want ErrNotFound", err)
+	}
+	s.Close()
+
+	// индекс по id переживает перезапуск
+	s, err = OpenBoltStore(path, false)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	got, err := s.GetAlert(ctx, first.ID.Hex())
+	if err != nil || got.State != alert.Acknowledged || got.AcknowledgedBy != "op" {
+		t.Errorf("get = %+v, %v", got, err)
+	}
+	if n, _ := s.CountAlerts(ctx, AlertQuery{}); n != 2 {
+		t.Errorf("count = %d, want 2", n)
+	}
+}
+
+func TestBoltStoreConsumerResumes(t *testing.T) {
+	s := openTestBolt(t, false)
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := alert.New(alert.PressureLow, 1, at, alert.Values{})
+	s.InsertAlert(context.Background(), old)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	alerts := s.Consumer("notifier").WatchAlerts(ctx, AlertFilter{})
+	delivered := alert.New(alert.PressureHigh, 1, at.Add(time.Second), alert.Values{})
+	s.InsertAlert(ctx, delivered)
+	select {
+	case a := <-alerts:
+		if a.ID != delivered.ID {
+			t.Fatalf("got %s, want the alert inserted after subscribing", a.ID.Hex())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert delivered")
+	}
+	cancel()
+	for range alerts {
+	}
+
+	// пропущенный без подписчика алерт доставляется после возобновления
+	missed := alert.New(alert.PressureHigh, 2, at.Add(2*time.Second), alert.Values{})
+	s.InsertAlert(context.Background(), missed)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	alerts = s.Consumer("notifier").WatchAlerts(ctx, AlertFilter{})
+	for {
+		select {
+		case a := <-alerts:
+			if a.ID == old.ID {
+				t.Fatal("alert inserted before the first subscription delivered")
+			}
+			if a.ID == missed.ID {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missed alert not delivered")
+		}
+	}
+}
+
+func TestBoltStoreCommands(t *testing.T) {
+	ctx := context.Background()
+	s := openTestBolt(t, false)
+
+	created := time.Now().UTC()
+	for i, id := range []string{"b", "a"} {
+		c := command.Command{ID: id, DeviceID: 1, Type: command.OpenValve, Status: command.StatusPending, CreatedAt: created.Add(time.Duration(i) * time.Second)}
+		if err := s.CreateCommand(ctx, c); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	claimed, err := s.ClaimCommands(ctx, 1, time.Minute, 10)
+	if err != nil || len(claimed) != 2 || claimed[0].ID != "b" || claimed[0].Attempts != 1 {
+		t.Fatalf("claim = %+v, %v", claimed, err)
+	}
+	if again, _ := s.ClaimCommands(ctx, 1, time.Minute, 10); len(again) != 0 {
+		t.Errorf("claimed twice: %+v", again)
+	}
+
+	if _, err := s.AckCommand(ctx, 2, "a", command.Ack{Status: command.StatusSucceeded}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ack from another device = %v, want ErrNotFound", err)
+	}
+	acked, err := s.AckCommand(ctx, 1, "a", command.Ack{Status: command.StatusSucceeded})
+	if err != nil || acked.Status != command.StatusSucceeded || acked.AckedAt == nil {
+		t.Errorf("ack = %+v, %v", acked, err)
+	}
+}
+
+func TestBoltStoreReadSeries(t *testing.T) {
+	ctx := context.Background()
+	s := openTestBolt(t, false)
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, sec := range []int{70, 0, 40, 20} {
+		p := packet.Packet{DeviceID: 1, Timestamp: base.Add(time.Duration(sec) * time.Second).Format(time.RFC3339), Pressure: float32(sec)}
+		s.InsertPacket(ctx, p)
+	}
+
+	points, err := s.ReadSeries(ctx, 1, Minutely, base, base.Add(time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Count != 3 || points[0].Pressure.Last != 40 || points[1].Count != 1 {
+		t.Errorf("points = %+v", points)
+	}
+
+	raw, _ := s.ReadSeries(ctx, 1, Raw, base.Add(20*time.Second), base.Add(70*time.Second), 100)
+	if len(raw) != 2 || raw[0].Pressure.Last != 20 {
+		t.Errorf("raw points = %+v", raw)
+	}
+}
+
+func TestBoltStoreRaiseSeverity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := openTestBolt(t, false)
+	alerts := s.WatchAlerts(ctx, AlertFilter{})
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	warning := alert.New(alert.PressureHigh, 1, at, alert.Values{Pressure: alert.Float(0.065)})
+	warning.Severity = alert.Warning
+	s.InsertAlert(ctx, warning)
+
+	critical := alert.New(alert.PressureHigh, 1, at.Add(time.Minute), alert.Values{Pressure: alert.Float(0.08)})
+	raised, ok, err := s.RaiseSeverity(ctx, critical, alert.Event{Action: alert.Raise, Operator: "engine", At: critical.CreatedAt})
+	if err != nil || !ok || raised.ID != warning.ID || !raised.Timestamp.Equal(critical.Timestamp) || *raised.Values.Pressure != 0.08 {
+		t.Fatalf("raise = %+v, %v, %v", raised, ok, err)
+	}
+	if _, ok, _ := s.RaiseSeverity(ctx, critical, alert.Event{Action: alert.Raise, Operator: "engine", At: critical.CreatedAt}); ok {
+		t.Error("raised an alert that is already critical")
+	}
+
+	// подписчик получает и вставку, и повышение
+	for _, want := range []alert.Severity{alert.Warning, alert.Critical} {
+		select {
+		case a := <-alerts:
+			if a.ID != warning.ID || a.Severity != want {
+				t.Fatalf("got %s %s, want %s", a.ID.Hex(), a.Severity, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s alert delivered", want)
+		}
+	}
+}
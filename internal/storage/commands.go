@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoCommandStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoCommandStore(coll *mongo.Collection) *MongoCommandStore {
+	return &MongoCommandStore{coll: coll}
+}
+
+func (s *MongoCommandStore) CreateCommand(ctx context.Context, c command.Command) error {
+	_, err := s.coll.InsertOne(ctx, c)
+	return err
+}
+
+func (s *MongoCommandStore) GetCommand(ctx context.Context, id string) (command.Command, error) {
+	var c command.Command
+	err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *MongoCommandStore) ClaimCommands(ctx context.Context, deviceID int, redeliverAfter time.Duration, limit int) ([]command.Command, error) {
+	var claimed []command.Command
+	for len(claimed) < limit {
+		now := time.Now().UTC()
+		filter := bson.M{
+			"device_id": deviceID,
+			"$or": bson.A{
+				bson.M{"status": command.StatusPending},
+				bson.M{"status": command.StatusDelivered, "delivered_at": bson.M{"$lt": now.Add(-redeliverAfter)}},
+			},
+		}
+		update := bson.M{
+			"$set": bson.M{"status": command.StatusDelivered, "delivered_at": now},
+			"$inc": bson.M{"attempts": 1},
+		}
+		opts := options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After)
+
+		// каждая команда забирается атомарно, чтобы два опроса не получили одну и ту же
+		var c command.Command
+		err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, c)
+	}
+	return claimed, nil
+}
+
+func (s *MongoCommandStore) AckCommand(ctx context.Context, deviceID int, id string, ack command.Ack) (command.Command, error) {
+	filter := bson.M{
+		"_id":       id,
+		"device_id": deviceID,
+		"status":    bson.M{"$nin": bson.A{command.StatusSucceeded, command.StatusFailed}},
+	}
+	set := bson.M{"status": ack.Status, "acked_at": time.Now().UTC()}
+	if ack.Error != "" {
+		set["error"] = ack.Error
+	}
+
+	var c command.Command
+	err := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&c)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return c, err
+	}
+
+	// уже подтверждена или чужая команда
+	err = s.coll.FindOne(ctx, bson.M{"_id": id, "device_id": deviceID}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// MemoryCommandStore — потокобезопасное хранилище команд в памяти
+type MemoryCommandStore struct {
+	mu       sync.Mutex
+	commands map[string]*command.Command
+}
+
+func NewMemoryCommandStore() *MemoryCommandStore {
+	return &MemoryCommandStore{commands: make(map[string]*command.Command)}
+}
+
+func (s *MemoryCommandStore) CreateCommand(_ context.Context, c command.Command) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[c.ID] = &c
+	return nil
+}
+
+func (s *MemoryCommandStore) GetCommand(_ context.Context, id string) (command.Command, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.commands[id]
+	if !ok {
+		return command.Command{}, ErrNotFound
+	}
+	return *c, nil
+}
+
+func (s *MemoryCommandStore) ClaimCommands(_ context.Context, deviceID int, redeliverAfter time.Duration, limit int) ([]command.Command, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var due []*command.Command
+	for _, c := range s.commands {
+		if c.DeviceID != deviceID {
+			continue
+		}
+		if c.Status == command.StatusPending ||
+			(c.Status == command.StatusDelivered && c.DeliveredAt.Before(now.Add(-redeliverAfter))) {
+			due = append(due, c)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]command.Command, 0, len(due))
+	for _, c := range due {
+		c.Status = command.StatusDelivered
+		c.DeliveredAt = &now
+		c.Attempts++
+		claimed = append(claimed, *c)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryCommandStore) AckCommand(_ context.Context, deviceID int, id string, ack command.Ack) (command.Command, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.commands[id]
+	if !ok || c.DeviceID != deviceID {
+		return command.Command{}, ErrNotFound
+	}
+	if !c.Status.Final() {
+		now := time.Now().UTC()
+		c.Status = ack.Status
+		c.Error = ack.Error
+		c.AckedAt = &now
+	}
+	return *c, nil
+}
+
+func (s *BoltStore) CreateCommand(_ context.Context, c command.Command) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putDoc(tx.Bucket(commandsBucket), []byte(c.ID), c)
+	})
+}
+
+func (s *BoltStore) GetCommand(_ context.Context, id string) (command.Command, error) {
+	var c command.Command
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getDoc(tx.Bucket(commandsBucket), []byte(id), &c)
+	})
+	return c, err
+}
+
+// ClaimCommands перебирает бакет целиком: незавершённых команд на одной станции немного
+func (s *BoltStore) ClaimCommands(_ context.Context, deviceID int, redeliverAfter time.Duration, limit int) ([]command.Command, error) {
+	now := time.Now().UTC()
+	var due []command.Command
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		commands := tx.Bucket(commandsBucket)
+		err := commands.ForEach(func(_, v []byte) error {
+			var c command.Command
+			if err := bson.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if c.DeviceID != deviceID {
+				return nil
+			}
+			if c.Status == command.StatusPending ||
+				(c.Status == command.StatusDelivered && c.DeliveredAt.Before(now.Add(-redeliverAfter))) {
+				due = append(due, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+		if len(due) > limit {
+			due = due[:limit]
+		}
+		for i := range due {
+			due[i].Status = command.StatusDelivered
+			due[i].DeliveredAt = &now
+			due[i].Attempts++
+			if err := putDoc(commands, []byte(due[i].ID), due[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if due == nil {
+		due = []command.Command{}
+	}
+	return due, nil
+}
+
+func (s *BoltStore) AckCommand(_ context.Context, deviceID int, id string, ack command.Ack) (command.Command, error) {
+	var c command.Command
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		commands := tx.Bucket(commandsBucket)
+		if err := getDoc(commands, []byte(id), &c); err != nil {
+			return err
+		}
+		if c.DeviceID != deviceID {
+			return ErrNotFound
+		}
+		if c.Status.Final() {
+			return nil
+		}
+		now := time.Now().UTC()
+		c.Status = ack.Status
+		c.Error = ack.Error
+		c.AckedAt = &now
+		return putDoc(commands, []byte(id), c)
+	})
+	if err != nil {
+		return command.Command{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryPacketStore — потокобезопасное хранилище пакетов в памяти
+type MemoryPacketStore struct {
+	mu      sync.RWMutex
+	packets map[int][]packet.Packet
+}
+
+func NewMemoryPacketStore() *MemoryPacketStore {
+	return &MemoryPacketStore{packets: make(map[int][]packet.Packet)}
+}
+
+func (s *MemoryPacketStore) InsertPacket(_ context.Context, p packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packets[p.DeviceID] = append(s.packets[p.DeviceID], p)
+	return nil
+}
+
+func (s *MemoryPacketStore) InsertPackets(_ context.Context, packets []packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range packets {
+		s.packets[p.DeviceID] = append(s.packets[p.DeviceID], p)
+	}
+	return nil
+}
+
+func (s *MemoryPacketStore) PacketTimes(_ context.Context, deviceID int, from, to time.Time) ([]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var times []time.Time
+	for _, p := range s.packets[deviceID] {
+		if t, err := time.Parse(time.RFC3339, p.Timestamp); err == nil && !t.Before(from) && !t.After(to) {
+			times = append(times, t)
+		}
+	}
+	return times, nil
+}
+
+func (s *MemoryPacketStore) RecentPackets(_ context.Context, deviceID int, until time.Time, n int) ([]packet.Packet, error) {
+	s.mu.RLock()
+	var packets []packet.Packet
+	for _, p := range s.packets[deviceID] {
+		if t, err := time.Parse(time.RFC3339, p.Timestamp); err == nil && !t.After(until) {
+			packets = append(packets, p)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortByTime(packets)
+	if len(packets) > n {
+		packets = packets[len(packets)-n:]
+	}
+	return packets, nil
+}
+
+// Packets возвращает все пакеты устройства в порядке вставки
+func (s *MemoryPacketStore) Packets(deviceID int) []packet.Packet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]packet.Packet(nil), s.packets[deviceID]...)
+}
+
+// MemoryAlertStore — потокобезопасное хранилище алертов в памяти
+type MemoryAlertStore struct {
+	mu       sync.Mutex
+	alerts   []alert.Alert
+	watchers map[*memAlertWatcher]struct{}
+}
+
+type memAlertWatcher struct {
+	filter AlertFilter
+	ch     chan alert.Alert
+}
+
+// memWatchBuffer — сколько алертов копится для медленного подписчика, дальше они теряются
+const memWatchBuffer = 256
+
+func NewMemoryAlertStore() *MemoryAlertStore {
+	return &MemoryAlertStore{}
+}
+
+func (s *MemoryAlertStore) InsertAlert(_ context.Context, a alert.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts = append(s.alerts, a)
+	s.publish(a)
+	return nil
+}
+
+// publish отдаёт алерт подписчикам; вызывается под s.mu
+func (s *MemoryAlertStore) publish(a alert.Alert) {
+	for w := range s.watchers {
+		if !w.filter.Match(a) {
+			continue
+		}
+		select {
+		case w.ch <- a:
+		default:
+			slog.Warn("memory alert watcher is full, alert dropped", "device_id", a.DeviceID)
+		}
+	}
+}
+
+func (s *MemoryAlertStore) WatchAlerts(ctx context.Context, filter AlertFilter) <-chan alert.Alert {
+	w := &memAlertWatcher{filter: filter, ch: make(chan alert.Alert, memWatchBuffer)}
+	s.mu.Lock()
+	if s.watchers == nil {
+		s.watchers = make(map[*memAlertWatcher]struct{})
+	}
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watchers, w)
+		close(w.ch)
+		s.mu.Unlock()
+	}()
+	return w.ch
+}
+
+func (s *MemoryAlertStore) GetAlert(_ context.Context, id string) (alert.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.alerts {
+		if a.ID.Hex() == id {
+			return a, nil
+		}
+	}
+	return alert.Alert{}, ErrNotFound
+}
+
+func (s *MemoryAlertStore) FindAlerts(_ context.Context, q AlertQuery) ([]alert.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findAlerts(s.alerts, q), nil
+}
+
+func (s *MemoryAlertStore) CountAlerts(ctx context.Context, q AlertQuery) (int64, error) {
+	q.Limit, q.Offset = 0, 0
+	found, _ := s.FindAlerts(ctx, q)
+	return int64(len(found)), nil
+}
+
+func (s *MemoryAlertStore) ApplyAlertEvent(_ context.Context, id string, e alert.Event) (alert.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.alerts {
+		if s.alerts[i].ID.Hex() != id {
+			continue
+		}
+		a := s.alerts[i]
+		// журнал копируется, чтобы не задеть уже выданные копии алерта
+		a.History = slices.Clone(a.History)
+		if err := a.Apply(e); err != nil {
+			return alert.Alert{}, err
+		}
+		s.alerts[i] = a
+		return a, nil
+	}
+	return alert.Alert{}, ErrNotFound
+}
+
+func (s *MemoryAlertStore) DueEscalations(_ context.Context, now time.Time, limit int) ([]alert.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return dueEscalations(s.alerts, now, limit), nil
+}
+
+func (s *MemoryAlertStore) AdvanceEscalation(_ context.Context, id primitive.ObjectID, step int, nextAt *time.Time, e alert.Event) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.alerts {
+		a := s.alerts[i]
+		if a.ID != id {
+			continue
+		}
+		if a.State != alert.Open || a.Escalation == nil || a.Escalation.Step != step {
+			return false, nil
+		}
+		a.Escalation = &alert.Escalation{Policy: a.Escalation.Policy, Since: a.Escalation.Since, Step: step + 1, NextAt: nextAt}
+		a.History = append(slices.Clone(a.History), e)
+		a.UpdatedAt = e.At
+		s.alerts[i] = a
+		return true, nil
+	}
+	return false, ErrNotFound
+}
+
+func (s *MemoryAlertStore) RaiseSeverity(_ context.Context, a alert.Alert, e alert.Event) (alert.Alert, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := -1
+	for i, prev := range s.alerts {
+		if raisable(prev, a) && (found < 0 || !prev.Timestamp.Before(s.alerts[found].Timestamp)) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return alert.Alert{}, false, nil
+	}
+	raised := raise(s.alerts[found], a, e)
+	s.alerts[found] = raised
+	s.publish(raised)
+	return raised, true, nil
+}
+
+// raisable сообщает, что важность открытого алерта prev можно поднять до важности a
+func raisable(prev, a alert.Alert) bool {
+	return prev.DeviceID == a.DeviceID && prev.Rule == a.Rule && prev.State == alert.Open &&
+		slices.Contains(a.Severity.Below(), prev.Severity)
+}
+
+// raise переносит в prev важность, время, показания и эскалацию алерта a
+func raise(prev, a alert.Alert, e alert.Event) alert.Alert {
+	prev.Severity = a.Severity
+	prev.Timestamp = a.Timestamp
+	prev.Values = a.Values
+	prev.Escalation = a.Escalation
+	prev.History = append(slices.Clone(prev.History), e)
+	prev.UpdatedAt = e.At
+	prev.RaisedAt = &e.At
+	return prev
+}
+
+// Alerts возвращает все алерты в порядке вставки
+func (s *MemoryAlertStore) Alerts() []alert.Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]alert.Alert(nil), s.alerts...)
+}
+
+// findAlerts отбирает алерты по запросу от новых к старым — поиск для хранилищ,
+// которые перебирают алерты целиком
+func findAlerts(alerts []alert.Alert, q AlertQuery) []alert.Alert {
+	found := []alert.Alert{}
+	for _, a := range alerts {
+		if q.Match(a) {
+			found = append(found, a)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Timestamp.After(found[j].Timestamp) })
+	found = found[min(q.Offset, len(found)):]
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found
+}
+
+// dueEscalations отбирает до limit открытых алертов, шаг эскалации которых наступил к now
+func dueEscalations(alerts []alert.Alert, now time.Time, limit int) []alert.Alert {
+	var due []alert.Alert
+	for _, a := range alerts {
+		if a.State == alert.Open && a.Escalation != nil && a.Escalation.NextAt != nil && !a.Escalation.NextAt.After(now) {
+			due = append(due, a)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Escalation.NextAt.Before(*due[j].Escalation.NextAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due
+}
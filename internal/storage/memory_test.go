@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+func TestMemoryPacketStoreRecentPackets(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryPacketStore()
+
+	// вставляем не по порядку времени
+	for _, sec := range []int{3, 1, 4, 0, 2} {
+		p := packet.Packet{DeviceID: 1, Timestamp: fmt.Sprintf("2025-01-01T00:00:0%dZ", sec), Pressure: float32(sec)}
+		if err := s.InsertPacket(ctx, p); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := s.InsertPacket(ctx, packet.Packet{DeviceID: 2, Timestamp: "2025-01-01T00:00:09Z"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	until := time.Date(2025, 1, 1, 0, 0, 9, 0, time.UTC)
+	got, err := s.RecentPackets(ctx, 1, until, 3)
+	if err != nil {
+		t.Fatalf("recent packets: %v", err)
+	}
+	want := []float32{2, 3, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.DeviceID != 1 || p.Pressure != want[i] {
+			t.Errorf("packet %d = %+v, want device 1 pressure %v", i, p, want[i])
+		}
+	}
+
+	got, err = s.RecentPackets(ctx, 1, until, 10)
+	if err != nil {
+		t.Fatalf("recent packets: %v", err)
+	}
+	if len(got) != 5 {
+		t.Errorf("got %d packets, want all 5", len(got))
+	}
+
+	// пакеты позже until не учитываются
+	got, err = s.RecentPackets(ctx, 1, time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC), 10)
+	if err != nil {
+		t.Fatalf("recent packets: %v", err)
+	}
+	if len(got) != 2 || got[1].Pressure != 1 {
+		t.Errorf("packets until 00:00:01 = %+v, want 2 packets ending with pressure 1", got)
+	}
+
+	got, err = s.RecentPackets(ctx, 3, until, 10)
+	if err != nil {
+		t.Fatalf("recent packets: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("unknown device: got %d packets, want 0", len(got))
+	}
+}
+
+func TestMemoryStoresConcurrent(t *testing.T) {
+	ctx := context.Background()
+	packets := NewMemoryPacketStore()
+	alerts := NewMemoryAlertStore()
+
+	const writers, perWriter = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				packets.InsertPacket(ctx, packet.Packet{DeviceID: w % 2, Timestamp: "2025-01-01T00:00:00Z"})
+				packets.RecentPackets(ctx, w%2, time.Now(), 10)
+				alerts.InsertAlert(ctx, alert.New(alert.PressureLow, w, time.Now(), alert.Values{}))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := len(packets.Packets(0)) + len(packets.Packets(1)); n != writers*perWriter {
+		t.Errorf("stored %d packets, want %d", n, writers*perWriter)
+	}
+	if n := len(alerts.Alerts()); n != writers*perWriter {
+		t.Errorf("stored %d alerts, want %d", n, writers*perWriter)
+	}
+}
+
+func TestMemoryAlertStoreWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewMemoryAlertStore()
+	alerts := s.WatchAlerts(ctx, AlertFilter{Devices: []int{2}, Types: []alert.Type{alert.Instant}})
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.InsertAlert(ctx, alert.New(alert.PressureLow, 1, at, alert.Values{}))
+	s.InsertAlert(ctx, alert.New(alert.RapidPressureIncrease, 2, at.Add(time.Second), alert.Values{Change: alert.Float(0.1)}))
+	s.InsertAlert(ctx, alert.New(alert.PressureHigh, 2, at.Add(2*time.Second), alert.Values{Pressure: alert.Float(0.08), Temperature: alert.Float(20)}))
+
+	select {
+	case a := <-alerts:
+		if a.DeviceID != 2 || a.Rule != alert.PressureHigh || a.Values.Pressure == nil || *a.Values.Pressure != 0.08 || a.Values.Change != nil {
+			t.Errorf("alert = %+v", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert delivered")
+	}
+
+	cancel()
+	for range alerts {
+		t.Error("unexpected alert after the filtered one")
+	}
+}
@@ -2,10 +2,17 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/tracing"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewMongoClient(uri string) (*mongo.Client, error) {
@@ -23,3 +30,136 @@ func NewMongoClient(uri string) (*mongo.Client, error) {
 
 	return client, nil
 }
+
+// startSpan открывает клиентский спан операции над коллекцией Mongo
+func startSpan(ctx context.Context, coll *mongo.Collection, op string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "mongodb "+op+" "+coll.Name(), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.namespace", coll.Database().Name()),
+			attribute.String("db.collection.name", coll.Name()),
+			attribute.String("db.operation.name", op),
+		))
+}
+
+// PacketDocument — пакет в time-series коллекции: время хранится как дата BSON
+type PacketDocument struct {
+	DeviceID    int       `bson:"device_id"`
+	Timestamp   time.Time `bson:"timestamp"`
+	Pressure    float32   `bson:"pressure"`
+	Temperature float32   `bson:"temperature"`
+}
+
+func (d PacketDocument) Packet() packet.Packet {
+	return packet.Packet{
+		DeviceID:    d.DeviceID,
+		Timestamp:   d.Timestamp.UTC().Format(time.RFC3339),
+		Pressure:    d.Pressure,
+		Temperature: d.Temperature,
+	}
+}
+
+type MongoPacketStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoPacketStore(coll *mongo.Collection) *MongoPacketStore {
+	return &MongoPacketStore{coll: coll}
+}
+
+func (s *MongoPacketStore) InsertPacket(ctx context.Context, p packet.Packet) (err error) {
+	ctx, span := startSpan(ctx, s.coll, "insert")
+	defer func() { tracing.End(span, err) }()
+
+	t, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		return fmt.Errorf("parse timestamp: %w", err)
+	}
+	_, err = s.coll.InsertOne(ctx, PacketDocument{
+		DeviceID:    p.DeviceID,
+		Timestamp:   t,
+		Pressure:    p.Pressure,
+		Temperature: p.Temperature,
+	})
+	return err
+}
+
+// InsertPackets вставляет пакеты одним InsertMany без упорядочивания
+func (s *MongoPacketStore) InsertPackets(ctx context.Context, packets []packet.Packet) error {
+	if len(packets) == 0 {
+		return nil
+	}
+	docs := make([]any, len(packets))
+	for i, p := range packets {
+		t, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil {
+			return fmt.Errorf("device %d: parse timestamp: %w", p.DeviceID, err)
+		}
+		docs[i] = PacketDocument{
+			DeviceID:    p.DeviceID,
+			Timestamp:   t,
+			Pressure:    p.Pressure,
+			Temperature: p.Temperature,
+		}
+	}
+	_, err := s.coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+func (s *MongoPacketStore) PacketTimes(ctx context.Context, deviceID int, from, to time.Time) ([]time.Time, error) {
+	filter := bson.M{
+		"device_id": deviceID,
+		"timestamp": bson.M{"$gte": from, "$lte": to},
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 0, "timestamp": 1})
+	cursor, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var times []time.Time
+	for cursor.Next(ctx) {
+		var doc struct {
+			Timestamp time.Time `bson:"timestamp"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		times = append(times, doc.Timestamp)
+	}
+	return times, cursor.Err()
+}
+
+func (s *MongoPacketStore) RecentPackets(ctx context.Context, deviceID int, until time.Time, n int) ([]packet.Packet, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(n))
+	filter := bson.M{
+		"device_id": deviceID,
+		"timestamp": bson.M{"$lte": until},
+	}
+	cursor, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []PacketDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	// собирали от новых к старым
+	recents := make([]packet.Packet, len(docs))
+	for i, d := range docs {
+		recents[len(docs)-1-i] = d.Packet()
+	}
+	return recents, nil
+}
+
+func sortByTime(packets []packet.Packet) {
+	sort.SliceStable(packets, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339, packets[i].Timestamp)
+		tj, _ := time.Parse(time.RFC3339, packets[j].Timestamp)
+		return ti.Before(tj)
+	})
+}
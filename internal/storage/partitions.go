@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// partitionsCollection хранит число партиций каждого exchange пакетов
+const partitionsCollection = "queue_partitions"
+
+// ErrPartitionMismatch возвращается CheckPartitions, если сервис настроен на другое
+// число партиций, чем остальные
+var ErrPartitionMismatch = errors.New("partition count mismatch")
+
+// CheckPartitions записывает число партиций exchange при первом запуске и сверяет его
+// при следующих. Контроллер и движок правил с разным числом партиций отправляли бы
+// пакеты одного устройства в разные очереди, нарушая порядок их обработки.
+func CheckPartitions(ctx context.Context, db *mongo.Database, exchange string, partitions int) error {
+	coll := db.Collection(partitionsCollection)
+	_, err := coll.UpdateOne(ctx,
+		bson.M{"_id": exchange},
+		bson.M{"$setOnInsert": bson.M{"partitions": partitions, "created_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	var doc struct {
+		Partitions int `bson:"partitions"`
+	}
+	if err := coll.FindOne(ctx, bson.M{"_id": exchange}).Decode(&doc); err != nil {
+		return err
+	}
+	if doc.Partitions != partitions {
+		return fmt.Errorf("%w: exchange %s has %d partitions, configured %d; "+
+			"to repartition stop all services, drain the queues and delete the document from %s",
+			ErrPartitionMismatch, exchange, doc.Partitions, partitions, partitionsCollection)
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection = "schema_migrations"
+	migrationLockID      = "lock"
+	migrationLockTTL     = 10 * time.Minute
+)
+
+// Schema — имена коллекций, которыми управляют миграции
+type Schema struct {
+	Packets  string
+	Alerts   string
+	Commands string
+	Silences string
+}
+
+// Migration — шаг изменения схемы. Шаги применяются строго по возрастанию Version,
+// применённые шаги записываются в schema_migrations и повторно не выполняются.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database, s Schema) error
+}
+
+// Migrations — история схемы. Новые шаги добавляются только в конец.
+var Migrations = []Migration{
+	{Version: 1, Name: "packets_timeseries", Up: createPacketsTimeSeries},
+	{Version: 2, Name: "packet_indexes", Up: createPacketIndexes},
+	{Version: 3, Name: "alert_indexes", Up: createAlertIndexes},
+	{Version: 4, Name: "command_indexes", Up: createCommandIndexes},
+	{Version: 5, Name: "rollup_collections", Up: createRollupCollections},
+	{Version: 6, Name: "normalize_alerts", Up: normalizeAlerts},
+	{Version: 7, Name: "alert_workflow_indexes", Up: createAlertWorkflowIndexes},
+	{Version: 8, Name: "silence_indexes", Up: createSilenceIndexes},
+	{Version: 9, Name: "escalation_indexes", Up: createEscalationIndexes},
+	{Version: 10, Name: "alert_raise_indexes", Up: createRaiseIndexes},
+}
+
+// Bootstrap приводит схему базы к актуальной версии. Безопасен при одновременном
+// запуске нескольких сервисов: миграции выполняются под блокировкой в schema_migrations.
+func Bootstrap(ctx context.Context, db *mongo.Database, s Schema) error {
+	coll := db.Collection(migrationsCollection)
+
+	owner := fmt.Sprintf("%d", time.Now().UnixNano())
+	if err := acquireMigrationLock(ctx, coll, owner); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer coll.DeleteOne(context.Background(), bson.M{"_id": migrationLockID, "owner": owner})
+
+	applied, err := appliedVersions(ctx, coll)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range Migrations {
+		if applied[m.Version] {
+			continue
+		}
+		slog.Info("applying schema migration", "version", m.Version, "name", m.Name)
+		start := time.Now()
+		if err := m.Up(ctx, db, s); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		_, err := coll.InsertOne(ctx, bson.M{"_id": m.Version, "name": m.Name, "applied_at": time.Now().UTC()})
+		if err != nil {
+			return fmt.Errorf("record migration %d: %w", m.Version, err)
+		}
+		slog.Info("schema migration applied", "version", m.Version, "name", m.Name, "duration", time.Since(start))
+	}
+	return nil
+}
+
+// acquireMigrationLock ждёт, пока блокировка свободна или просрочена, и захватывает её
+func acquireMigrationLock(ctx context.Context, coll *mongo.Collection, owner string) error {
+	for {
+		now := time.Now()
+		_, err := coll.UpdateOne(ctx,
+			bson.M{"_id": migrationLockID, "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(migrationLockTTL)}},
+			options.Update().SetUpsert(true))
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		slog.Info("waiting for schema migration lock")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func appliedVersions(ctx context.Context, coll *mongo.Collection) (map[int]bool, error) {
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		Version int `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(docs))
+	for _, d := range docs {
+		applied[d.Version] = true
+	}
+	return applied, nil
+}
+
+func collectionExists(ctx context.Context, db *mongo.Database, name string) (bool, error) {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": name})
+	return len(names) > 0, err
+}
+
+// createPacketsTimeSeries создаёт коллекцию пакетов как time-series. Если коллекция
+// уже существует как обычная (строковые метки времени), она переименовывается
+// в <name>_legacy, а данные копируются в новую коллекцию с преобразованием времени.
+// Старая коллекция не удаляется — её можно удалить вручную после проверки.
+// Если прошлый запуск упал после переименования, копирование продолжается
+// из <name>_legacy: уже перенесённые пакеты пропускаются.
+func createPacketsTimeSeries(ctx context.Context, db *mongo.Database, s Schema) error {
+	legacy := s.Packets + "_legacy"
+	exists, err := collectionExists(ctx, db, s.Packets)
+	if err != nil {
+		return err
+	}
+	legacyExists, err := collectionExists(ctx, db, legacy)
+	if err != nil {
+		return err
+	}
+
+	timeseries := false
+	if exists {
+		specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": s.Packets})
+		if err != nil {
+			return err
+		}
+		timeseries = len(specs) == 1 && specs[0].Type == "timeseries"
+	}
+
+	switch {
+	case timeseries && !legacyExists:
+		return nil
+	case timeseries:
+		// версия миграции не записана, значит копирование могло оборваться на середине
+		slog.Warn("resuming legacy packets copy", "collection", s.Packets, "legacy", legacy)
+		return copyLegacyPackets(ctx, db.Collection(legacy), db.Collection(s.Packets), true)
+	case exists && legacyExists:
+		return fmt.Errorf("both %s and %s exist and %s is not time-series, resolve manually", s.Packets, legacy, s.Packets)
+	case exists:
+		slog.Warn("converting packets collection to time-series", "collection", s.Packets, "legacy", legacy)
+		err = db.Client().Database("admin").RunCommand(ctx, bson.D{
+			{Key: "renameCollection", Value: db.Name() + "." + s.Packets},
+			{Key: "to", Value: db.Name() + "." + legacy},
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("rename legacy packets: %w", err)
+		}
+		legacyExists = true
+	case legacyExists:
+		slog.Warn("legacy packets found without packets collection, resuming conversion", "collection", s.Packets, "legacy", legacy)
+	}
+
+	opts := options.CreateCollection().SetTimeSeriesOptions(
+		options.TimeSeries().
+			SetTimeField("timestamp").
+			SetMetaField("device_id").
+			SetGranularity("seconds"))
+	if err := db.CreateCollection(ctx, s.Packets, opts); err != nil {
+		return fmt.Errorf("create time-series collection: %w", err)
+	}
+
+	if legacyExists {
+		return copyLegacyPackets(ctx, db.Collection(legacy), db.Collection(s.Packets), false)
+	}
+	return nil
+}
+
+// copyLegacyPackets переносит пакеты со строковым временем в time-series коллекцию.
+// С dedup пакеты, уже лежащие в to с тем же (device_id, timestamp), не вставляются повторно.
+func copyLegacyPackets(ctx context.Context, from, to *mongo.Collection, dedup bool) error {
+	cursor, err := from.Find(ctx, bson.M{}, options.Find().SetBatchSize(5000))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	const batchSize = 5000
+	var (
+		batch      []any
+		copied     int
+		skipped    int
+		duplicates int
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if dedup {
+			fresh, err := missingPackets(ctx, to, batch)
+			if err != nil {
+				return err
+			}
+			duplicates += len(batch) - len(fresh)
+			batch = fresh
+		}
+		if len(batch) > 0 {
+			if _, err := to.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+				return err
+			}
+		}
+		copied += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var old struct {
+			DeviceID    int     `bson:"device_id"`
+			Timestamp   string  `bson:"timestamp"`
+			Pressure    float32 `bson:"pressure"`
+			Temperature float32 `bson:"temperature"`
+		}
+		if err := cursor.Decode(&old); err != nil {
+			skipped++
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, old.Timestamp)
+		if err != nil {
+			skipped++
+			continue
+		}
+		batch = append(batch, PacketDocument{
+			DeviceID:    old.DeviceID,
+			Timestamp:   t,
+			Pressure:    old.Pressure,
+			Temperature: old.Temperature,
+		})
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	slog.Info("legacy packets copied", "copied", copied, "skipped", skipped, "duplicates", duplicates)
+	return nil
+}
+
+// missingPackets оставляет из batch только пакеты, которых ещё нет в coll
+func missingPackets(ctx context.Context, coll *mongo.Collection, batch []any) ([]any, error) {
+	type key struct {
+		DeviceID  int       `bson:"device_id"`
+		Timestamp time.Time `bson:"timestamp"`
+	}
+	ids := make(map[int]bool)
+	var from, to time.Time
+	for i, d := range batch {
+		p := d.(PacketDocument)
+		ids[p.DeviceID] = true
+		// Mongo хранит время с точностью до миллисекунд
+		t := p.Timestamp.Truncate(time.Millisecond)
+		if i == 0 || t.Before(from) {
+			from = t
+		}
+		if i == 0 || t.After(to) {
+			to = t
+		}
+	}
+	devices := make([]int, 0, len(ids))
+	for id := range ids {
+		devices = append(devices, id)
+	}
+
+	cursor, err := coll.Find(ctx,
+		bson.M{"device_id": bson.M{"$in": devices}, "timestamp": bson.M{"$gte": from, "$lte": to}},
+		options.Find().SetProjection(bson.M{"_id": 0, "device_id": 1, "timestamp": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	present := make(map[key]bool)
+	for cursor.Next(ctx) {
+		var k key
+		if err := cursor.Decode(&k); err != nil {
+			return nil, err
+		}
+		present[key{k.DeviceID, k.Timestamp.UTC()}] = true
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	fresh := batch[:0:0]
+	for _, d := range batch {
+		p := d.(PacketDocument)
+		if !present[key{p.DeviceID, p.Timestamp.Truncate(time.Millisecond).UTC()}] {
+			fresh = append(fresh, d)
+		}
+	}
+	return fresh, nil
+}
+
+func createPacketIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Packets).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "timestamp", Value: -1}},
+	})
+	return err
+}
+
+func createAlertIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Alerts).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "state", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	return err
+}
+
+// createAlertWorkflowIndexes — поиск подтверждённых алертов того же правила перед
+// уведомлением и выборка алертов оператора
+func createAlertWorkflowIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Alerts).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "rule", Value: 1}, {Key: "state", Value: 1}}},
+		{Keys: bson.D{{Key: "assignee", Value: 1}, {Key: "state", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+func createCommandIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Commands).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
+
+// createRollupCollections создаёт уникальный индекс, по которому $merge обновляет агрегаты
+func createRollupCollections(ctx context.Context, db *mongo.Database, s Schema) error {
+	for _, r := range Rollups {
+		_, err := db.Collection(RollupCollection(s.Packets, r)).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "device_id", Value: 1}, {Key: "bucket", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+const rollupTTLIndex = "bucket_ttl"
+
+// ApplyRetention приводит сроки хранения к конфигу: для сырых пакетов — через
+// expireAfterSeconds time-series коллекции, для агрегатов — через TTL-индекс по bucket.
+// Вызывается при каждом старте, так как сроки задаются конфигом, а не миграциями.
+func ApplyRetention(ctx context.Context, db *mongo.Database, s Schema, rt Retention) error {
+	var expire any = "off"
+	if d := rt[Raw]; d > 0 {
+		expire = int64(d.Seconds())
+	}
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: s.Packets},
+		{Key: "expireAfterSeconds", Value: expire},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("raw retention: %w", err)
+	}
+
+	for _, r := range Rollups {
+		if err := applyRollupTTL(ctx, db, RollupCollection(s.Packets, r), rt[r]); err != nil {
+			return fmt.Errorf("%s retention: %w", r.Name, err)
+		}
+	}
+	slog.Info("retention applied", "raw", rt[Raw], "1m", rt[Minutely], "1h", rt[Hourly], "1d", rt[Daily])
+	return nil
+}
+
+func applyRollupTTL(ctx context.Context, db *mongo.Database, name string, d time.Duration) error {
+	coll := db.Collection(name)
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+
+	var current *mongo.IndexSpecification
+	for _, spec := range specs {
+		if spec.Name == rollupTTLIndex {
+			current = spec
+		}
+	}
+
+	seconds := int32(d.Seconds())
+	switch {
+	case d == 0 && current == nil:
+		return nil
+	case d == 0:
+		_, err := coll.Indexes().DropOne(ctx, rollupTTLIndex)
+		return err
+	case current == nil:
+		_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "bucket", Value: 1}},
+			Options: options.Index().SetName(rollupTTLIndex).SetExpireAfterSeconds(seconds),
+		})
+		return err
+	case current.ExpireAfterSeconds == nil || *current.ExpireAfterSeconds != seconds:
+		return db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: name},
+			{Key: "index", Value: bson.M{"name": rollupTTLIndex, "expireAfterSeconds": seconds}},
+		}).Err()
+	}
+	return nil
+}
+
+// ErrSchemaOutdated возвращается CheckSchema, если в базе применены не все миграции
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// CheckSchema проверяет, что все миграции применены, не меняя базу.
+// Нужен сервисам, которые только читают данные.
+func CheckSchema(ctx context.Context, db *mongo.Database) error {
+	applied, err := appliedVersions(ctx, db.Collection(migrationsCollection))
+	if err != nil {
+		return err
+	}
+	for _, m := range Migrations {
+		if !applied[m.Version] {
+			return fmt.Errorf("%w: migration %d %s not applied", ErrSchemaOutdated, m.Version, m.Name)
+		}
+	}
+	return nil
+}
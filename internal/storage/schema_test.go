@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	seen := make(map[int]bool)
+	prev := 0
+	for _, m := range Migrations {
+		if m.Version <= prev {
+			t.Errorf("migration %d %s is not after %d", m.Version, m.Name, prev)
+		}
+		if seen[m.Version] {
+			t.Errorf("duplicate migration version %d", m.Version)
+		}
+		if m.Up == nil || m.Name == "" {
+			t.Errorf("migration %d is incomplete", m.Version)
+		}
+		seen[m.Version] = true
+		prev = m.Version
+	}
+}
+
+func TestPacketDocumentRoundTrip(t *testing.T) {
+	doc := PacketDocument{
+		DeviceID:    4,
+		Timestamp:   time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+		Pressure:    0.05,
+		Temperature: 20,
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// время должно храниться датой BSON, иначе time-series коллекция отклонит документ
+	if typ := bson.Raw(raw).Lookup("timestamp").Type; typ != bson.TypeDateTime {
+		t.Errorf("timestamp stored as %s, want datetime", typ)
+	}
+
+	var back PacketDocument
+	if err := bson.Unmarshal(raw, &back); err != nil {
+		t.Fatal(err)
+	}
+	if p := back.Packet(); p.Timestamp != "2025-03-01T10:00:00Z" || p.DeviceID != 4 {
+		t.Errorf("packet = %+v", p)
+	}
+}
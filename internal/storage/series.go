@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Resolution — разрешение временного ряда. Step == 0 означает сырые пакеты.
+type Resolution struct {
+	Name string
+	Step time.Duration
+}
+
+var (
+	Raw       = Resolution{Name: "raw"}
+	Minutely  = Resolution{Name: "1m", Step: time.Minute}
+	Hourly    = Resolution{Name: "1h", Step: time.Hour}
+	Daily     = Resolution{Name: "1d", Step: 24 * time.Hour}
+	Rollups   = []Resolution{Minutely, Hourly, Daily}
+	AllLevels = []Resolution{Raw, Minutely, Hourly, Daily}
+)
+
+// ParseResolution возвращает разрешение по имени
+func ParseResolution(name string) (Resolution, error) {
+	for _, r := range AllLevels {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return Resolution{}, fmt.Errorf("unknown resolution %q", name)
+}
+
+// RollupCollection возвращает имя коллекции агрегатов для коллекции пакетов
+func RollupCollection(packets string, r Resolution) string {
+	return packets + "_" + r.Name
+}
+
+// Stats — агрегаты метрики за интервал
+type Stats struct {
+	Min  float64 `json:"min" bson:"min"`
+	Max  float64 `json:"max" bson:"max"`
+	Avg  float64 `json:"avg" bson:"avg"`
+	Last float64 `json:"last" bson:"last"`
+}
+
+// Point — точка временного ряда: начало интервала и агрегаты за него.
+// Для сырых данных интервал состоит из одного пакета.
+type Point struct {
+	Timestamp   time.Time `json:"timestamp" bson:"bucket"`
+	Count       int       `json:"count" bson:"count"`
+	Pressure    Stats     `json:"pressure" bson:"pressure"`
+	Temperature Stats     `json:"temperature" bson:"temperature"`
+}
+
+type SeriesReader interface {
+	// ReadSeries возвращает до limit точек устройства за [from, to) по возрастанию времени
+	ReadSeries(ctx context.Context, deviceID int, r Resolution, from, to time.Time, limit int) ([]Point, error)
+}
+
+// Retention — срок хранения для каждого разрешения, 0 — бессрочно
+type Retention map[Resolution]time.Duration
+
+// Covers сообщает, хранятся ли данные разрешения r начиная с from
+func (rt Retention) Covers(r Resolution, from, now time.Time) bool {
+	d := rt[r]
+	return d == 0 || !from.Before(now.Add(-d))
+}
+
+// ChooseResolution выбирает самое подробное разрешение, которое ещё хранится за весь
+// период и даёт не больше maxPoints точек. Сырые данные отдаются только для
+// периодов не длиннее rawMaxRange: частота пакетов заранее не известна.
+func ChooseResolution(from, to, now time.Time, rt Retention, maxPoints int, rawMaxRange time.Duration) Resolution {
+	span := to.Sub(from)
+	if span <= rawMaxRange && rt.Covers(Raw, from, now) {
+		return Raw
+	}
+	for _, r := range Rollups {
+		if int(span/r.Step) <= maxPoints && rt.Covers(r, from, now) {
+			return r
+		}
+	}
+	return Daily
+}
+
+// MongoSeriesStore читает сырые пакеты и агрегаты и строит агрегаты из пакетов
+type MongoSeriesStore struct {
+	db      *mongo.Database
+	packets string
+}
+
+func NewMongoSeriesStore(db *mongo.Database, packets string) *MongoSeriesStore {
+	return &MongoSeriesStore{db: db, packets: packets}
+}
+
+func (s *MongoSeriesStore) ReadSeries(ctx context.Context, deviceID int, r Resolution, from, to time.Time, limit int) ([]Point, error) {
+	if r == Raw {
+		return s.readRaw(ctx, deviceID, from, to, limit)
+	}
+
+	filter := bson.M{"device_id": deviceID, "bucket": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().SetSort(bson.D{{Key: "bucket", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.db.Collection(RollupCollection(s.packets, r)).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	points := []Point{}
+	if err := cursor.All(ctx, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (s *MongoSeriesStore) readRaw(ctx context.Context, deviceID int, from, to time.Time, limit int) ([]Point, error) {
+	filter := bson.M{"device_id": deviceID, "timestamp": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.db.Collection(s.packets).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	points := []Point{}
+	for cursor.Next(ctx) {
+		var d PacketDocument
+		if err := cursor.Decode(&d); err != nil {
+			return nil, err
+		}
+		points = append(points, rawPoint(d))
+	}
+	return points, cursor.Err()
+}
+
+func rawPoint(d PacketDocument) Point {
+	p, t := float64(d.Pressure), float64(d.Temperature)
+	return Point{
+		Timestamp:   d.Timestamp,
+		Count:       1,
+		Pressure:    Stats{Min: p, Max: p, Avg: p, Last: p},
+		Temperature: Stats{Min: t, Max: t, Avg: t, Last: t},
+	}
+}
+
+// Rollup пересчитывает агрегаты разрешения r за [from, to) из сырых пакетов.
+// Повторный запуск за тот же период перезаписывает агрегаты, поэтому безопасен.
+func (s *MongoSeriesStore) Rollup(ctx context.Context, r Resolution, from, to time.Time) error {
+	return s.rollup(ctx, r, bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}})
+}
+
+// RollupDevice пересчитывает агрегаты одного устройства. Границы периода должны
+// совпадать с границами интервалов r, иначе крайние агрегаты перезапишутся неполными.
+func (s *MongoSeriesStore) RollupDevice(ctx context.Context, deviceID int, r Resolution, from, to time.Time) error {
+	return s.rollup(ctx, r, bson.M{"device_id": deviceID, "timestamp": bson.M{"$gte": from, "$lt": to}})
+}
+
+func (s *MongoSeriesStore) rollup(ctx context.Context, r Resolution, match bson.M) error {
+	unit, binSize := "minute", int(r.Step/time.Minute)
+	switch {
+	case r.Step%(24*time.Hour) == 0:
+		unit, binSize = "day", int(r.Step/(24*time.Hour))
+	case r.Step%time.Hour == 0:
+		unit, binSize = "hour", int(r.Step/time.Hour)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"device_id": "$device_id",
+				"bucket": bson.M{"$dateTrunc": bson.M{
+					"date": "$timestamp", "unit": unit, "binSize": binSize,
+				}},
+			},
+			"count":  bson.M{"$sum": 1},
+			"p_min":  bson.M{"$min": "$pressure"},
+			"p_max":  bson.M{"$max": "$pressure"},
+			"p_avg":  bson.M{"$avg": "$pressure"},
+			"p_last": bson.M{"$last": "$pressure"},
+			"t_min":  bson.M{"$min": "$temperature"},
+			"t_max":  bson.M{"$max": "$temperature"},
+			"t_avg":  bson.M{"$avg": "$temperature"},
+			"t_last": bson.M{"$last": "$temperature"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"device_id":   "$_id.device_id",
+			"bucket":      "$_id.bucket",
+			"count":       1,
+			"pressure":    bson.M{"min": "$p_min", "max": "$p_max", "avg": "$p_avg", "last": "$p_last"},
+			"temperature": bson.M{"min": "$t_min", "max": "$t_max", "avg": "$t_avg", "last": "$t_last"},
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           RollupCollection(s.packets, r),
+			"on":             bson.A{"device_id", "bucket"},
+			"whenMatched":    "replace",
+			"whenNotMatched": "insert",
+		}}},
+	}
+	cursor, err := s.db.Collection(s.packets).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
+
+// ReadSeries строит ряд из пакетов в памяти; агрегаты считаются на лету
+func (s *MemoryPacketStore) ReadSeries(_ context.Context, deviceID int, r Resolution, from, to time.Time, limit int) ([]Point, error) {
+	s.mu.RLock()
+	var docs []PacketDocument
+	for _, p := range s.packets[deviceID] {
+		t, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		docs = append(docs, PacketDocument{DeviceID: p.DeviceID, Timestamp: t, Pressure: p.Pressure, Temperature: p.Temperature})
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].Timestamp.Before(docs[j].Timestamp) })
+	return seriesPoints(docs, r, limit), nil
+}
+
+// ReadSeries строит ряд из пакетов файла; агрегаты считаются на лету
+func (s *BoltStore) ReadSeries(_ context.Context, deviceID int, r Resolution, from, to time.Time, limit int) ([]Point, error) {
+	var docs []PacketDocument
+	err := s.db.View(func(tx *bolt.Tx) error {
+		device := tx.Bucket(packetsBucket).Bucket([]byte(strconv.Itoa(deviceID)))
+		if device == nil {
+			return nil
+		}
+		c := device.Cursor()
+		bound := packetKey(to, 0)
+		for k, v := c.Seek(packetKey(from, 0)); k != nil && bytes.Compare(k, bound) < 0; k, v = c.Next() {
+			var p packet.Packet
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			t, err := time.Parse(time.RFC3339, p.Timestamp)
+			if err != nil {
+				continue
+			}
+			docs = append(docs, PacketDocument{DeviceID: p.DeviceID, Timestamp: t, Pressure: p.Pressure, Temperature: p.Temperature})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seriesPoints(docs, r, limit), nil
+}
+
+// seriesPoints собирает ряд разрешения r из упорядоченных по времени пакетов
+func seriesPoints(docs []PacketDocument, r Resolution, limit int) []Point {
+	points := []Point{}
+	for _, d := range docs {
+		if r == Raw {
+			points = append(points, rawPoint(d))
+			continue
+		}
+		bucket := d.Timestamp.Truncate(r.Step)
+		if n := len(points); n > 0 && points[n-1].Timestamp.Equal(bucket) {
+			points[n-1] = merge(points[n-1], d)
+			continue
+		}
+		pt := rawPoint(d)
+		pt.Timestamp = bucket
+		points = append(points, pt)
+	}
+	if len(points) > limit {
+		points = points[:limit]
+	}
+	return points
+}
+
+func merge(pt Point, d PacketDocument) Point {
+	add := func(s Stats, v float64, n int) Stats {
+		return Stats{
+			Min:  min(s.Min, v),
+			Max:  max(s.Max, v),
+			Avg:  s.Avg + (v-s.Avg)/float64(n),
+			Last: v,
+		}
+	}
+	pt.Count++
+	pt.Pressure = add(pt.Pressure, float64(d.Pressure), pt.Count)
+	pt.Temperature = add(pt.Temperature, float64(d.Temperature), pt.Count)
+	return pt
+}
+
+// MongoRollupState хранит для каждого разрешения момент, до которого агрегаты посчитаны
+type MongoRollupState struct {
+	coll *mongo.Collection
+}
+
+func NewMongoRollupState(coll *mongo.Collection) *MongoRollupState {
+	return &MongoRollupState{coll: coll}
+}
+
+// Watermark возвращает ok == false, если агрегаты разрешения ещё не считались
+func (s *MongoRollupState) Watermark(ctx context.Context, r Resolution) (time.Time, bool, error) {
+	var doc struct {
+		Until time.Time `bson:"until"`
+	}
+	err := s.coll.FindOne(ctx, bson.M{"_id": r.Name}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, false, nil
+	}
+	return doc.Until, err == nil, err
+}
+
+func (s *MongoRollupState) SetWatermark(ctx context.Context, r Resolution, until time.Time) error {
+	_, err := s.coll.UpdateOne(ctx,
+		bson.M{"_id": r.Name},
+		bson.M{"$set": bson.M{"until": until, "updated_at": time.Now().UTC()}},
+		options.Update().SetUpsert(true))
+	return err
+}
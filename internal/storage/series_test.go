@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+func TestChooseResolution(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	rt := Retention{Raw: 30 * 24 * time.Hour, Minutely: 90 * 24 * time.Hour, Hourly: 0, Daily: 0}
+
+	tests := []struct {
+		name string
+		from time.Duration // до now
+		want Resolution
+	}{
+		{"last hour", time.Hour, Raw},
+		{"last day", 24 * time.Hour, Minutely},
+		{"last week", 7 * 24 * time.Hour, Hourly},
+		{"last year", 365 * 24 * time.Hour, Daily},
+		// сырые данные за 40 дней назад уже удалены, хотя период короткий
+		{"short range beyond raw retention", 40 * 24 * time.Hour, Minutely},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := now.Add(-tt.from)
+			to := now
+			if tt.name == "short range beyond raw retention" {
+				to = from.Add(time.Hour)
+			}
+			if got := ChooseResolution(from, to, now, rt, 2000, 6*time.Hour); got != tt.want {
+				t.Errorf("got %s, want %s", got.Name, tt.want.Name)
+			}
+		})
+	}
+}
+
+func TestMemoryReadSeries(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryPacketStore()
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	values := []struct {
+		at       time.Duration
+		pressure float32
+	}{
+		{0, 0.05}, {20 * time.Second, 0.07}, {40 * time.Second, 0.06},
+		{70 * time.Second, 0.04},
+	}
+	for _, v := range values {
+		s.InsertPacket(ctx, packet.Packet{DeviceID: 1, Timestamp: base.Add(v.at).Format(time.RFC3339), Pressure: v.pressure, Temperature: 20})
+	}
+
+	points, err := s.ReadSeries(ctx, 1, Minutely, base, base.Add(time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2", len(points))
+	}
+
+	p := points[0]
+	if !p.Timestamp.Equal(base) || p.Count != 3 {
+		t.Errorf("first bucket = %s count %d", p.Timestamp, p.Count)
+	}
+	near := func(a, b float64) bool { return a-b < 1e-6 && b-a < 1e-6 }
+	if !near(p.Pressure.Min, 0.05) || !near(p.Pressure.Max, 0.07) || !near(p.Pressure.Avg, 0.06) || !near(p.Pressure.Last, 0.06) {
+		t.Errorf("pressure stats = %+v", p.Pressure)
+	}
+
+	raw, _ := s.ReadSeries(ctx, 1, Raw, base, base.Add(time.Minute), 100)
+	if len(raw) != 3 || raw[1].Count != 1 {
+		t.Errorf("raw points = %+v", raw)
+	}
+}
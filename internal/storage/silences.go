@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/silence"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// silenceTTL — сколько закончившаяся тишина хранится для разбора, потом её удаляет TTL-индекс
+const silenceTTL = 30 * 24 * time.Hour
+
+type SilenceStore interface {
+	CreateSilence(ctx context.Context, s silence.Silence) error
+	GetSilence(ctx context.Context, id string) (silence.Silence, error)
+	// Silences возвращает тишины, не закончившиеся к моменту at, по времени начала
+	Silences(ctx context.Context, at time.Time) ([]silence.Silence, error)
+	// ExpireSilence досрочно завершает тишину в момент at
+	ExpireSilence(ctx context.Context, id string, at time.Time) (silence.Silence, error)
+}
+
+type MongoSilenceStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoSilenceStore(coll *mongo.Collection) *MongoSilenceStore {
+	return &MongoSilenceStore{coll: coll}
+}
+
+func (s *MongoSilenceStore) CreateSilence(ctx context.Context, sl silence.Silence) error {
+	_, err := s.coll.InsertOne(ctx, sl)
+	return err
+}
+
+func (s *MongoSilenceStore) GetSilence(ctx context.Context, id string) (silence.Silence, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return silence.Silence{}, ErrNotFound
+	}
+	var sl silence.Silence
+	err = s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&sl)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return silence.Silence{}, ErrNotFound
+	}
+	return sl, err
+}
+
+func (s *MongoSilenceStore) Silences(ctx context.Context, at time.Time) ([]silence.Silence, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "starts_at", Value: 1}})
+	cursor, err := s.coll.Find(ctx, bson.M{"ends_at": bson.M{"$gt": at}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	silences := []silence.Silence{}
+	if err := cursor.All(ctx, &silences); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+func (s *MongoSilenceStore) ExpireSilence(ctx context.Context, id string, at time.Time) (silence.Silence, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return silence.Silence{}, ErrNotFound
+	}
+	var sl silence.Silence
+	err = s.coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": oid, "ends_at": bson.M{"$gt": at}},
+		bson.M{"$set": bson.M{"ends_at": at.UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&sl)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// уже закончилась или не существует
+		return s.GetSilence(ctx, id)
+	}
+	return sl, err
+}
+
+func createSilenceIndexes(ctx context.Context, db *mongo.Database, s Schema) error {
+	_, err := db.Collection(s.Silences).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ends_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(silenceTTL.Seconds())),
+	})
+	return err
+}
+
+// MemorySilenceStore — потокобезопасное хранилище тишин в памяти
+type MemorySilenceStore struct {
+	mu       sync.Mutex
+	silences []silence.Silence
+}
+
+func NewMemorySilenceStore() *MemorySilenceStore {
+	return &MemorySilenceStore{}
+}
+
+func (s *MemorySilenceStore) CreateSilence(_ context.Context, sl silence.Silence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silences = append(s.silences, sl)
+	return nil
+}
+
+func (s *MemorySilenceStore) GetSilence(_ context.Context, id string) (silence.Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sl := range s.silences {
+		if sl.ID.Hex() == id {
+			return sl, nil
+		}
+	}
+	return silence.Silence{}, ErrNotFound
+}
+
+func (s *MemorySilenceStore) Silences(_ context.Context, at time.Time) ([]silence.Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := []silence.Silence{}
+	for _, sl := range s.silences {
+		if sl.EndsAt.After(at) {
+			found = append(found, sl)
+		}
+	}
+	return found, nil
+}
+
+func (s *MemorySilenceStore) ExpireSilence(_ context.Context, id string, at time.Time) (silence.Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.silences {
+		if s.silences[i].ID.Hex() != id {
+			continue
+		}
+		if s.silences[i].EndsAt.After(at) {
+			s.silences[i].EndsAt = at.UTC()
+		}
+		return s.silences[i], nil
+	}
+	return silence.Silence{}, ErrNotFound
+}
+
+// SilenceCache держит в памяти незакончившиеся тишины и перечитывает их раз в
+// интервал, чтобы движок не обращался к бд на каждый пакет. Новая тишина начинает
+// действовать в движке не позже чем через интервал обновления.
+type SilenceCache struct {
+	store    SilenceStore
+	mu       sync.RWMutex
+	silences []silence.Silence
+}
+
+func NewSilenceCache(store SilenceStore) *SilenceCache {
+	return &SilenceCache{store: store}
+}
+
+func (c *SilenceCache) Refresh(ctx context.Context) error {
+	silences, err := c.store.Silences(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.silences = silences
+	c.mu.Unlock()
+	return nil
+}
+
+// Run обновляет кэш до отмены ctx. При ошибке чтения остаётся прежний набор.
+func (c *SilenceCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, interval)
+		if err := c.Refresh(refreshCtx); err != nil && ctx.Err() == nil {
+			slog.Error("refresh silences error", "err", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Match возвращает первую тишину, под которую попадает алерт
+func (c *SilenceCache) Match(a alert.Alert, groups map[string][]int) (silence.Silence, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, sl := range c.silences {
+		if sl.Matches(a, groups) {
+			return sl, true
+		}
+	}
+	return silence.Silence{}, false
+}
+
+func (s *BoltStore) CreateSilence(_ context.Context, sl silence.Silence) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putDoc(tx.Bucket(silencesBucket), sl.ID[:], sl)
+	})
+}
+
+func (s *BoltStore) GetSilence(_ context.Context, id string) (silence.Silence, error) {
+	var sl silence.Silence
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return sl, ErrNotFound
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return getDoc(tx.Bucket(silencesBucket), oid[:], &sl)
+	})
+	return sl, err
+}
+
+func (s *BoltStore) Silences(_ context.Context, at time.Time) ([]silence.Silence, error) {
+	found := []silence.Silence{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(silencesBucket).ForEach(func(_, v []byte) error {
+			var sl silence.Silence
+			if err := bson.Unmarshal(v, &sl); err != nil {
+				return err
+			}
+			if sl.EndsAt.After(at) {
+				found = append(found, sl)
+			}
+			return nil
+		})
+	})
+	return found, err
+}
+
+func (s *BoltStore) ExpireSilence(_ context.Context, id string, at time.Time) (silence.Silence, error) {
+	var sl silence.Silence
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return sl, ErrNotFound
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		silences := tx.Bucket(silencesBucket)
+		if err := getDoc(silences, oid[:], &sl); err != nil {
+			return err
+		}
+		if !sl.EndsAt.After(at) {
+			return nil
+		}
+		sl.EndsAt = at.UTC()
+		return putDoc(silences, oid[:], sl)
+	})
+	return sl, err
+}
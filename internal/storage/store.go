@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"github.com/pochkachaiki/iot4gds/internal/models/command"
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+)
+
+type PacketStore interface {
+	InsertPacket(ctx context.Context, p packet.Packet) error
+	// RecentPackets возвращает не более n последних пакетов устройства с временем не позже until
+	// в порядке возрастания времени
+	RecentPackets(ctx context.Context, deviceID int, until time.Time, n int) ([]packet.Packet, error)
+}
+
+// BulkPacketStore — пакетная загрузка истории
+type BulkPacketStore interface {
+	InsertPackets(ctx context.Context, packets []packet.Packet) error
+	// PacketTimes возвращает времена уже сохранённых пакетов устройства в интервале [from, to]
+	PacketTimes(ctx context.Context, deviceID int, from, to time.Time) ([]time.Time, error)
+}
+
+type AlertStore interface {
+	InsertAlert(ctx context.Context, a alert.Alert) error
+}
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrConflict — документ менялся параллельно и обновление не удалось применить
+	ErrConflict = errors.New("concurrent update")
+)
+
+type CommandStore interface {
+	CreateCommand(ctx context.Context, c command.Command) error
+	GetCommand(ctx context.Context, id string) (command.Command, error)
+	// ClaimCommands помечает выданными и возвращает до limit команд устройства: новые и
+	// выданные раньше redeliverAfter назад, но не подтверждённые. Порядок — по времени создания.
+	ClaimCommands(ctx context.Context, deviceID int, redeliverAfter time.Duration, limit int) ([]command.Command, error)
+	// AckCommand сохраняет результат выполнения и возвращает обновлённую команду.
+	// Повторное подтверждение уже завершённой команды не меняет её.
+	AckCommand(ctx context.Context, deviceID int, id string, ack command.Ack) (command.Command, error)
+}
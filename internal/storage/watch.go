@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/alert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// коды ошибок сервера: change stream без реплика-сета и устаревший resume token
+	errCodeNotReplicaSet      = 40573
+	errCodeHistoryLost        = 286
+	errCodeInvalidResumeToken = 260
+
+	watchBatch        = 500
+	tokenSaveInterval = time.Second
+	maxWatchBackoff   = 30 * time.Second
+)
+
+// AlertFilter отбирает алерты для подписчика. Пустое поле — без ограничения.
+type AlertFilter struct {
+	Devices    []int
+	Types      []alert.Type
+	Rules      []alert.Rule
+	Severities []alert.Severity
+}
+
+func (f AlertFilter) Match(a alert.Alert) bool {
+	return AlertQuery{Devices: f.Devices, Types: f.Types, Rules: f.Rules, Severities: f.Severities}.Match(a)
+}
+
+// query — условие на документ алерта; prefix нужен для fullDocument в change stream
+func (f AlertFilter) query(prefix string) bson.M {
+	q := bson.M{}
+	for field, cond := range (AlertQuery{Devices: f.Devices, Types: f.Types, Rules: f.Rules, Severities: f.Severities}).filter() {
+		q[prefix+field] = cond
+	}
+	return q
+}
+
+// AlertSource — поток новых и повышенных алертов. Канал закрывается при отмене ctx.
+type AlertSource interface {
+	WatchAlerts(ctx context.Context, filter AlertFilter) <-chan alert.Alert
+}
+
+// AlertWatcher следит за вставками в коллекцию алертов и повышениями важности
+// (RaiseSeverity) через change stream. На standalone Mongo без реплика-сета change
+// stream недоступен, и тогда коллекция опрашивается по возрастанию _id и raised_at.
+//
+// Именованный подписчик (Consumer) сохраняет позицию в коллекции tokens и после
+// перезапуска продолжает с неё — доставка «хотя бы один раз». Безымянный получает
+// только алерты, появившиеся после подписки.
+type AlertWatcher struct {
+	alerts       *mongo.Collection
+	tokens       *mongo.Collection
+	consumer     string
+	pollInterval time.Duration
+}
+
+func NewAlertWatcher(alerts, tokens *mongo.Collection, pollInterval time.Duration) *AlertWatcher {
+	return &AlertWatcher{alerts: alerts, tokens: tokens, pollInterval: pollInterval}
+}
+
+// Consumer возвращает наблюдателя, сохраняющего позицию под именем name
+func (w *AlertWatcher) Consumer(name string) *AlertWatcher {
+	c := *w
+	c.consumer = name
+	return &c
+}
+
+type watchPosition struct {
+	ID        string             `bson:"_id"`
+	Token     bson.Raw           `bson:"token,omitempty"`
+	LastID    primitive.ObjectID `bson:"last_id,omitempty"`
+	RaisedAt  time.Time          `bson:"raised_at,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+func (w *AlertWatcher) WatchAlerts(ctx context.Context, filter AlertFilter) <-chan alert.Alert {
+	out := make(chan alert.Alert)
+	go func() {
+		defer close(out)
+		w.run(ctx, filter, out)
+	}()
+	return out
+}
+
+func (w *AlertWatcher) run(ctx context.Context, filter AlertFilter, out chan<- alert.Alert) {
+	log := slog.With("consumer", w.consumer)
+
+	replicaSet, err := w.replicaSet(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Warn("alert watcher: topology check failed, assuming replica set", "err", err)
+		replicaSet = true
+	}
+
+	// позиция живёт в памяти между переподключениями и сохраняется для именованного подписчика
+	var pos *watchPosition
+	backoff := time.Second
+	for ctx.Err() == nil {
+		if pos == nil {
+			pos, err = w.position(ctx)
+		}
+		switch {
+		case pos == nil:
+			// позиция подписчика не прочиталась, повторяем после паузы
+		case replicaSet:
+			err = w.stream(ctx, filter, pos, out)
+			var se mongo.ServerError
+			if errors.As(err, &se) && se.HasErrorCode(errCodeNotReplicaSet) {
+				log.Info("alert watcher: change streams unavailable, falling back to polling")
+				replicaSet = false
+				continue
+			}
+		default:
+			err = w.poll(ctx, filter, pos, out)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error("alert watcher error", "err", err, "retry_in", backoff)
+		} else {
+			// поток закрыт сервером, например после удаления коллекции
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxWatchBackoff)
+	}
+}
+
+// replicaSet определяет, поддерживает ли сервер change streams
+func (w *AlertWatcher) replicaSet(ctx context.Context) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	admin := w.alerts.Database().Client().Database("admin")
+	if err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+func (w *AlertWatcher) stream(ctx context.Context, filter AlertFilter, pos *watchPosition, out chan<- alert.Alert) error {
+	match := filter.query("fullDocument.")
+	// raised_at меняет только RaiseSeverity
+	match["$or"] = bson.A{
+		bson.M{"operationType": "insert"},
+		bson.M{"operationType": "update", "updateDescription.updatedFields.raised_at": bson.M{"$exists": true}},
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if pos.Token != nil {
+		opts.SetResumeAfter(pos.Token)
+	}
+	cs, err := w.alerts.Watch(ctx, pipeline, opts)
+	var se mongo.ServerError
+	if errors.As(err, &se) && (se.HasErrorCode(errCodeHistoryLost) || se.HasErrorCode(errCodeInvalidResumeToken)) {
+		// позиция вытеснена из oplog: пропущенное не вернуть, продолжаем с текущего момента
+		slog.Warn("alert watcher: resume token expired, alerts may have been missed", "consumer", w.consumer, "err", err)
+		pos.Token = nil
+		cs, err = w.alerts.Watch(ctx, pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	}
+	if err != nil {
+		return err
+	}
+	defer cs.Close(context.WithoutCancel(ctx))
+	// сохраняется только позиция после доставленного алерта, недоставленный придёт снова
+	defer func() {
+		if pos.Token != nil {
+			w.savePosition(context.WithoutCancel(ctx), *pos)
+		}
+	}()
+
+	var saved time.Time
+	for cs.Next(ctx) {
+		var event struct {
+			FullDocument alert.Alert `bson:"fullDocument"`
+		}
+		if err := cs.Decode(&event); err != nil {
+			return err
+		}
+		select {
+		case out <- event.FullDocument:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		pos.Token = cs.ResumeToken()
+		if time.Since(saved) >= tokenSaveInterval {
+			if err := w.savePosition(ctx, *pos); err != nil {
+				return err
+			}
+			saved = time.Now()
+		}
+	}
+	return cs.Err()
+}
+
+// poll опрашивает коллекцию по возрастанию _id, а повышенные алерты — по возрастанию
+// raised_at. ObjectID и raised_at растут со временем, но у разных писателей порядок
+// не строгий, поэтому алерт, записанный одновременно с опросом, может быть пропущен —
+// это цена работы без change streams.
+func (w *AlertWatcher) poll(ctx context.Context, filter AlertFilter, pos *watchPosition, out chan<- alert.Alert) error {
+	if pos.LastID.IsZero() {
+		latest, err := w.latestID(ctx)
+		if err != nil {
+			return err
+		}
+		pos.LastID = latest
+	}
+	if pos.RaisedAt.IsZero() {
+		pos.RaisedAt = time.Now().UTC()
+	}
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		q := filter.query("")
+		if !pos.LastID.IsZero() {
+			q["_id"] = bson.M{"$gt": pos.LastID}
+		}
+		inserted, err := w.pollBatch(ctx, q, "_id", pos, out, func(a alert.Alert) { pos.LastID = a.ID })
+		if err != nil {
+			return err
+		}
+
+		// алерты после LastID уже отданы выше вместе с повышением
+		q = filter.query("")
+		q["raised_at"] = bson.M{"$gt": pos.RaisedAt}
+		if !pos.LastID.IsZero() {
+			q["_id"] = bson.M{"$lte": pos.LastID}
+		}
+		raised, err := w.pollBatch(ctx, q, "raised_at", pos, out, func(a alert.Alert) { pos.RaisedAt = *a.RaisedAt })
+		if err != nil {
+			return err
+		}
+		if inserted == watchBatch || raised == watchBatch {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollBatch отдаёт до watchBatch алертов по запросу q в порядке поля sort, сдвигая
+// позицию через advance после каждого, и возвращает их число
+func (w *AlertWatcher) pollBatch(ctx context.Context, q bson.M, sort string, pos *watchPosition, out chan<- alert.Alert, advance func(alert.Alert)) (int, error) {
+	opts := options.Find().SetSort(bson.D{{Key: sort, Value: 1}}).SetLimit(watchBatch)
+	cursor, err := w.alerts.Find(ctx, q, opts)
+	if err != nil {
+		return 0, err
+	}
+	var alerts []alert.Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return 0, err
+	}
+
+	for _, a := range alerts {
+		select {
+		case out <- a:
+		case <-ctx.Done():
+			w.savePosition(context.WithoutCancel(ctx), *pos)
+			return 0, ctx.Err()
+		}
+		advance(a)
+	}
+	if len(alerts) > 0 {
+		if err := w.savePosition(ctx, *pos); err != nil {
+			return 0, err
+		}
+	}
+	return len(alerts), nil
+}
+
+func (w *AlertWatcher) latestID(ctx context.Context) (primitive.ObjectID, error) {
+	var doc struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}}).SetProjection(bson.M{"_id": 1})
+	err := w.alerts.FindOne(ctx, bson.M{}, opts).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return primitive.NilObjectID, nil
+	}
+	return doc.ID, err
+}
+
+func (w *AlertWatcher) position(ctx context.Context) (*watchPosition, error) {
+	var pos watchPosition
+	if w.consumer == "" {
+		return &pos, nil
+	}
+	err := w.tokens.FindOne(ctx, bson.M{"_id": w.consumer}).Decode(&pos)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+func (w *AlertWatcher) savePosition(ctx context.Context, pos watchPosition) error {
+	if w.consumer == "" {
+		return nil
+	}
+	pos.ID = w.consumer
+	pos.UpdatedAt = time.Now().UTC()
+	_, err := w.tokens.ReplaceOne(ctx, bson.M{"_id": w.consumer}, pos, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagation(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := make(map[string]string)
+	Inject(ctx, propagation.MapCarrier(headers))
+	if headers["traceparent"] == "" {
+		t.Fatalf("headers = %v, want traceparent", headers)
+	}
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), propagation.MapCarrier(headers)))
+	if got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted %v, want %v", got, span.SpanContext())
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))).With("service", "test")
+
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "process")
+	defer span.End()
+
+	logger.InfoContext(ctx, "traced")
+	logger.Info("untraced")
+
+	dec := json.NewDecoder(&buf)
+	var traced, untraced map[string]any
+	if err := dec.Decode(&traced); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&untraced); err != nil {
+		t.Fatal(err)
+	}
+	if traced["trace_id"] != span.SpanContext().TraceID().String() || traced["span_id"] != span.SpanContext().SpanID().String() {
+		t.Errorf("traced record = %v", traced)
+	}
+	if traced["service"] != "test" {
+		t.Errorf("WithAttrs lost: %v", traced)
+	}
+	if _, ok := untraced["trace_id"]; ok {
+		t.Errorf("untraced record has trace_id: %v", untraced)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, cfg := range []Config{{Exporter: "jaeger", SampleRatio: 1}, {Exporter: OTLP, SampleRatio: 2}} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", cfg)
+		}
+	}
+	if err := (Config{Exporter: Stdout, SampleRatio: 0.5}).Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package upstream
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	syncedPackets = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "upstream_synced_packets_total",
+			Help: "Total number of packets forwarded to the central controller",
+		},
+	)
+
+	rejectedPackets = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "upstream_rejected_packets_total",
+			Help: "Total number of packets dropped because the central controller rejected them with a non-retryable status",
+		},
+	)
+
+	pendingPackets = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "upstream_pending_packets",
+			Help: "Number of packets waiting to be forwarded to the central controller",
+		},
+	)
+)
+
+// Syncer пересылает накопленные локально пакеты в центральный контроллер.
+// Пакеты отправляются строго по порядку; при ошибке отправка прерывается
+// и возобновляется со следующего тика, когда связь восстановится. Пакет, отвергнутый
+// контроллером с неповторяемым кодом, снимается с очереди: иначе он задержал бы её навсегда.
+type Syncer struct {
+	store    *storage.BoltStore
+	client   sender.PacketSender
+	interval time.Duration
+	batch    int
+}
+
+func NewSyncer(store *storage.BoltStore, client sender.PacketSender, interval time.Duration, batch int) *Syncer {
+	return &Syncer{
+		store:    store,
+		client:   client,
+		interval: interval,
+		batch:    batch,
+	}
+}
+
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	slog.Info("upstream sync started", "interval", s.interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("upstream sync stopped")
+			return
+		case <-ticker.C:
+			s.flush(ctx)
+		}
+	}
+}
+
+func (s *Syncer) flush(ctx context.Context) {
+	defer s.reportPending()
+
+	for ctx.Err() == nil {
+		entries, err := s.store.PendingOutbox(s.batch)
+		if err != nil {
+			slog.Error("read outbox error", "err", err)
+			return
+		}
+		if len(entries) == 0 {
+			return
+		}
+
+		sent := 0
+		for i, entry := range entries {
+			err := s.client.Send(ctx, entry.Packet)
+			if sender.Rejected(err) {
+				rejectedPackets.Inc()
+				slog.Warn("packet rejected upstream, dropping", "device_id", entry.Packet.DeviceID, "seq", entry.Seq, "err", err)
+				continue
+			}
+			if err != nil {
+				slog.Warn("upstream unreachable, will retry", "err", err)
+				if i > 0 {
+					s.ack(entries[i-1].Seq, sent)
+				}
+				return
+			}
+			sent++
+		}
+		s.ack(entries[len(entries)-1].Seq, sent)
+	}
+}
+
+func (s *Syncer) ack(seq uint64, n int) {
+	if err := s.store.AckOutbox(seq); err != nil {
+		slog.Error("ack outbox error", "err", err)
+		return
+	}
+	if n == 0 {
+		return
+	}
+	syncedPackets.Add(float64(n))
+	slog.Info("packets forwarded upstream", "count", n)
+}
+
+func (s *Syncer) reportPending() {
+	n, err := s.store.OutboxLen()
+	if err != nil {
+		return
+	}
+	pendingPackets.Set(float64(n))
+}
@@ -0,0 +1,53 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pochkachaiki/iot4gds/internal/models/packet"
+	"github.com/pochkachaiki/iot4gds/internal/sender"
+	"github.com/pochkachaiki/iot4gds/internal/storage"
+)
+
+func TestSyncerDropsRejected(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.OpenBoltStore(filepath.Join(t.TempDir(), "test.db"), true)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer store.Close()
+	for i := 1; i <= 4; i++ {
+		store.InsertPacket(ctx, packet.Packet{DeviceID: i, Timestamp: fmt.Sprintf("2025-01-01T00:00:0%dZ", i)})
+	}
+
+	down := true
+	var sent []int
+	client := sender.SenderFunc(func(_ context.Context, p packet.Packet) error {
+		switch {
+		case p.DeviceID == 2:
+			return &sender.StatusError{Code: http.StatusBadRequest}
+		case down && p.DeviceID == 4:
+			return errors.New("connection refused")
+		}
+		sent = append(sent, p.DeviceID)
+		return nil
+	})
+	s := NewSyncer(store, client, 0, 10)
+
+	// отвергнутый пакет снимается, недоступность останавливает отправку
+	s.flush(ctx)
+	if n, _ := store.OutboxLen(); n != 1 || !slices.Equal(sent, []int{1, 3}) {
+		t.Fatalf("sent %v, outbox len %d; want [1 3] and the unreachable packet kept", sent, n)
+	}
+
+	down = false
+	s.flush(ctx)
+	if n, _ := store.OutboxLen(); n != 0 || !slices.Equal(sent, []int{1, 3, 4}) {
+		t.Errorf("sent %v, outbox len %d; want [1 3 4] and empty outbox", sent, n)
+	}
+}